@@ -0,0 +1,419 @@
+package btree
+
+import "sort"
+
+//批量操作的类型
+type OpKind int
+
+const (
+	OpInsert OpKind = iota //插入或者替换Item，等价于ReplaceOrInsert
+	OpDelete               //删除Item，等价于Delete
+	OpUpsert               //根据Fn的返回值插入、替换或删除Item
+)
+
+//Op是ApplyBatch中的一个操作
+//对于OpUpsert，Item是用来定位的key，Fn的返回值必须和key相等（即互相都不Less）
+//Fn返回false时，已经存在的item会被删除，不存在时什么也不做
+type Op struct {
+	Kind OpKind
+	Item Item
+	Fn   UpsertFunc
+}
+
+//BatchResult按照ops中的顺序记录每一个op的结果
+//Replaced[i]是第i个op替换掉的item，Removed[i]是第i个op删除的item，没有的时候为nil
+type BatchResult struct {
+	Replaced []Item
+	Removed  []Item
+}
+
+//带有原始位置的op
+type batchOp struct {
+	index int
+	op    Op
+	purge bool //内部产生的删除操作，不记录结果
+}
+
+//一次批量操作的上下文
+type batchContext struct {
+	result   BatchResult
+	delta    int //tree长度的变化
+	maxItems int
+	minItems int
+
+	observed bool     //tree是否有Observer，有的时候才记录changes
+	changes  []change //记录的变化，节点中的item先于子节点中的item记录，通知之前会排序
+}
+
+//ApplyBatch将ops一次性应用到tree中。如果ops不是按照Item排好序的，会先进行稳定排序，
+//相同key的op按照它们在ops中的顺序依次生效。
+//和逐个调用ReplaceOrInsert/Delete不同，ApplyBatch只从root向下遍历一次，把ops分发到各个子树，
+//然后在返回的路上拆分过大的节点、合并过小的节点。
+func (t *BTree) ApplyBatch(ops []Op) BatchResult {
+	c := &batchContext{
+		result: BatchResult{
+			Replaced: make([]Item, len(ops)),
+			Removed:  make([]Item, len(ops)),
+		},
+		maxItems: t.maxItems(),
+		minItems: t.minItems(),
+		observed: len(t.observers) > 0,
+	}
+	if len(ops) == 0 {
+		return c.result
+	}
+	sorted := make([]batchOp, len(ops))
+	needSort := false
+	for i, op := range ops {
+		if op.Item == nil {
+			panic("nil item in batch")
+		}
+		if op.Kind == OpUpsert && op.Fn == nil {
+			panic("nil upsert func in batch")
+		}
+		sorted[i] = batchOp{index: i, op: op}
+		if i > 0 && op.Item.Less(ops[i-1].Item) {
+			needSort = true
+		}
+	}
+	if needSort {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].op.Item.Less(sorted[j].op.Item)
+		})
+	}
+
+	if t.root == nil {
+		t.root = t.cow.newNode()
+	}
+	t.root = t.root.mutableFor(t.cow)
+	t.root.applyBatch(sorted, c)
+	t.rebalanceRoot()
+	t.length += c.delta
+	sort.Slice(c.changes, func(i, j int) bool { return c.changes[i].key().Less(c.changes[j].key()) })
+	t.notifyAll(c.changes)
+	return c.result
+}
+
+//批量修改之后调整root：root过大时不断向上增加层级，root为空时降低层级
+func (t *BTree) rebalanceRoot() {
+	for len(t.root.items) > t.maxItems() {
+		oldRoot := t.root
+		t.root = t.cow.newNode()
+		t.root.children = append(t.root.children, oldRoot)
+		t.root.splitOversizedChild(0, t.maxItems())
+		t.root.recount()
+	}
+	for len(t.root.items) == 0 && len(t.root.children) > 0 {
+		oldRoot := t.root
+		t.root = t.root.children[0]
+		t.cow.freeNode(oldRoot)
+	}
+}
+
+//DeleteFunc删除[greaterOrEqual, lessThan)范围内所有pred返回true的item，并返回删除的个数。
+//greaterOrEqual或lessThan为nil时表示这一边没有边界。
+//整个范围只遍历一次，受影响的节点在返回的路上原地合并或拆分，而不是每删除一个item都从root重新向下查找。
+//pred对范围内的每个item只调用一次，但调用的顺序不保证是升序，pred中不能修改tree。
+func (t *BTree) DeleteFunc(greaterOrEqual, lessThan Item, pred func(Item) bool) int {
+	if t.root == nil || len(t.root.items) == 0 {
+		return 0
+	}
+	c := &deleteFuncContext{
+		ge:       greaterOrEqual,
+		lt:       lessThan,
+		pred:     pred,
+		maxItems: t.maxItems(),
+		minItems: t.minItems(),
+		observed: len(t.observers) > 0,
+	}
+	t.root = t.root.mutableFor(t.cow)
+	t.root.deleteFunc(nil, c)
+	t.rebalanceRoot()
+	t.length -= c.removed
+	sort.Slice(c.deleted, func(i, j int) bool { return c.deleted[i].Less(c.deleted[j]) })
+	for _, item := range c.deleted {
+		t.notify(changeDelete, item, nil)
+	}
+	return c.removed
+}
+
+//一次DeleteFunc的上下文
+type deleteFuncContext struct {
+	ge, lt   Item
+	pred     func(Item) bool
+	removed  int
+	maxItems int
+	minItems int
+
+	observed bool   //tree是否有Observer，有的时候才记录deleted
+	deleted  []Item //被删除的item，调用pred的顺序不是升序，通知之前会排序
+}
+
+//item是否在[ge, lt)范围内
+func (c *deleteFuncContext) contains(item Item) bool {
+	return (c.ge == nil || !item.Less(c.ge)) && (c.lt == nil || item.Less(c.lt))
+}
+
+//判断item是否需要删除，purge是已经确定要删除的、不小于item的item（升序），返回剩下的purge
+func (c *deleteFuncContext) shouldDelete(item Item, purge []Item) (bool, []Item) {
+	if len(purge) > 0 && !item.Less(purge[0]) {
+		return true, purge[1:]
+	}
+	if c.contains(item) && c.pred(item) {
+		c.removed++
+		if c.observed {
+			c.deleted = append(c.deleted, item)
+		}
+		return true, purge
+	}
+	return false, purge
+}
+
+//在以n为根的子树上执行DeleteFunc，n必须已经是可变的
+//purge是上层已经调用过pred、确定要删除并合并到这棵子树中的item
+func (n *node) deleteFunc(purge []Item, c *deleteFuncContext) {
+	var del bool
+	if len(n.children) == 0 {
+		j := 0
+		for _, item := range n.items {
+			if del, purge = c.shouldDelete(item, purge); !del {
+				n.items[j] = item
+				j++
+			}
+		}
+		n.items.truncate(j)
+		n.size = j
+		return
+	}
+	//当前节点中要删除的item连同两边的子节点一起合并到下一层，交给子节点删除
+	var deletes []Item
+	rest := make([]Item, 0, len(purge))
+	for _, item := range n.items {
+		for len(purge) > 0 && purge[0].Less(item) {
+			rest = append(rest, purge[0])
+			purge = purge[1:]
+		}
+		if del, purge = c.shouldDelete(item, purge); del {
+			deletes = append(deletes, item)
+		}
+	}
+	rest = append(rest, purge...)
+	for _, item := range deletes {
+		i, _ := n.find(item)
+		n.mergeChild(i)
+	}
+	if len(deletes) > 0 {
+		rest = mergeSortedItems(rest, deletes)
+	}
+	for i := range n.children {
+		var lo, hi Item
+		if i > 0 {
+			lo = n.items[i-1]
+		}
+		if i < len(n.items) {
+			hi = n.items[i]
+		}
+		//属于children[i]的purge
+		j := len(rest)
+		if hi != nil {
+			j = sort.Search(len(rest), func(k int) bool { return !rest[k].Less(hi) })
+		}
+		childPurge := rest[:j]
+		rest = rest[j:]
+		//和[ge, lt)没有交集并且没有purge的子树不需要访问
+		if len(childPurge) == 0 &&
+			((hi != nil && c.ge != nil && !c.ge.Less(hi)) || (lo != nil && c.lt != nil && !lo.Less(c.lt))) {
+			continue
+		}
+		n.mutableChild(i).deleteFunc(childPurge, c)
+	}
+	n.rebalanceChildren(c.maxItems, c.minItems)
+	n.recount()
+}
+
+//归并两个升序的item列表
+func mergeSortedItems(a, b []Item) []Item {
+	out := make([]Item, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].Less(a[0]) {
+			out = append(out, b[0])
+			b = b[1:]
+		} else {
+			out = append(out, a[0])
+			a = a[1:]
+		}
+	}
+	out = append(out, a...)
+	return append(out, b...)
+}
+
+//依次对当前的item执行相同key的ops，返回最终的item，被删除时返回nil
+func (c *batchContext) resolve(ops []batchOp, cur Item) Item {
+	if ops[0].purge {
+		return nil
+	}
+	existed, orig := cur != nil, cur
+	for _, b := range ops {
+		switch b.op.Kind {
+		case OpInsert:
+			c.result.Replaced[b.index] = cur
+			cur = b.op.Item
+		case OpDelete:
+			c.result.Removed[b.index] = cur
+			cur = nil
+		case OpUpsert:
+			out, keep := b.op.Fn(cur, cur != nil)
+			if keep {
+				if out == nil {
+					panic("nil item returned from upsert func")
+				}
+				c.result.Replaced[b.index] = cur
+				cur = out
+			} else {
+				c.result.Removed[b.index] = cur
+				cur = nil
+			}
+		default:
+			panic("invalid op kind")
+		}
+	}
+	switch {
+	case existed && cur == nil:
+		c.delta--
+	case !existed && cur != nil:
+		c.delta++
+	}
+	if c.observed {
+		//同一个key上的多个op只通知最终的结果
+		switch {
+		case existed && cur == nil:
+			c.changes = append(c.changes, change{kind: changeDelete, old: orig})
+		case !existed && cur != nil:
+			c.changes = append(c.changes, change{kind: changeInsert, new: cur})
+		case existed && cur != nil:
+			c.changes = append(c.changes, change{kind: changeReplace, old: orig, new: cur})
+		}
+	}
+	return cur
+}
+
+//返回ops中和ops[0]的key相等的op的个数
+func sameKey(ops []batchOp) int {
+	key := ops[0].op.Item
+	j := 1
+	for j < len(ops) && !key.Less(ops[j].op.Item) {
+		j++
+	}
+	return j
+}
+
+//将排好序的ops应用到以n为根的子树上，n必须已经是可变的
+//返回之后n的所有子节点都满足大小的限制，但n本身可能过大或过小，需要由父节点来调整
+func (n *node) applyBatch(ops []batchOp, c *batchContext) {
+	if len(n.children) == 0 {
+		n.applyBatchLeaf(ops, c)
+		return
+	}
+	//先处理和当前节点中的item相等的ops
+	//需要删除的item连同两边的子节点一起合并到下一层，由一个purge op在叶子节点中删除
+	rest := make([]batchOp, 0, len(ops))
+	for len(ops) > 0 {
+		k := sameKey(ops)
+		if i, found := n.find(ops[0].op.Item); found {
+			if out := c.resolve(ops[:k], n.items[i]); out != nil {
+				n.items[i] = out
+			} else {
+				key := n.items[i]
+				n.mergeChild(i)
+				rest = append(rest, batchOp{op: Op{Kind: OpDelete, Item: key}, purge: true})
+			}
+		} else {
+			rest = append(rest, ops[:k]...)
+		}
+		ops = ops[k:]
+	}
+	ops = rest
+	//小于items[i]的ops都属于children[i]
+	for i := 0; i <= len(n.items) && len(ops) > 0; i++ {
+		j := len(ops)
+		if i < len(n.items) {
+			sep := n.items[i]
+			j = sort.Search(len(ops), func(k int) bool {
+				return !ops[k].op.Item.Less(sep)
+			})
+		}
+		if j > 0 {
+			n.mutableChild(i).applyBatch(ops[:j], c)
+			ops = ops[j:]
+		}
+	}
+	n.rebalanceChildren(c.maxItems, c.minItems)
+	n.recount()
+}
+
+//将ops和叶子节点中的items归并
+func (n *node) applyBatchLeaf(ops []batchOp, c *batchContext) {
+	out := make(items, 0, len(n.items)+len(ops))
+	i := 0
+	for len(ops) > 0 {
+		key := ops[0].op.Item
+		for i < len(n.items) && n.items[i].Less(key) {
+			out = append(out, n.items[i])
+			i++
+		}
+		var cur Item
+		if i < len(n.items) && !key.Less(n.items[i]) {
+			cur = n.items[i]
+			i++
+		}
+		k := sameKey(ops)
+		if final := c.resolve(ops[:k], cur); final != nil {
+			out = append(out, final)
+		}
+		ops = ops[k:]
+	}
+	out = append(out, n.items[i:]...)
+	n.items = out
+	n.size = len(out)
+}
+
+//调整n的子节点，使每一个子节点的items数都在[minItems, maxItems]之间
+//过小的子节点和相邻的子节点合并，过大的子节点拆分成若干个节点
+func (n *node) rebalanceChildren(maxItems, minItems int) {
+	for i := 0; i < len(n.children); {
+		size := len(n.children[i].items)
+		switch {
+		case size < minItems && len(n.children) > 1:
+			if i == len(n.children)-1 {
+				i--
+			}
+			//合并之后重新检查合并后的节点
+			//被合并的节点可能只剩下一个子节点，它在下一层没有办法调整，所以这里还要调整合并后节点的子节点
+			n.mergeChild(i).rebalanceChildren(maxItems, minItems)
+		case size > maxItems:
+			i += n.splitOversizedChild(i, maxItems)
+		default:
+			i++
+		}
+	}
+}
+
+//将过大的子节点i拆分成若干个大小接近的节点，返回拆分后的节点数
+func (n *node) splitOversizedChild(i, maxItems int) int {
+	child := n.mutableChild(i)
+	size := len(child.items)
+	//每个节点最多maxItems个item，节点之间还需要一个item放到n中
+	pieces := (size + maxItems + 1) / (maxItems + 1)
+	per, extra := (size-(pieces-1))/pieces, (size-(pieces-1))%pieces
+	//从右往左依次切下
+	for p := pieces - 1; p > 0; p-- {
+		length := per
+		if p < extra {
+			length++
+		}
+		item, next := child.split(len(child.items) - length - 1)
+		n.items.insertAt(i, item)
+		n.children.insertAt(i+1, next)
+	}
+	return pieces
+}
@@ -0,0 +1,235 @@
+package btree
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//verifyTree检查tr中每个节点的大小、顺序和深度
+func verifyTree(t *testing.T, tr *BTree) {
+	t.Helper()
+	if tr.root == nil {
+		if tr.Len() != 0 {
+			t.Fatalf("nil root with length %d", tr.Len())
+		}
+		return
+	}
+	leafDepth := -1
+	count := 0
+	var walk func(n *node, depth int, lo, hi Item)
+	walk = func(n *node, depth int, lo, hi Item) {
+		if n != tr.root && (len(n.items) < tr.minItems() || len(n.items) > tr.maxItems()) {
+			t.Fatalf("node size %d outside [%d, %d]", len(n.items), tr.minItems(), tr.maxItems())
+		}
+		for i, item := range n.items {
+			if (lo != nil && !lo.Less(item)) || (hi != nil && !item.Less(hi)) {
+				t.Fatalf("item %v outside (%v, %v)", item, lo, hi)
+			}
+			if i > 0 && !n.items[i-1].Less(item) {
+				t.Fatalf("items out of order: %v", n.items)
+			}
+		}
+		count += len(n.items)
+		size := len(n.items)
+		for _, child := range n.children {
+			size += child.size
+		}
+		if n.size != size {
+			t.Fatalf("node size field %d, counted %d", n.size, size)
+		}
+		if len(n.children) == 0 {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				t.Fatalf("leaf at depth %d, want %d", depth, leafDepth)
+			}
+			return
+		}
+		if len(n.children) != len(n.items)+1 {
+			t.Fatalf("%d children for %d items", len(n.children), len(n.items))
+		}
+		for i, child := range n.children {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = n.items[i-1]
+			}
+			if i < len(n.items) {
+				chi = n.items[i]
+			}
+			walk(child, depth+1, clo, chi)
+		}
+	}
+	walk(tr.root, 0, nil, nil)
+	if count != tr.Len() {
+		t.Fatalf("counted %d items, Len() is %d", count, tr.Len())
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	for _, degree := range []int{2, 3, 8, *btreeDegree} {
+		tr := New(degree)
+		want := map[Int]bool{}
+		for round := 0; round < 20; round++ {
+			ops := make([]Op, 0, 500)
+			for i := 0; i < 500; i++ {
+				v := Int(rand.Intn(2000))
+				switch rand.Intn(3) {
+				case 0:
+					ops = append(ops, Op{Kind: OpInsert, Item: v})
+				case 1:
+					ops = append(ops, Op{Kind: OpDelete, Item: v})
+				default:
+					ops = append(ops, Op{Kind: OpUpsert, Item: v, Fn: func(old Item, exists bool) (Item, bool) {
+						return v, !exists
+					}})
+				}
+			}
+			if round%2 == 0 {
+				sort.SliceStable(ops, func(i, j int) bool { return ops[i].Item.Less(ops[j].Item) })
+			}
+			clone := tr.Clone()
+			before := all(clone)
+
+			res := tr.ApplyBatch(ops)
+			for i, op := range ops {
+				v := op.Item.(Int)
+				var replaced, removed Item
+				switch op.Kind {
+				case OpInsert:
+					if want[v] {
+						replaced = v
+					}
+					want[v] = true
+				case OpDelete:
+					if want[v] {
+						removed = v
+					}
+					delete(want, v)
+				case OpUpsert:
+					if want[v] {
+						removed = v
+						delete(want, v)
+					} else {
+						want[v] = true
+					}
+				}
+				if res.Replaced[i] != replaced || res.Removed[i] != removed {
+					t.Fatalf("op %d %+v: got replaced=%v removed=%v, want %v %v",
+						i, op, res.Replaced[i], res.Removed[i], replaced, removed)
+				}
+			}
+			verifyTree(t, tr)
+			var expect []Item
+			for v := range want {
+				expect = append(expect, v)
+			}
+			sort.Sort(byInts(expect))
+			if got := all(tr); !reflect.DeepEqual(got, expect) {
+				t.Fatalf("degree %d round %d mismatch:\n got: %v\nwant: %v", degree, round, got, expect)
+			}
+			if got := all(clone); !reflect.DeepEqual(got, before) {
+				t.Fatalf("batch modified clone")
+			}
+		}
+	}
+}
+
+func TestApplyBatchEmptyAndBulk(t *testing.T) {
+	tr := New(3)
+	if res := tr.ApplyBatch(nil); len(res.Replaced) != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	var ops []Op
+	for _, v := range perm(1000) {
+		ops = append(ops, Op{Kind: OpInsert, Item: v})
+	}
+	tr.ApplyBatch(ops)
+	verifyTree(t, tr)
+	if got, want := all(tr), rang(1000); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	for i := range ops {
+		ops[i].Kind = OpDelete
+	}
+	tr.ApplyBatch(ops)
+	verifyTree(t, tr)
+	if tr.Len() != 0 || tr.Min() != nil {
+		t.Fatalf("tree not empty: %v", all(tr))
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	for _, degree := range []int{2, 3, 8, *btreeDegree} {
+		for _, mod := range []int{1, 2, 3, 7} {
+			tr := New(degree)
+			for _, v := range perm(1000) {
+				tr.ReplaceOrInsert(v)
+			}
+			clone := tr.Clone()
+			seen := map[Item]int{}
+			n := tr.DeleteFunc(Int(100), Int(900), func(a Item) bool {
+				seen[a]++
+				return int(a.(Int))%mod == 0
+			})
+			var want []Item
+			removed := 0
+			for i := 0; i < 1000; i++ {
+				if i >= 100 && i < 900 {
+					if seen[Int(i)] != 1 {
+						t.Fatalf("pred called %d times for %d", seen[Int(i)], i)
+					}
+					if i%mod == 0 {
+						removed++
+						continue
+					}
+				}
+				want = append(want, Int(i))
+			}
+			if len(seen) != 800 {
+				t.Fatalf("pred called for %d items, want 800", len(seen))
+			}
+			if n != removed {
+				t.Fatalf("removed: want %d, got %d", removed, n)
+			}
+			verifyTree(t, tr)
+			if got := all(tr); !reflect.DeepEqual(got, want) {
+				t.Fatalf("degree %d mod %d mismatch:\n got: %v\nwant: %v", degree, mod, got, want)
+			}
+			if got := all(clone); !reflect.DeepEqual(got, rang(1000)) {
+				t.Fatalf("DeleteFunc modified clone")
+			}
+		}
+	}
+}
+
+func TestDeleteFuncUnbounded(t *testing.T) {
+	tr := New(3)
+	for _, v := range perm(500) {
+		tr.ReplaceOrInsert(v)
+	}
+	if n := tr.DeleteFunc(nil, nil, func(Item) bool { return true }); n != 500 {
+		t.Fatalf("removed: want 500, got %d", n)
+	}
+	verifyTree(t, tr)
+	if tr.Len() != 0 || tr.Min() != nil {
+		t.Fatalf("tree not empty: %v", all(tr))
+	}
+	if n := tr.DeleteFunc(nil, nil, func(Item) bool { return true }); n != 0 {
+		t.Fatalf("removed %d from empty tree", n)
+	}
+}
+
+func BenchmarkApplyBatch(b *testing.B) {
+	insertP := perm(benchmarkTreeSize)
+	ops := make([]Op, len(insertP))
+	for i, v := range insertP {
+		ops[i] = Op{Kind: OpInsert, Item: v}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr := New(*btreeDegree)
+		tr.ApplyBatch(ops)
+	}
+}
@@ -0,0 +1,566 @@
+package btree
+
+//BPlusTree是B+Tree的一个实现：所有的item都存储在叶子节点中，内部节点只存储用来分隔子节点的key，
+//叶子节点按顺序双向链接，范围遍历找到起点之后只需要沿着叶子节点的链表顺序访问。
+//
+//BPlusTree和BTree一样支持写时复制的Clone。Clone之后叶子节点被两棵树共享，共享的节点不能再修改，
+//所以一棵树复制一个共享的叶子节点之后，共享的邻居仍然链接着原来的节点。
+//为此链接只在拥有节点的树中维护：两个叶子节点都属于这棵树的写上下文时，它们之间的链接一定有效；
+//Clone之后、下一次修改之前，Clone时的叶子节点之间的链接对两棵树也都有效。
+//遍历时能沿着有效的链接走就沿着链接走，遇到不能确定的链接时才通过父节点路径找到下一个叶子节点。
+type BPlusTree struct {
+	degree int
+	length int
+	root   *bpNode
+	cow    *bpContext
+	frozen *bpContext //上一次Clone之前的写上下文，Clone之后还没有修改过时不为nil，它的叶子节点之间的链接仍然有效
+}
+
+//BPlusTree的写时复制上下文，和copyOnWriteContext一样用指针来确定节点的所有权
+type bpContext struct {
+	_ int //保证每次分配的地址都不同
+}
+
+//BPlusTree的节点
+type bpNode struct {
+	keys     items     //叶子节点中是item，内部节点中是分隔key：children[i]中的item都小于keys[i]，children[i+1]中的item都不小于keys[i]
+	children []*bpNode //子节点，叶子节点为空
+	prev     *bpNode   //前一个叶子节点
+	next     *bpNode   //后一个叶子节点
+	cow      *bpContext
+}
+
+//根据给定degree来生成一个BPlusTree，每个节点最多有2*degree-1个key
+func NewBPlusTree(degree int) *BPlusTree {
+	if degree <= 1 {
+		panic("bad degree")
+	}
+	return &BPlusTree{degree: degree, cow: &bpContext{}}
+}
+
+//是否是叶子节点
+func (n *bpNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+//和node.mutableFor一样，节点不属于cow时复制一份
+func (n *bpNode) mutableFor(cow *bpContext) *bpNode {
+	if n.cow == cow {
+		return n
+	}
+	out := &bpNode{cow: cow, prev: n.prev, next: n.next}
+	out.keys = append(make(items, 0, cap(n.keys)), n.keys...)
+	if len(n.children) > 0 {
+		out.children = append(make([]*bpNode, 0, cap(n.children)), n.children...)
+	}
+	return out
+}
+
+func (n *bpNode) mutableChild(i int) *bpNode {
+	c := n.children[i].mutableFor(n.cow)
+	n.children[i] = c
+	return c
+}
+
+//key所在的子节点
+func (n *bpNode) childIndex(key Item) int {
+	i, found := n.keys.find(key)
+	if found {
+		//和分隔key相等的item在右边的子节点中
+		i++
+	}
+	return i
+}
+
+//在children的位置i插入子节点
+func (n *bpNode) insertChild(i int, child *bpNode) {
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+//删除位置i的子节点
+func (n *bpNode) removeChild(i int) *bpNode {
+	child := n.children[i]
+	copy(n.children[i:], n.children[i+1:])
+	n.children[len(n.children)-1] = nil
+	n.children = n.children[:len(n.children)-1]
+	return child
+}
+
+//将过大的子节点i拆分成两个节点
+//叶子节点之间的链接由BPlusTree.relink在修改之后统一维护
+func (n *bpNode) splitChild(i int) {
+	child := n.mutableChild(i)
+	right := &bpNode{cow: n.cow}
+	mid := len(child.keys) / 2
+	var sep Item
+	if child.leaf() {
+		//叶子节点拆分时，右边节点的第一个item作为分隔key
+		right.keys = append(right.keys, child.keys[mid:]...)
+		child.keys.truncate(mid)
+		sep = right.keys[0]
+	} else {
+		//内部节点拆分时，中间的key移动到父节点
+		sep = child.keys[mid]
+		right.keys = append(right.keys, child.keys[mid+1:]...)
+		right.children = append(right.children, child.children[mid+1:]...)
+		child.keys.truncate(mid)
+		for j := mid + 1; j < len(child.children); j++ {
+			child.children[j] = nil
+		}
+		child.children = child.children[:mid+1]
+	}
+	n.keys.insertAt(i, sep)
+	n.insertChild(i+1, right)
+}
+
+//在以此节点为根节点的子树上插入item，返回被替换的item
+func (n *bpNode) insert(item Item, t *BPlusTree) Item {
+	if n.leaf() {
+		i, found := n.keys.find(item)
+		if found {
+			out := n.keys[i]
+			n.keys[i] = item
+			return out
+		}
+		n.keys.insertAt(i, item)
+		return nil
+	}
+	i := n.childIndex(item)
+	child := n.mutableChild(i)
+	out := child.insert(item, t)
+	if len(child.keys) > t.maxItems() {
+		n.splitChild(i)
+	}
+	return out
+}
+
+//在以此节点为根节点的子树上删除item，返回被删除的item
+func (n *bpNode) remove(item Item, t *BPlusTree) Item {
+	if n.leaf() {
+		i, found := n.keys.find(item)
+		if !found {
+			return nil
+		}
+		return n.keys.removeAt(i)
+	}
+	i := n.childIndex(item)
+	child := n.mutableChild(i)
+	out := child.remove(item, t)
+	if len(child.keys) < t.minItems() {
+		n.rebalanceChild(i, t.minItems())
+	}
+	return out
+}
+
+//子节点i过小时，从兄弟节点借一个key，或者和兄弟节点合并
+func (n *bpNode) rebalanceChild(i, minItems int) {
+	child := n.mutableChild(i)
+	switch {
+	case i > 0 && len(n.children[i-1].keys) > minItems:
+		left := n.mutableChild(i - 1)
+		if child.leaf() {
+			child.keys.insertAt(0, left.keys.pop())
+			n.keys[i-1] = child.keys[0]
+		} else {
+			child.keys.insertAt(0, n.keys[i-1])
+			n.keys[i-1] = left.keys.pop()
+			last := left.children[len(left.children)-1]
+			left.children[len(left.children)-1] = nil
+			left.children = left.children[:len(left.children)-1]
+			child.insertChild(0, last)
+		}
+	case i < len(n.keys) && len(n.children[i+1].keys) > minItems:
+		right := n.mutableChild(i + 1)
+		if child.leaf() {
+			child.keys = append(child.keys, right.keys.removeAt(0))
+			n.keys[i] = right.keys[0]
+		} else {
+			child.keys = append(child.keys, n.keys[i])
+			n.keys[i] = right.keys.removeAt(0)
+			child.children = append(child.children, right.removeChild(0))
+		}
+	default:
+		if i == len(n.keys) {
+			i--
+		}
+		n.mergeChild(i)
+	}
+}
+
+//将子节点i+1合并到子节点i中
+func (n *bpNode) mergeChild(i int) {
+	left := n.mutableChild(i)
+	right := n.children[i+1]
+	sep := n.keys.removeAt(i)
+	n.removeChild(i + 1)
+	if left.leaf() {
+		left.keys = append(left.keys, right.keys...)
+		return
+	}
+	left.keys = append(left.keys, sep)
+	left.keys = append(left.keys, right.keys...)
+	left.children = append(left.children, right.children...)
+}
+
+//maxItems返回每个node允许的最大key数
+func (t *BPlusTree) maxItems() int {
+	return t.degree*2 - 1
+}
+
+//minItems返回每个node允许的最小key数
+func (t *BPlusTree) minItems() int {
+	return t.degree - 1
+}
+
+//Clone和BTree.Clone一样是延迟clone，两棵树共享现有的节点，写的时候再复制
+//Clone时的叶子节点之间的链接在每棵树下一次修改之前都仍然可以使用，见BPlusTree的说明
+func (t *BPlusTree) Clone() *BPlusTree {
+	if t.frozen == nil {
+		//上一次Clone之后修改过，t.cow拥有的叶子节点之间的链接都是有效的
+		t.frozen = t.cow
+	}
+	out := *t
+	t.cow, out.cow = &bpContext{}, &bpContext{}
+	return &out
+}
+
+//两个相邻的叶子节点之间的链接在这棵树中是否一定有效
+func (t *BPlusTree) trusted(a, b *bpNode) bool {
+	return b != nil && a.cow == b.cow && (a.cow == t.cow || a.cow == t.frozen)
+}
+
+//修改key附近的叶子节点之后重新链接它们
+//一次修改只会复制、拆分或者合并key所在的叶子节点和它两边各两个以内的叶子节点，
+//所以只需要通过父节点路径找到这个范围内的叶子节点，把属于这棵树的节点和它的邻居链接起来。
+func (t *BPlusTree) relink(key Item) {
+	t.frozen = nil
+	if t.root == nil {
+		return
+	}
+	c := &bpCursor{t: t}
+	c.seekLeaf(key)
+	//两边各找两个叶子节点，到头的时候用nil表示
+	var before, after []*bpNode
+	for b := c.copy(); len(before) < 2; {
+		if !b.stepPath(descend) {
+			before = append(before, nil)
+			break
+		}
+		before = append(before, b.leaf)
+	}
+	for a := c.copy(); len(after) < 2; {
+		if !a.stepPath(ascend) {
+			after = append(after, nil)
+			break
+		}
+		after = append(after, a.leaf)
+	}
+	leaves := make([]*bpNode, 0, len(before)+1+len(after))
+	for i := len(before) - 1; i >= 0; i-- {
+		leaves = append(leaves, before[i])
+	}
+	leaves = append(leaves, c.leaf)
+	leaves = append(leaves, after...)
+	for i := 1; i < len(leaves); i++ {
+		prev, next := leaves[i-1], leaves[i]
+		if prev != nil && prev.cow == t.cow {
+			prev.next = next
+		}
+		if next != nil && next.cow == t.cow {
+			next.prev = prev
+		}
+	}
+}
+
+//ReplaceOrInsert将给定的item加入到tree中，如果已经存在相等的item，就替换它并返回原来的item
+func (t *BPlusTree) ReplaceOrInsert(item Item) Item {
+	if item == nil {
+		panic("nil item being added to BPlusTree")
+	}
+	if t.root == nil {
+		t.root = &bpNode{cow: t.cow}
+	}
+	t.root = t.root.mutableFor(t.cow)
+	out := t.root.insert(item, t)
+	if len(t.root.keys) > t.maxItems() {
+		oldRoot := t.root
+		t.root = &bpNode{cow: t.cow, children: []*bpNode{oldRoot}}
+		t.root.splitChild(0)
+	}
+	t.relink(item)
+	if out == nil {
+		t.length++
+	}
+	return out
+}
+
+//将给定的item在tree中删除，并把它返回。如果不存在给定的item就返回nil
+//先确认item存在再向下删除，避免删除不存在的item时产生不必要的复制
+func (t *BPlusTree) Delete(item Item) Item {
+	if t.root == nil || t.getFrom(t.root, item) == nil {
+		return nil
+	}
+	t.root = t.root.mutableFor(t.cow)
+	out := t.root.remove(item, t)
+	if len(t.root.keys) == 0 && !t.root.leaf() {
+		t.root = t.root.children[0]
+	}
+	t.relink(item)
+	if out != nil {
+		t.length--
+	}
+	return out
+}
+
+//在以n为根的子树中查找key
+func (t *BPlusTree) getFrom(n *bpNode, key Item) Item {
+	for !n.leaf() {
+		n = n.children[n.childIndex(key)]
+	}
+	if i, found := n.keys.find(key); found {
+		return n.keys[i]
+	}
+	return nil
+}
+
+//在tree中查找指定的key
+func (t *BPlusTree) Get(key Item) Item {
+	if t.root == nil {
+		return nil
+	}
+	return t.getFrom(t.root, key)
+}
+
+//key是否在tree中
+func (t *BPlusTree) Has(key Item) bool {
+	return t.Get(key) != nil
+}
+
+//当前tree的长度
+func (t *BPlusTree) Len() int {
+	return t.length
+}
+
+//返回tree中最小的item
+func (t *BPlusTree) Min() Item {
+	c := t.seekEdge(ascend)
+	if c == nil {
+		return nil
+	}
+	return c.item()
+}
+
+//返回tree中最大的item
+func (t *BPlusTree) Max() Item {
+	c := t.seekEdge(descend)
+	if c == nil {
+		return nil
+	}
+	return c.item()
+}
+
+//叶子节点上的游标，链接有效时沿着链接移动，否则用stack中记录的父节点路径寻找相邻的叶子节点
+type bpCursor struct {
+	t     *BPlusTree
+	stack []bpFrame
+	stale bool //沿着链接移动过，stack不再是当前叶子节点的路径
+	leaf  *bpNode
+	pos   int
+}
+
+//路径上的一个内部节点，以及走向的子节点
+type bpFrame struct {
+	n *bpNode
+	i int
+}
+
+func (c *bpCursor) item() Item {
+	return c.leaf.keys[c.pos]
+}
+
+//复制一个游标，两个游标之后可以独立移动
+func (c *bpCursor) copy() *bpCursor {
+	return &bpCursor{t: c.t, stack: append([]bpFrame(nil), c.stack...), stale: c.stale, leaf: c.leaf, pos: c.pos}
+}
+
+//从root开始走到key所在的叶子节点，并记录路径
+func (c *bpCursor) seekLeaf(key Item) {
+	c.stack = c.stack[:0]
+	c.stale = false
+	n := c.t.root
+	for !n.leaf() {
+		i := n.childIndex(key)
+		c.stack = append(c.stack, bpFrame{n, i})
+		n = n.children[i]
+	}
+	c.leaf = n
+}
+
+//从n开始沿着第一个（dir为descend时是最后一个）子节点向下走到叶子节点
+func (c *bpCursor) descendEdge(n *bpNode, dir direction) {
+	for !n.leaf() {
+		i := 0
+		if dir == descend {
+			i = len(n.children) - 1
+		}
+		c.stack = append(c.stack, bpFrame{n, i})
+		n = n.children[i]
+	}
+	c.leaf = n
+	c.pos = 0
+	if dir == descend {
+		c.pos = len(n.keys) - 1
+	}
+}
+
+//移动到下一个（dir为descend时是上一个）叶子节点，没有时返回false
+func (c *bpCursor) step(dir direction) bool {
+	next := c.leaf.next
+	if dir == descend {
+		next = c.leaf.prev
+	}
+	if c.t.trusted(c.leaf, next) {
+		c.leaf = next
+		c.stale = true
+	} else {
+		if c.stale {
+			//从root重新找到当前的叶子节点，叶子节点中至少有一个key
+			c.seekLeaf(c.leaf.keys[0])
+		}
+		if !c.stepPath(dir) {
+			return false
+		}
+	}
+	c.pos = 0
+	if dir == descend {
+		c.pos = len(c.leaf.keys) - 1
+	}
+	return true
+}
+
+//通过父节点路径移动到下一个（dir为descend时是上一个）叶子节点，没有时返回false
+func (c *bpCursor) stepPath(dir direction) bool {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if i := top.i + int(dir); i >= 0 && i < len(top.n.children) {
+			top.i = i
+			c.descendEdge(top.n.children[i], dir)
+			return true
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+	}
+	return false
+}
+
+//移动到下一个item，没有时返回false
+func (c *bpCursor) advance(dir direction) bool {
+	c.pos += int(dir)
+	for c.pos < 0 || c.pos >= len(c.leaf.keys) {
+		if !c.step(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+//返回指向最小（dir为descend时是最大）item的游标，tree为空时返回nil
+func (t *BPlusTree) seekEdge(dir direction) *bpCursor {
+	if t.root == nil || t.length == 0 {
+		return nil
+	}
+	c := &bpCursor{t: t}
+	c.descendEdge(t.root, dir)
+	return c
+}
+
+//返回指向第一个不小于key（dir为descend时是最后一个不大于key）的item的游标，不存在时返回nil
+func (t *BPlusTree) seek(key Item, dir direction) *bpCursor {
+	if t.root == nil || t.length == 0 {
+		return nil
+	}
+	c := &bpCursor{t: t}
+	c.seekLeaf(key)
+	n := c.leaf
+	i, found := n.keys.find(key)
+	if dir == descend && !found {
+		i--
+	}
+	c.pos = i
+	if i < 0 || i >= len(n.keys) {
+		//不在这个叶子节点中，从相邻的叶子节点开始
+		c.pos -= int(dir)
+		if !c.advance(dir) {
+			return nil
+		}
+	}
+	return c
+}
+
+//从start开始沿着dir遍历，start为nil时从头开始，遇到stop时停止，不包括stop
+func (t *BPlusTree) scan(dir direction, start, stop Item, iter ItemIterator) {
+	var c *bpCursor
+	if start == nil {
+		c = t.seekEdge(dir)
+	} else {
+		c = t.seek(start, dir)
+	}
+	if c == nil {
+		return
+	}
+	for {
+		item := c.item()
+		if stop != nil {
+			if dir == ascend && !item.Less(stop) {
+				return
+			}
+			if dir == descend && !stop.Less(item) {
+				return
+			}
+		}
+		if !iter(item) || !c.advance(dir) {
+			return
+		}
+	}
+}
+
+//AscendRange升序处理[greaterOrEqual, lessThan)范围内的每一个item，直到iterator返回false
+func (t *BPlusTree) AscendRange(greaterOrEqual, lessThan Item, iterator ItemIterator) {
+	t.scan(ascend, greaterOrEqual, lessThan, iterator)
+}
+
+//AscendLessThan升序处理[first, pivot)范围内的每一个item，直到iterator返回false
+func (t *BPlusTree) AscendLessThan(pivot Item, iterator ItemIterator) {
+	t.scan(ascend, nil, pivot, iterator)
+}
+
+//AscendGreaterOrEqual升序处理[pivot, last]范围内的每一个item，直到iterator返回false
+func (t *BPlusTree) AscendGreaterOrEqual(pivot Item, iterator ItemIterator) {
+	t.scan(ascend, pivot, nil, iterator)
+}
+
+//Ascend升序处理tree中的每一个item，直到iterator返回false
+func (t *BPlusTree) Ascend(iterator ItemIterator) {
+	t.scan(ascend, nil, nil, iterator)
+}
+
+//DescendRange降序处理[lessOrEqual, greaterThan)范围内的每一个item，直到iterator返回false
+func (t *BPlusTree) DescendRange(lessOrEqual, greaterThan Item, iterator ItemIterator) {
+	t.scan(descend, lessOrEqual, greaterThan, iterator)
+}
+
+//DescendLessOrEqual降序处理[pivot, first]范围内的每一个item，直到iterator返回false
+func (t *BPlusTree) DescendLessOrEqual(pivot Item, iterator ItemIterator) {
+	t.scan(descend, pivot, nil, iterator)
+}
+
+//DescendGreaterThan降序处理[last, pivot)范围内的每一个item，直到iterator返回false
+func (t *BPlusTree) DescendGreaterThan(pivot Item, iterator ItemIterator) {
+	t.scan(descend, nil, pivot, iterator)
+}
+
+//Descend降序处理tree中的每一个item，直到iterator返回false
+func (t *BPlusTree) Descend(iterator ItemIterator) {
+	t.scan(descend, nil, nil, iterator)
+}
@@ -0,0 +1,308 @@
+package btree
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//verifyBPlusTree检查节点大小、key的顺序和叶子的深度，以及tree信任的每个叶子链接都指向真正的相邻叶子
+//tree自己拥有的叶子之间必须都已经链接。
+func verifyBPlusTree(t *testing.T, tr *BPlusTree) {
+	t.Helper()
+	if tr.root == nil {
+		return
+	}
+	var leaves []*bpNode
+	leafDepth := -1
+	count := 0
+	var walk func(n *bpNode, depth int, lo, hi Item)
+	walk = func(n *bpNode, depth int, lo, hi Item) {
+		if n != tr.root && (len(n.keys) < tr.minItems() || len(n.keys) > tr.maxItems()) {
+			t.Fatalf("node size %d outside [%d, %d]", len(n.keys), tr.minItems(), tr.maxItems())
+		}
+		for i, k := range n.keys {
+			if (lo != nil && k.Less(lo)) || (hi != nil && !k.Less(hi)) {
+				t.Fatalf("key %v outside [%v, %v)", k, lo, hi)
+			}
+			if i > 0 && !n.keys[i-1].Less(k) {
+				t.Fatalf("keys out of order: %v", n.keys)
+			}
+		}
+		if n.leaf() {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				t.Fatalf("leaf at depth %d, want %d", depth, leafDepth)
+			}
+			count += len(n.keys)
+			leaves = append(leaves, n)
+			return
+		}
+		if len(n.children) != len(n.keys)+1 {
+			t.Fatalf("%d children for %d keys", len(n.children), len(n.keys))
+		}
+		for i, child := range n.children {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				chi = n.keys[i]
+			}
+			walk(child, depth+1, clo, chi)
+		}
+	}
+	walk(tr.root, 0, nil, nil)
+	if count != tr.Len() {
+		t.Fatalf("counted %d items, Len() is %d", count, tr.Len())
+	}
+	for i, leaf := range leaves {
+		var prev, next *bpNode
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if tr.trusted(leaf, leaf.prev) && leaf.prev != prev {
+			t.Fatalf("leaf %d has a trusted prev link to the wrong leaf", i)
+		}
+		if tr.trusted(leaf, leaf.next) && leaf.next != next {
+			t.Fatalf("leaf %d has a trusted next link to the wrong leaf", i)
+		}
+		if next != nil && leaf.cow == tr.cow && next.cow == tr.cow && (leaf.next != next || next.prev != leaf) {
+			t.Fatalf("owned leaves %d and %d are not linked", i, i+1)
+		}
+	}
+}
+
+//trustedLinks返回遍历tr时可以沿着链接跨过的相邻叶子对的个数，以及相邻叶子对的总数
+func trustedLinks(tr *BPlusTree) (trusted, pairs int) {
+	var prev *bpNode
+	var walk func(n *bpNode)
+	walk = func(n *bpNode) {
+		if !n.leaf() {
+			for _, c := range n.children {
+				walk(c)
+			}
+			return
+		}
+		if prev != nil {
+			pairs++
+			if tr.trusted(prev, prev.next) && tr.trusted(n, n.prev) {
+				trusted++
+			}
+		}
+		prev = n
+	}
+	if tr.root != nil {
+		walk(tr.root)
+	}
+	return
+}
+
+func allBPlus(tr *BPlusTree) (out []Item) {
+	tr.Ascend(func(a Item) bool {
+		out = append(out, a)
+		return true
+	})
+	return
+}
+
+func TestBPlusTree(t *testing.T) {
+	for _, degree := range []int{2, 3, *btreeDegree} {
+		tr := NewBPlusTree(degree)
+		ref := New(degree)
+		for i := 0; i < 5000; i++ {
+			v := Int(rand.Intn(1000))
+			if rand.Intn(3) == 0 {
+				if got, want := tr.Delete(v), ref.Delete(v); got != want {
+					t.Fatalf("Delete(%v): got %v, want %v", v, got, want)
+				}
+			} else if got, want := tr.ReplaceOrInsert(v), ref.ReplaceOrInsert(v); got != want {
+				t.Fatalf("ReplaceOrInsert(%v): got %v, want %v", v, got, want)
+			}
+		}
+		verifyBPlusTree(t, tr)
+		if got, want := allBPlus(tr), all(ref); !reflect.DeepEqual(got, want) {
+			t.Fatalf("degree %d mismatch:\n got: %v\nwant: %v", degree, got, want)
+		}
+		if tr.Len() != ref.Len() || tr.Min() != ref.Min() || tr.Max() != ref.Max() {
+			t.Fatalf("len/min/max mismatch")
+		}
+		for i := 0; i < 1000; i++ {
+			if tr.Get(Int(i)) != ref.Get(Int(i)) {
+				t.Fatalf("Get(%d) mismatch", i)
+			}
+		}
+		for _, v := range all(ref) {
+			tr.Delete(v)
+		}
+		verifyBPlusTree(t, tr)
+		if tr.Len() != 0 || tr.Min() != nil || tr.Max() != nil {
+			t.Fatalf("tree not empty")
+		}
+	}
+}
+
+//checkScans用相同的边界执行BTree和BPlusTree的每一种范围遍历，报告第一个不一致的结果
+func checkScans(t *testing.T, tr *BPlusTree, ref *BTree) {
+	t.Helper()
+	collect := func(out *[]Item) ItemIterator {
+		*out = (*out)[:0]
+		return func(a Item) bool {
+			*out = append(*out, a)
+			return true
+		}
+	}
+	var got, want []Item
+	for _, b := range [][2]Item{{Int(0), Int(100)}, {Int(37), Int(412)}, {Int(-5), Int(2000)}, {Int(500), Int(499)}, {Int(998), Int(1000)}} {
+		lo, hi := b[0], b[1]
+		tr.AscendRange(lo, hi, collect(&got))
+		ref.AscendRange(lo, hi, collect(&want))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("AscendRange(%v, %v):\n got: %v\nwant: %v", lo, hi, got, want)
+		}
+		tr.DescendRange(hi, lo, collect(&got))
+		ref.DescendRange(hi, lo, collect(&want))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("DescendRange(%v, %v):\n got: %v\nwant: %v", hi, lo, got, want)
+		}
+		tr.AscendLessThan(hi, collect(&got))
+		ref.AscendLessThan(hi, collect(&want))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("AscendLessThan(%v):\n got: %v\nwant: %v", hi, got, want)
+		}
+		tr.AscendGreaterOrEqual(lo, collect(&got))
+		ref.AscendGreaterOrEqual(lo, collect(&want))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("AscendGreaterOrEqual(%v):\n got: %v\nwant: %v", lo, got, want)
+		}
+		tr.DescendLessOrEqual(hi, collect(&got))
+		ref.DescendLessOrEqual(hi, collect(&want))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("DescendLessOrEqual(%v):\n got: %v\nwant: %v", hi, got, want)
+		}
+		tr.DescendGreaterThan(lo, collect(&got))
+		ref.DescendGreaterThan(lo, collect(&want))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("DescendGreaterThan(%v):\n got: %v\nwant: %v", lo, got, want)
+		}
+	}
+	tr.Descend(collect(&got))
+	ref.Descend(collect(&want))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Descend:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestBPlusTreeScans(t *testing.T) {
+	tr := NewBPlusTree(3)
+	ref := New(3)
+	for _, v := range perm(1000) {
+		if v.(Int)%3 != 0 {
+			tr.ReplaceOrInsert(v)
+			ref.ReplaceOrInsert(v)
+		}
+	}
+	checkScans(t, tr, ref)
+	if trusted, pairs := trustedLinks(tr); trusted != pairs {
+		t.Fatalf("%d of %d leaf links trusted before Clone", trusted, pairs)
+	}
+	clone := tr.Clone()
+	//修改之前两棵tree都可以沿着整个叶子链表遍历
+	for _, x := range []*BPlusTree{tr, clone} {
+		verifyBPlusTree(t, x)
+		if trusted, pairs := trustedLinks(x); trusted != pairs {
+			t.Fatalf("%d of %d leaf links trusted after Clone", trusted, pairs)
+		}
+		checkScans(t, x, ref)
+	}
+	//中间没有修改的第二次Clone也保留了链接
+	clone2 := clone.Clone()
+	if trusted, pairs := trustedLinks(clone2); trusted != pairs {
+		t.Fatalf("%d of %d leaf links trusted after second Clone", trusted, pairs)
+	}
+	checkScans(t, clone2, ref)
+}
+
+func TestBPlusTreeClone(t *testing.T) {
+	tr := NewBPlusTree(2)
+	for _, v := range perm(500) {
+		tr.ReplaceOrInsert(v)
+	}
+	clone := tr.Clone()
+	for _, v := range perm(250) {
+		tr.Delete(v)
+	}
+	for i := 500; i < 600; i++ {
+		clone.ReplaceOrInsert(Int(i))
+	}
+	verifyBPlusTree(t, tr)
+	verifyBPlusTree(t, clone)
+	if got, want := allBPlus(tr), rang(500)[250:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("original:\n got: %v\nwant: %v", got, want)
+	}
+	if got, want := allBPlus(clone), rang(600); !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestBPlusTreeCloneRandom(t *testing.T) {
+	type pair struct {
+		tr  *BPlusTree
+		ref *BTree
+	}
+	trees := []pair{{NewBPlusTree(2), New(2)}}
+	for round := 0; round < 3000; round++ {
+		p := trees[rand.Intn(len(trees))]
+		v := Int(rand.Intn(400))
+		switch r := rand.Intn(20); {
+		case r == 0 && len(trees) < 8:
+			trees = append(trees, pair{p.tr.Clone(), p.ref.Clone()})
+		case r < 8:
+			p.tr.Delete(v)
+			p.ref.Delete(v)
+		default:
+			p.tr.ReplaceOrInsert(v)
+			p.ref.ReplaceOrInsert(v)
+		}
+	}
+	for _, p := range trees {
+		verifyBPlusTree(t, p.tr)
+		checkScans(t, p.tr, p.ref)
+		//重写每个item之后所有叶子都属于这棵tree，遍历沿着链表进行
+		p.ref.Ascend(func(a Item) bool {
+			p.tr.ReplaceOrInsert(a)
+			return true
+		})
+		verifyBPlusTree(t, p.tr)
+		if trusted, pairs := trustedLinks(p.tr); trusted != pairs {
+			t.Fatalf("%d of %d leaf links trusted after rewrite", trusted, pairs)
+		}
+		checkScans(t, p.tr, p.ref)
+	}
+}
+
+func BenchmarkBPlusTreeAscendRange(b *testing.B) {
+	arr := perm(benchmarkTreeSize)
+	tr := NewBPlusTree(*btreeDegree)
+	for _, v := range arr {
+		tr.ReplaceOrInsert(v)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := 100
+		tr.AscendRange(Int(100), Int(benchmarkTreeSize-100), func(item Item) bool {
+			if item.(Int) != Int(j) {
+				b.Fatalf("mismatch: expected: %v, got %v", j, item)
+			}
+			j++
+			return true
+		})
+		if j != benchmarkTreeSize-100 {
+			b.Fatalf("expected: %v, got %v", benchmarkTreeSize-100, j)
+		}
+	}
+}
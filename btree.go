@@ -12,6 +12,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"unsafe"
 )
 
 const (
@@ -69,6 +70,9 @@ func (f *FreeList) freeNode(n *node) (out bool) {
 
 type ItemIterator func(i Item) bool
 
+//UpsertFunc根据tree中原来的item决定新的item，返回false表示删除或者不插入
+type UpsertFunc func(old Item, exists bool) (Item, bool)
+
 //根据给定degree来生成一个BTree
 func New(degree int) *BTree {
 	return NewWithFreeList(degree, NewFreeList(DefaultFreelistSize))
@@ -156,6 +160,28 @@ func (i items) find(item Item) (index int, found bool) {
 	return n, false
 }
 
+//和find一样，但是从前往后逐个比较，节点很小并且比较很便宜的时候比二分查找快
+func (i items) findLinear(item Item) (index int, found bool) {
+	for j, v := range i {
+		if !v.Less(item) {
+			return j, !item.Less(v)
+		}
+	}
+	return len(i), false
+}
+
+//节点中查找item的策略
+type SearchStrategy int
+
+const (
+	SearchBinary SearchStrategy = iota //二分查找，默认的策略
+	SearchLinear                       //线性查找
+	SearchAuto                         //根据节点的大小和比较的代价自动选择
+)
+
+//SearchAuto时，比较代价为1的key在不超过这么多item的节点中使用线性查找
+const autoLinearMaxItems = 32
+
 /**
 children：对子node的操作
 */
@@ -216,7 +242,9 @@ node
 type node struct {
 	items    items               //此节点的元素
 	children children            //此节点包含的子节点的指针
+	size     int                 //以此节点为根的子树中item的个数，用于按位置查找
 	cow      *copyOnWriteContext //copy on write
+	digest   unsafe.Pointer      //缓存的*nodeDigest，节点被修改时清空，见merkle.go
 }
 
 //  copyOnWriteContext指针确定节点的所有权...具有与节点的写入上下文等效的写入上下文的树可用于修改该节点。
@@ -224,14 +252,19 @@ type node struct {
 //  为此，我们需要在上下文不匹配的情况下，通过使用正确的上下文创建一个副本，然后再进入任何节点。
 //  由于我们当前在任何写操作中访问的节点都具有请求树的上下文，因此该节点可以在适当的位置进行修改。 该节点的子节点可能不会共享上下文，但是在我们进入它们之前，我们将创建一个可变的副本。
 type copyOnWriteContext struct {
-	freelist *FreeList
+	freelist  *FreeList
+	search    SearchStrategy //节点中查找item的策略
+	linearMax int            //SearchAuto时使用线性查找的最大节点大小
+	hasher    *itemHasher    //计算Merkle hash时使用的item hash函数，见SetItemHasher
+	epoch     uint64         //History分配的代数，0表示不是History创建的，见history.go
 }
 
 //可变的
 func (n *node) mutableFor(cow *copyOnWriteContext) *node {
 	//如果node的copyOnWriteContext和指定的copyOnWriteContext相等
 	if n.cow == cow {
-		//返回当前节点
+		//返回当前节点，调用者接下来会修改它，缓存的hash失效
+		n.digest = nil
 		return n
 	}
 	//从空闲链表中取出一个node
@@ -252,6 +285,7 @@ func (n *node) mutableFor(cow *copyOnWriteContext) *node {
 	}
 	//复制children
 	copy(out.children, n.children)
+	out.size = n.size
 	return out
 }
 
@@ -262,6 +296,14 @@ func (n *node) mutableChild(i int) *node {
 	return c
 }
 
+//重新计算以此节点为根的子树中item的个数
+func (n *node) recount() {
+	n.size = len(n.items)
+	for _, child := range n.children {
+		n.size += child.size
+	}
+}
+
 //将i之后的item和node，清除掉
 func (n *node) split(i int) (Item, *node) {
 	item := n.items[i]
@@ -277,6 +319,8 @@ func (n *node) split(i int) (Item, *node) {
 		next.children = append(next.children, n.children[i+1:]...)
 		n.children.truncate(i + 1)
 	}
+	next.recount()
+	n.recount()
 	return item, next
 }
 
@@ -301,7 +345,7 @@ func (n *node) maybeSplitChild(i, maxItems int) bool {
 //如果要插入的item已经存在，就把它返回
 func (n *node) insert(item Item, maxItems int) Item {
 	//i为item的位置
-	i, found := n.items.find(item)
+	i, found := n.find(item)
 	if found {
 		out := n.items[i]
 		//更新
@@ -313,6 +357,7 @@ func (n *node) insert(item Item, maxItems int) Item {
 	if len(n.children) == 0 {
 		//在给定的位置插入item
 		n.items.insertAt(i, item)
+		n.size++
 		return nil
 	}
 	//拆分child
@@ -331,12 +376,110 @@ func (n *node) insert(item Item, maxItems int) Item {
 		}
 
 	}
-	return n.mutableChild(i).insert(item, maxItems)
+	out := n.mutableChild(i).insert(item, maxItems)
+	if out == nil {
+		n.size++
+	}
+	return out
+}
+
+//和insert一样在以此节点为根节点的子树上查找key，并且在向下的过程中拆分已满的子节点
+//找到位置之后调用fn，返回原来的item，以及fn是否保留了结果。fn返回false时在同一次遍历中删除原来的item，
+//删除之后n可能少于minItems个item，由调用方在返回的路上修复，和remove在向下之前修复不同。
+func (n *node) upsert(key Item, fn UpsertFunc, maxItems, minItems int) (Item, bool) {
+	i, found := n.find(key)
+	if found {
+		return n.upsertAt(i, fn, minItems)
+	}
+	if len(n.children) == 0 {
+		out, keep := fn(nil, false)
+		if keep {
+			if out == nil {
+				panic("nil item being added to BTree")
+			}
+			n.items.insertAt(i, out)
+			n.size++
+		}
+		return nil, keep
+	}
+	if n.maybeSplitChild(i, maxItems) {
+		inTree := n.items[i]
+		switch {
+		case key.Less(inTree):
+		case inTree.Less(key):
+			i++
+		default:
+			return n.upsertAt(i, fn, minItems)
+		}
+	}
+	old, keep := n.mutableChild(i).upsert(key, fn, maxItems, minItems)
+	switch {
+	case old == nil && keep:
+		n.size++
+	case old != nil && !keep:
+		n.size--
+		n.fixChild(i, minItems)
+	}
+	return old, keep
+}
+
+//对已经存在的items[i]调用fn，fn返回false时删除items[i]
+func (n *node) upsertAt(i int, fn UpsertFunc, minItems int) (Item, bool) {
+	old := n.items[i]
+	out, keep := fn(old, true)
+	if keep {
+		if out == nil {
+			panic("nil item being added to BTree")
+		}
+		n.items[i] = out
+		return old, true
+	}
+	n.size--
+	if len(n.children) == 0 {
+		n.items.removeAt(i)
+		return old, false
+	}
+	//用左子树中最大的item代替它
+	n.items[i] = n.mutableChild(i).removeMaxUp(minItems)
+	n.fixChild(i, minItems)
+	return old, false
+}
+
+//删除并返回子树中最大的item，沿途变得少于minItems个item的子节点在返回的路上修复，n自己由调用方修复
+func (n *node) removeMaxUp(minItems int) Item {
+	n.size--
+	if len(n.children) == 0 {
+		return n.items.pop()
+	}
+	i := len(n.children) - 1
+	out := n.mutableChild(i).removeMaxUp(minItems)
+	n.fixChild(i, minItems)
+	return out
+}
+
+//子节点i少于minItems个item时从兄弟节点窃取或者和兄弟节点合并
+func (n *node) fixChild(i, minItems int) {
+	if len(n.children[i].items) < minItems {
+		n.growChild(i, minItems)
+	}
+}
+
+//根据tree的查找策略找到item在节点中的位置
+func (n *node) find(item Item) (int, bool) {
+	switch n.cow.search {
+	case SearchLinear:
+		return n.items.findLinear(item)
+	case SearchAuto:
+		if len(n.items) <= n.cow.linearMax {
+			return n.items.findLinear(item)
+		}
+	}
+	return n.items.find(item)
 }
 
 //在子树中找到key
 func (n *node) get(key Item) Item {
-	i, found := n.items.find(key)
+	i, found := n.find(key)
 	if found {
 		return n.items[i]
 	} else if len(n.children) > 0 {
@@ -347,6 +490,44 @@ func (n *node) get(key Item) Item {
 	return nil
 }
 
+//返回子树中按升序排在第i位的item，i必须在[0, n.size)范围内
+func (n *node) at(i int) Item {
+	if len(n.children) == 0 {
+		return n.items[i]
+	}
+	for j, child := range n.children {
+		if i < child.size {
+			return child.at(i)
+		}
+		i -= child.size
+		if i == 0 {
+			return n.items[j]
+		}
+		i--
+	}
+	panic("index out of range")
+}
+
+//返回子树中小于key的item的个数，也就是key按升序排在第几位
+func (n *node) rank(key Item) int {
+	r := 0
+	for {
+		i, found := n.find(key)
+		r += i
+		if len(n.children) == 0 {
+			return r
+		}
+		for _, child := range n.children[:i] {
+			r += child.size
+		}
+		if found {
+			//items[i]等于key，它左边的子树整个都小于key
+			return r + n.children[i].size
+		}
+		n = n.children[i]
+	}
+}
+
 //返回子树中第一个item
 func min(n *node) Item {
 	if n == nil {
@@ -397,6 +578,7 @@ func (n *node) remove(item Item, minItems int, typ toRemove) Item {
 		//移除子树中最大的item
 		if len(n.children) == 0 {
 			//子树为空，则取items中取一个
+			n.size--
 			return n.items.pop()
 		}
 		//最大索引
@@ -404,15 +586,17 @@ func (n *node) remove(item Item, minItems int, typ toRemove) Item {
 	case removeMin:
 		//移除子树中最小的item
 		if len(n.children) == 0 {
+			n.size--
 			return n.items.removeAt(0)
 		}
 		//最小索引值
 		i = 0
 	case removeItem:
 		// 移除指定的item
-		i, found = n.items.find(item)
+		i, found = n.find(item)
 		if len(n.children) == 0 {
 			if found {
+				n.size--
 				return n.items.removeAt(i)
 			}
 			return nil
@@ -438,11 +622,16 @@ func (n *node) remove(item Item, minItems int, typ toRemove) Item {
 		// predecessor of item i (the rightmost leaf of our immediate left child)
 		// and set it into where we pulled the item from.
 		n.items[i] = child.remove(nil, minItems, removeMax)
+		n.size--
 		return out
 	}
 	// 一旦我们到了这个位置的时候，我们知道这个item不在node中，而且 the child 应该去移除因为已经足够大了
 	// 递归调用
-	return child.remove(item, minItems, typ)
+	out := child.remove(item, minItems, typ)
+	if out != nil {
+		n.size--
+	}
+	return out
 
 }
 
@@ -461,6 +650,12 @@ func (n *node) remove(item Item, minItems int, typ toRemove) Item {
 //如果节点没有足够的item，则确保它有（使用a，b，c）。
 //然后，我们只是简单地重做移除调用，然后第二次（无论我们是在案例1还是案例2中），我们将有足够的项目并可以保证我们碰到案例A。
 func (n *node) growChildAndRemove(i int, item Item, minItems int, typ toRemove) Item {
+	n.growChild(i, minItems)
+	return n.remove(item, minItems, typ)
+}
+
+//从左右兄弟节点窃取一个item给子节点i，兄弟节点都不够的时候和兄弟节点合并，合并时返回true
+func (n *node) growChild(i int, minItems int) bool {
 	if i > 0 && len(n.children[i-1].items) > minItems {
 		//从左子节点窃取
 		child := n.mutableChild(i)
@@ -473,6 +668,8 @@ func (n *node) growChildAndRemove(i int, item Item, minItems int, typ toRemove)
 		if len(stealFrom.children) > 0 {
 			child.children.insertAt(0, stealFrom.children.pop())
 		}
+		child.recount()
+		stealFrom.recount()
 	} else if i < len(n.items) && len(n.children[i+1].items) > minItems {
 		// 从右子树窃取
 		child := n.mutableChild(i)
@@ -483,20 +680,30 @@ func (n *node) growChildAndRemove(i int, item Item, minItems int, typ toRemove)
 		if len(stealFrom.children) > 0 {
 			child.children = append(child.children, stealFrom.children.removeAt(0))
 		}
+		child.recount()
+		stealFrom.recount()
 	} else {
 		if i >= len(n.items) {
 			i--
 		}
-		child := n.mutableChild(i)
 		// 和右子树合并
-		mergeItem := n.items.removeAt(i)
-		mergeChild := n.children.removeAt(i + 1)
-		child.items = append(child.items, mergeItem)
-		child.items = append(child.items, mergeChild.items...)
-		child.children = append(child.children, mergeChild.children...)
-		n.cow.freeNode(mergeChild)
+		n.mergeChild(i)
+		return true
 	}
-	return n.remove(item, minItems, typ)
+	return false
+}
+
+//将子节点i、子节点i+1以及它们之间的item合并成一个节点，并返回合并后的节点
+func (n *node) mergeChild(i int) *node {
+	child := n.mutableChild(i)
+	mergeItem := n.items.removeAt(i)
+	mergeChild := n.children.removeAt(i + 1)
+	child.items = append(child.items, mergeItem)
+	child.items = append(child.items, mergeChild.items...)
+	child.children = append(child.children, mergeChild.children...)
+	child.size += mergeChild.size + 1
+	n.cow.freeNode(mergeChild)
+	return child
 }
 
 type direction int //方向
@@ -516,7 +723,7 @@ func (n *node) iterate(dir direction, start, stop Item, includeStart bool, hit b
 	//升序迭代
 	case ascend:
 		if start != nil {
-			index, _ = n.items.find(start)
+			index, _ = n.find(start)
 		}
 		for i := index; i < len(n.items); i++ {
 			// iterate one children
@@ -546,7 +753,7 @@ func (n *node) iterate(dir direction, start, stop Item, includeStart bool, hit b
 		//降序迭代
 	case descend:
 		if start != nil {
-			index, found = n.items.find(start)
+			index, found = n.find(start)
 			if !found {
 				index = index - 1
 			}
@@ -595,6 +802,8 @@ type BTree struct {
 	length int
 	root   *node
 	cow    *copyOnWriteContext
+
+	observers []Observer //修改之后需要通知的Observer，不会被Clone继承
 }
 
 //Clone是延迟clone。 不应该并发调用Clone，但是一旦Clone调用完成，就可以并发使用原始 tree (t) 和新tree (t2）。
@@ -611,9 +820,22 @@ func (t *BTree) Clone() (t2 *BTree) {
 	out := *t
 	t.cow = &cow1
 	out.cow = &cow2
+	out.observers = nil
 	return &out
 }
 
+//SetSearchStrategy设置在节点中查找item的策略，find、get、insert、remove以及遍历都会使用它。
+//keyCost是一次Less的相对代价（1表示和Int一样便宜），只在SearchAuto时使用：
+//节点中的item数不超过32/keyCost时使用线性查找，否则使用二分查找。
+//和Clone共享的节点在被复制之前仍然使用原来的策略，这只影响速度，不影响结果。
+func (t *BTree) SetSearchStrategy(s SearchStrategy, keyCost int) {
+	if keyCost < 1 {
+		keyCost = 1
+	}
+	t.cow.search = s
+	t.cow.linearMax = autoLinearMaxItems / keyCost
+}
+
 // maxItems returns 每个node允许的最大items数
 func (t *BTree) maxItems() int {
 	return t.degree*2 - 1
@@ -647,7 +869,9 @@ func (c *copyOnWriteContext) freeNode(n *node) freeType {
 		//清空以备进行GC
 		n.items.truncate(0)
 		n.children.truncate(0)
+		n.size = 0
 		n.cow = nil
+		n.digest = nil
 		if c.freelist.freeNode(n) {
 			return ftStored
 		} else {
@@ -669,26 +893,97 @@ func (t *BTree) ReplaceOrInsert(item Item) Item {
 	if t.root == nil {
 		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, item)
+		t.root.size = 1
 		t.length++
+		t.notify(changeInsert, nil, item)
 		return nil
-	} else {
-		//root节点不为空
-		t.root = t.root.mutableFor(t.cow)
-		if len(t.root.items) >= t.maxItems() {
-			item2, second := t.root.split(t.maxItems() / 2)
-			oldRoot := t.root
-			t.root = t.cow.newNode()
-			t.root.items = append(t.root.items, item2)
-			t.root.children = append(t.root.children, oldRoot, second)
-		}
 	}
+	//root节点不为空
+	t.mutableRootForInsert()
 	out := t.root.insert(item, t.maxItems())
 	if out == nil {
 		t.length++
+		t.notify(changeInsert, nil, item)
+	} else {
+		t.notify(changeReplace, out, item)
 	}
 	return out
 }
 
+//让root可变，并且在root已满的时候拆分root，保证接下来的插入不会超出maxItems
+func (t *BTree) mutableRootForInsert() {
+	t.root = t.root.mutableFor(t.cow)
+	if len(t.root.items) >= t.maxItems() {
+		item2, second := t.root.split(t.maxItems() / 2)
+		oldRoot := t.root
+		t.root = t.cow.newNode()
+		t.root.items = append(t.root.items, item2)
+		t.root.children = append(t.root.children, oldRoot, second)
+		t.root.recount()
+	}
+}
+
+//Upsert在一次向下遍历中查找key，并根据fn的返回值决定如何修改tree：
+//old是tree中和key相等的item，exists表示它是否存在。
+//fn返回(item, true)时，用item替换old，或者在old不存在时插入item，item必须和key相等；
+//fn返回false时，如果old存在就删除它，否则什么也不做。
+//插入、替换和删除都只需要一次向下遍历。
+func (t *BTree) Upsert(key Item, fn UpsertFunc) {
+	t.upsert(key, fn, true)
+}
+
+//Upsert的实现，notifyReplace为false时替换不通知Observer，用于GetOrInsert这种替换成原来item的情况
+func (t *BTree) upsert(key Item, fn UpsertFunc, notifyReplace bool) {
+	if key == nil {
+		panic("nil item being added to BTree")
+	}
+	if t.root == nil {
+		if out, keep := fn(nil, false); keep {
+			t.ReplaceOrInsert(out)
+		}
+		return
+	}
+	var item Item
+	if len(t.observers) > 0 {
+		//记录fn返回的item，用来通知Observer
+		inner := fn
+		fn = func(old Item, exists bool) (Item, bool) {
+			out, keep := inner(old, exists)
+			item = out
+			return out, keep
+		}
+	}
+	t.mutableRootForInsert()
+	old, keep := t.root.upsert(key, fn, t.maxItems(), t.minItems())
+	switch {
+	case old == nil && keep:
+		t.length++
+		t.notify(changeInsert, nil, item)
+	case old != nil && keep && notifyReplace:
+		t.notify(changeReplace, old, item)
+	case old != nil && !keep:
+		if len(t.root.items) == 0 && len(t.root.children) > 0 {
+			oldRoot := t.root
+			t.root = t.root.children[0]
+			t.cow.freeNode(oldRoot)
+		}
+		t.length--
+		t.notify(changeDelete, old, nil)
+	}
+}
+
+//GetOrInsert返回tree中和item相等的item，不存在时插入item并返回(nil, true)
+func (t *BTree) GetOrInsert(item Item) (existing Item, inserted bool) {
+	t.upsert(item, func(old Item, exists bool) (Item, bool) {
+		if exists {
+			existing = old
+			return old, true
+		}
+		return item, true
+	}, false)
+	return existing, existing == nil
+}
+
 //将给定的item在tree中删除，并把它返回。如果不存在给定的item就返回nil
 func (t *BTree) Delete(item Item) Item {
 	return t.deleteItem(item, removeItem)
@@ -718,6 +1013,7 @@ func (t *BTree) deleteItem(item Item, typ toRemove) Item {
 	}
 	if out != nil {
 		t.length--
+		t.notify(changeDelete, out, nil)
 	}
 	return out
 }
@@ -797,6 +1093,15 @@ func (t *BTree) Get(key Item) Item {
 	return t.root.get(key)
 }
 
+//At返回tree中按升序排在第index位（从0开始）的item，index超出范围时返回nil
+//每个节点都记录了子树的大小，所以只需要O(log n)
+func (t *BTree) At(index int) Item {
+	if t.root == nil || index < 0 || index >= t.length {
+		return nil
+	}
+	return t.root.at(index)
+}
+
 // 返回tree中最小的item
 func (t *BTree) Min() Item {
 	return min(t.root)
@@ -830,6 +1135,9 @@ func (t *BTree) Clear(addNodesToFreelist bool) {
 		t.root.reset(t.cow)
 	}
 	t.root, t.length = nil, 0
+	for _, o := range t.observers {
+		o.OnClear()
+	}
 }
 
 // reset将子树返回到空闲列表。 如果空闲列表已满，它将立即中断，因为迭代的唯一好处是将空闲列表填满。 如果父级重置调用应继续，然后返回true。
@@ -334,6 +334,182 @@ func TestDescendGreaterThan(t *testing.T) {
 	}
 }
 
+func TestUpsert(t *testing.T) {
+	tr := New(3)
+	// Insert even numbers through Upsert, counting how many times each is seen.
+	counts := map[Int]int{}
+	for _, v := range perm(100) {
+		tr.Upsert(v, func(old Item, exists bool) (Item, bool) {
+			if exists {
+				t.Fatalf("%v already exists", v)
+			}
+			counts[v.(Int)]++
+			return v, v.(Int)%2 == 0
+		})
+	}
+	if tr.Len() != 50 {
+		t.Fatalf("len: want 50, got %d", tr.Len())
+	}
+	for _, v := range perm(100) {
+		tr.Upsert(v, func(old Item, exists bool) (Item, bool) {
+			if exists != (v.(Int)%2 == 0) {
+				t.Fatalf("%v: unexpected exists=%v", v, exists)
+			}
+			// Keep multiples of four, delete the other even numbers.
+			return v, exists && v.(Int)%4 == 0
+		})
+	}
+	var want []Item
+	for i := 0; i < 100; i += 4 {
+		want = append(want, Int(i))
+	}
+	if got := all(tr); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	if tr.Len() != len(want) {
+		t.Fatalf("len: want %d, got %d", len(want), tr.Len())
+	}
+	verifyTree(t, tr)
+}
+
+func TestUpsertDeleteRandom(t *testing.T) {
+	for _, degree := range []int{2, 3, 4} {
+		tr := New(degree)
+		model := map[Int]bool{}
+		var snapshot *BTree
+		var snapshotItems []Item
+		for i := 0; i < 5000; i++ {
+			v := Int(rand.Intn(300))
+			tr.Upsert(v, func(old Item, exists bool) (Item, bool) {
+				if exists != model[v] {
+					t.Fatalf("%v: exists=%v", v, exists)
+				}
+				// Flip membership: insert missing items, delete present ones.
+				return v, !exists
+			})
+			if model[v] {
+				delete(model, v)
+			} else {
+				model[v] = true
+			}
+			if i%500 == 0 {
+				snapshot, snapshotItems = tr.Clone(), all(tr)
+			}
+		}
+		verifyTree(t, tr)
+		var want []Item
+		for i := Int(0); i < 300; i++ {
+			if model[i] {
+				want = append(want, i)
+			}
+		}
+		if got := all(tr); !reflect.DeepEqual(got, want) {
+			t.Fatalf("degree %d: got %v, want %v", degree, got, want)
+		}
+		if got := all(snapshot); !reflect.DeepEqual(got, snapshotItems) {
+			t.Fatalf("degree %d: clone was modified", degree)
+		}
+		verifyTree(t, snapshot)
+	}
+}
+
+func TestGetOrInsert(t *testing.T) {
+	tr := New(*btreeDegree)
+	for _, v := range perm(100) {
+		if existing, inserted := tr.GetOrInsert(v); existing != nil || !inserted {
+			t.Fatalf("GetOrInsert(%v) = %v, %v", v, existing, inserted)
+		}
+	}
+	clone := tr.Clone()
+	for _, v := range perm(100) {
+		if existing, inserted := clone.GetOrInsert(v); existing != v || inserted {
+			t.Fatalf("GetOrInsert(%v) = %v, %v", v, existing, inserted)
+		}
+	}
+	if got, want := all(tr), rang(100); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mismatch:\n got: %v\nwant: %v", got, want)
+	}
+	if tr.Len() != 100 || clone.Len() != 100 {
+		t.Fatalf("len: got %d and %d", tr.Len(), clone.Len())
+	}
+}
+
+func TestAt(t *testing.T) {
+	tr := New(2)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	for _, v := range perm(100)[:30] {
+		tr.Delete(v)
+	}
+	tr.DeleteMin()
+	tr.DeleteMax()
+	want := all(tr)
+	for i, v := range want {
+		if got := tr.At(i); got != v {
+			t.Fatalf("At(%d): want %v, got %v", i, v, got)
+		}
+	}
+	if got := tr.At(-1); got != nil {
+		t.Fatalf("At(-1): got %v", got)
+	}
+	if got := tr.At(len(want)); got != nil {
+		t.Fatalf("At(%d): got %v", len(want), got)
+	}
+}
+
+func TestFindLinear(t *testing.T) {
+	for n := 0; n < 10; n++ {
+		var s items
+		for i := 0; i < n; i++ {
+			s = append(s, Int(i*2))
+		}
+		for v := -1; v <= n*2; v++ {
+			i1, f1 := s.find(Int(v))
+			i2, f2 := s.findLinear(Int(v))
+			if i1 != i2 || f1 != f2 {
+				t.Fatalf("find(%d) in %v: binary (%d, %v), linear (%d, %v)", v, s, i1, f1, i2, f2)
+			}
+		}
+	}
+}
+
+func TestSearchStrategy(t *testing.T) {
+	for _, s := range searchStrategies {
+		for _, degree := range []int{2, 8, 16, 40} {
+			tr := New(degree)
+			tr.SetSearchStrategy(s.strategy, 2)
+			for _, v := range perm(1000) {
+				tr.ReplaceOrInsert(v)
+			}
+			for _, v := range perm(1000)[:500] {
+				if tr.Delete(v) == nil {
+					t.Fatalf("%s: didn't find %v", s.name, v)
+				}
+			}
+			clone := tr.Clone()
+			got := all(clone)
+			if len(got) != 500 {
+				t.Fatalf("%s degree %d: %d items left", s.name, degree, len(got))
+			}
+			for _, v := range got {
+				if !clone.Has(v) {
+					t.Fatalf("%s: Has(%v) false", s.name, v)
+				}
+			}
+			var desc []Item
+			tr.DescendLessOrEqual(got[250], func(a Item) bool {
+				desc = append(desc, a)
+				return true
+			})
+			if len(desc) != 251 || desc[0] != got[250] {
+				t.Fatalf("%s: descend got %d items starting at %v", s.name, len(desc), desc[0])
+			}
+			verifyTree(t, tr)
+		}
+	}
+}
+
 const benchmarkTreeSize = 10000
 
 func BenchmarkInsert(b *testing.B) {
@@ -353,19 +529,58 @@ func BenchmarkInsert(b *testing.B) {
 	}
 }
 
+var searchStrategies = []struct {
+	name     string
+	strategy SearchStrategy
+}{
+	{"Binary", SearchBinary},
+	{"Linear", SearchLinear},
+	{"Auto", SearchAuto},
+}
+
+// benchDegrees returns the fixed benchmark degrees plus -degree, without
+// duplicates, in increasing order.
+func benchDegrees() []int {
+	degrees := []int{4, 8, 16, 32, 64}
+	for _, d := range degrees {
+		if d == *btreeDegree {
+			return degrees
+		}
+	}
+	degrees = append(degrees, *btreeDegree)
+	sort.Ints(degrees)
+	return degrees
+}
+
+// forEachStrategy runs fn as a sub-benchmark for every search strategy and
+// a range of degrees.
+func forEachStrategy(b *testing.B, fn func(b *testing.B, degree int, s SearchStrategy)) {
+	for _, s := range searchStrategies {
+		for _, degree := range benchDegrees() {
+			s, degree := s, degree
+			b.Run(fmt.Sprintf("%s/degree=%d", s.name, degree), func(b *testing.B) {
+				fn(b, degree, s.strategy)
+			})
+		}
+	}
+}
+
 func BenchmarkSeek(b *testing.B) {
-	b.StopTimer()
 	size := 100000
 	insertP := perm(size)
-	tr := New(*btreeDegree)
-	for _, item := range insertP {
-		tr.ReplaceOrInsert(item)
-	}
-	b.StartTimer()
+	forEachStrategy(b, func(b *testing.B, degree int, s SearchStrategy) {
+		b.StopTimer()
+		tr := New(degree)
+		tr.SetSearchStrategy(s, 1)
+		for _, item := range insertP {
+			tr.ReplaceOrInsert(item)
+		}
+		b.StartTimer()
 
-	for i := 0; i < b.N; i++ {
-		tr.AscendGreaterOrEqual(Int(i%size), func(i Item) bool { return false })
-	}
+		for i := 0; i < b.N; i++ {
+			tr.AscendGreaterOrEqual(Int(i%size), func(i Item) bool { return false })
+		}
+	})
 }
 
 func BenchmarkDeleteInsert(b *testing.B) {
@@ -439,26 +654,27 @@ func BenchmarkDelete(b *testing.B) {
 }
 
 func BenchmarkGet(b *testing.B) {
-	b.StopTimer()
 	insertP := perm(benchmarkTreeSize)
 	removeP := perm(benchmarkTreeSize)
-	b.StartTimer()
-	i := 0
-	for i < b.N {
-		b.StopTimer()
-		tr := New(*btreeDegree)
-		for _, v := range insertP {
-			tr.ReplaceOrInsert(v)
-		}
-		b.StartTimer()
-		for _, item := range removeP {
-			tr.Get(item)
-			i++
-			if i >= b.N {
-				return
+	forEachStrategy(b, func(b *testing.B, degree int, s SearchStrategy) {
+		i := 0
+		for i < b.N {
+			b.StopTimer()
+			tr := New(degree)
+			tr.SetSearchStrategy(s, 1)
+			for _, v := range insertP {
+				tr.ReplaceOrInsert(v)
+			}
+			b.StartTimer()
+			for _, item := range removeP {
+				tr.Get(item)
+				i++
+				if i >= b.N {
+					return
+				}
 			}
 		}
-	}
+	})
 }
 
 func BenchmarkGetCloneEachTime(b *testing.B) {
@@ -0,0 +1,414 @@
+package btree
+
+import (
+	"bytes"
+	"sort"
+)
+
+//BytesTree是专门存储[]byte key的B-Tree
+//每个节点只保存一份所有key的公共前缀，各个key剩下的后缀紧凑地存放在同一个字节数组中，
+//对于路径、URL这类有很长公共前缀的key，比把每个key都作为Item存储节省很多内存。
+//BytesTree不支持Clone，也不能并发修改。
+type BytesTree struct {
+	degree int
+	length int
+	root   *bytesNode
+}
+
+//BytesTree的节点
+type bytesNode struct {
+	prefix   []byte       //节点中所有key的公共前缀
+	arena    []byte       //所有key的后缀依次存放在一起
+	ends     []uint32     //第i个key的后缀是arena[ends[i-1]:ends[i]]
+	children []*bytesNode //子节点
+}
+
+//根据给定degree来生成一个BytesTree
+func NewBytesTree(degree int) *BytesTree {
+	if degree <= 1 {
+		panic("bad degree")
+	}
+	return &BytesTree{degree: degree}
+}
+
+//节点中key的个数
+func (n *bytesNode) len() int {
+	return len(n.ends)
+}
+
+//第i个key的后缀
+func (n *bytesNode) suffix(i int) []byte {
+	start := uint32(0)
+	if i > 0 {
+		start = n.ends[i-1]
+	}
+	return n.arena[start:n.ends[i]]
+}
+
+//将第i个完整的key追加到dst中
+func (n *bytesNode) appendKey(dst []byte, i int) []byte {
+	return append(append(dst, n.prefix...), n.suffix(i)...)
+}
+
+//第i个key的后缀在arena中的起始位置
+func (n *bytesNode) start(i int) uint32 {
+	if i == 0 {
+		return 0
+	}
+	return n.ends[i-1]
+}
+
+//a和b的公共前缀的长度
+func commonPrefix(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+//把公共前缀缩短成c个字节，去掉的部分补到每个后缀的前面，需要重写整个arena
+func (n *bytesNode) shrinkPrefix(c int) {
+	extra := n.prefix[c:]
+	arena := make([]byte, 0, len(n.arena)+len(extra)*n.len())
+	start := uint32(0)
+	for i, end := range n.ends {
+		arena = append(append(arena, extra...), n.arena[start:end]...)
+		start, n.ends[i] = end, uint32(len(arena))
+	}
+	n.prefix, n.arena = n.prefix[:c], arena
+}
+
+//第一个或者最后一个key变化之后，把所有后缀共同的开头移到公共前缀中，在arena中原地移动后缀
+func (n *bytesNode) growPrefix() {
+	if n.len() == 0 {
+		n.prefix = n.prefix[:0]
+		return
+	}
+	g := commonPrefix(n.suffix(0), n.suffix(n.len()-1))
+	if g == 0 {
+		return
+	}
+	n.prefix = append(n.prefix, n.suffix(0)[:g]...)
+	w, start := uint32(0), uint32(0)
+	for i, end := range n.ends {
+		w += uint32(copy(n.arena[w:], n.arena[start+uint32(g):end]))
+		start, n.ends[i] = end, w
+	}
+	n.arena = n.arena[:w]
+}
+
+//把key插入到第i个位置，只把后缀拼接到arena中
+//key一定在第i-1和第i个key之间，所以只有插入到两端时公共前缀才可能变短
+func (n *bytesNode) insertKey(i int, key []byte) {
+	if n.len() == 0 {
+		n.prefix = append(n.prefix[:0], key...)
+		n.arena = n.arena[:0]
+		n.ends = append(n.ends, 0)
+		return
+	}
+	if c := commonPrefix(n.prefix, key); c < len(n.prefix) {
+		n.shrinkPrefix(c)
+	}
+	rest := key[len(n.prefix):]
+	pos, d := n.start(i), uint32(len(rest))
+	n.arena = append(n.arena, rest...)
+	copy(n.arena[pos+d:], n.arena[pos:uint32(len(n.arena))-d])
+	copy(n.arena[pos:], rest)
+	n.ends = append(n.ends, 0)
+	copy(n.ends[i+1:], n.ends[i:])
+	n.ends[i] = pos
+	for j := i; j < len(n.ends); j++ {
+		n.ends[j] += d
+	}
+}
+
+//删除并返回第i个完整的key，只从arena中去掉它的后缀
+func (n *bytesNode) removeKey(i int) []byte {
+	//空key也要返回非nil的切片，remove用nil表示没有找到
+	out := n.appendKey([]byte{}, i)
+	pos, end := n.start(i), n.ends[i]
+	n.arena = append(n.arena[:pos], n.arena[end:]...)
+	n.ends = append(n.ends[:i], n.ends[i+1:]...)
+	for j := i; j < len(n.ends); j++ {
+		n.ends[j] -= end - pos
+	}
+	if i == 0 || i == n.len() {
+		n.growPrefix()
+	}
+	return out
+}
+
+//把src的第from到to-1个key追加到n的末尾，它们都大于n中的key，公共前缀最多缩短一次
+func (n *bytesNode) appendKeys(src *bytesNode, from, to int) {
+	if from >= to {
+		return
+	}
+	last := src.appendKey(nil, to-1)
+	if n.len() == 0 {
+		first := src.appendKey(nil, from)
+		n.prefix = append(n.prefix[:0], first[:commonPrefix(first, last)]...)
+		n.arena = n.arena[:0]
+	} else if c := commonPrefix(n.prefix, last); c < len(n.prefix) {
+		n.shrinkPrefix(c)
+	}
+	var buf []byte
+	for j := from; j < to; j++ {
+		buf = src.appendKey(buf[:0], j)
+		n.arena = append(n.arena, buf[len(n.prefix):]...)
+		n.ends = append(n.ends, uint32(len(n.arena)))
+	}
+}
+
+//只保留前i个key
+func (n *bytesNode) truncate(i int) {
+	n.arena = n.arena[:n.start(i)]
+	n.ends = n.ends[:i]
+	n.growPrefix()
+}
+
+//找到key在节点中的位置，先比较前缀，前缀相同时再用二分查找比较后缀
+func (n *bytesNode) find(key []byte) (int, bool) {
+	if !bytes.HasPrefix(key, n.prefix) {
+		//key没有这个前缀时，key和前缀的大小关系就是key和节点中所有key的大小关系
+		if bytes.Compare(key, n.prefix) < 0 {
+			return 0, false
+		}
+		return n.len(), false
+	}
+	rest := key[len(n.prefix):]
+	i := sort.Search(n.len(), func(i int) bool {
+		return bytes.Compare(n.suffix(i), rest) >= 0
+	})
+	return i, i < n.len() && bytes.Equal(n.suffix(i), rest)
+}
+
+//和node.split一样，将第i个key之后的key和子节点拆分到一个新节点中，返回第i个key和新节点
+func (n *bytesNode) split(i int) ([]byte, *bytesNode) {
+	key := n.appendKey([]byte{}, i)
+	next := &bytesNode{}
+	next.appendKeys(n, i+1, n.len())
+	if len(n.children) > 0 {
+		next.children = append(next.children, n.children[i+1:]...)
+		n.children = append([]*bytesNode(nil), n.children[:i+1]...)
+	}
+	n.truncate(i)
+	return key, next
+}
+
+//和node.maybeSplitChild一样，子节点已满时拆分它
+func (n *bytesNode) maybeSplitChild(i, maxItems int) bool {
+	if n.children[i].len() < maxItems {
+		return false
+	}
+	key, second := n.children[i].split(maxItems / 2)
+	n.insertKey(i, key)
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = second
+	return true
+}
+
+//在以此节点为根节点的子树上插入key，key已经存在时返回false
+func (n *bytesNode) insert(key []byte, maxItems int) bool {
+	i, found := n.find(key)
+	if found {
+		return false
+	}
+	if len(n.children) == 0 {
+		n.insertKey(i, key)
+		return true
+	}
+	if n.maybeSplitChild(i, maxItems) {
+		switch c := bytes.Compare(key, n.appendKey(nil, i)); {
+		case c == 0:
+			return false
+		case c > 0:
+			i++
+		}
+	}
+	return n.children[i].insert(key, maxItems)
+}
+
+//和node.remove一样根据typ删除key，返回被删除的key，不存在时返回nil
+func (n *bytesNode) remove(key []byte, minItems int, typ toRemove) []byte {
+	var i int
+	var found bool
+	switch typ {
+	case removeMax:
+		if len(n.children) == 0 {
+			return n.removeKey(n.len() - 1)
+		}
+		i = n.len()
+	case removeItem:
+		i, found = n.find(key)
+		if len(n.children) == 0 {
+			if found {
+				return n.removeKey(i)
+			}
+			return nil
+		}
+	default:
+		panic("invalid type")
+	}
+	if n.children[i].len() <= minItems {
+		return n.growChildAndRemove(i, key, minItems, typ)
+	}
+	if found {
+		//用左子树中最大的key替换被删除的key
+		out := n.removeKey(i)
+		n.insertKey(i, n.children[i].remove(nil, minItems, removeMax))
+		return out
+	}
+	return n.children[i].remove(key, minItems, typ)
+}
+
+//和node.growChildAndRemove一样，先从兄弟节点窃取或者和兄弟节点合并，再重新删除
+func (n *bytesNode) growChildAndRemove(i int, key []byte, minItems int, typ toRemove) []byte {
+	if i > 0 && n.children[i-1].len() > minItems {
+		//从左子节点窃取
+		child, stealFrom := n.children[i], n.children[i-1]
+		child.insertKey(0, n.removeKey(i-1))
+		n.insertKey(i-1, stealFrom.removeKey(stealFrom.len()-1))
+		if len(stealFrom.children) > 0 {
+			last := len(stealFrom.children) - 1
+			child.children = append([]*bytesNode{stealFrom.children[last]}, child.children...)
+			stealFrom.children = stealFrom.children[:last]
+		}
+	} else if i < n.len() && n.children[i+1].len() > minItems {
+		//从右子节点窃取
+		child, stealFrom := n.children[i], n.children[i+1]
+		child.insertKey(child.len(), n.removeKey(i))
+		n.insertKey(i, stealFrom.removeKey(0))
+		if len(stealFrom.children) > 0 {
+			child.children = append(child.children, stealFrom.children[0])
+			stealFrom.children = stealFrom.children[1:]
+		}
+	} else {
+		//和右子节点合并
+		if i >= n.len() {
+			i--
+		}
+		child, mergeChild := n.children[i], n.children[i+1]
+		child.insertKey(child.len(), n.removeKey(i))
+		child.appendKeys(mergeChild, 0, mergeChild.len())
+		child.children = append(child.children, mergeChild.children...)
+		n.children = append(n.children[:i+1], n.children[i+2:]...)
+	}
+	return n.remove(key, minItems, typ)
+}
+
+//升序遍历[ge, lt)范围内的key，buf用来拼接完整的key
+func (n *bytesNode) ascend(ge, lt []byte, buf *[]byte, fn func(key []byte) bool) bool {
+	i := 0
+	if ge != nil {
+		i, _ = n.find(ge)
+	}
+	for ; i <= n.len(); i++ {
+		if len(n.children) > 0 && !n.children[i].ascend(ge, lt, buf, fn) {
+			return false
+		}
+		if i == n.len() {
+			break
+		}
+		*buf = n.appendKey((*buf)[:0], i)
+		if lt != nil && bytes.Compare(*buf, lt) >= 0 {
+			return false
+		}
+		if !fn(*buf) {
+			return false
+		}
+	}
+	return true
+}
+
+//maxItems返回每个node允许的最大key数
+func (t *BytesTree) maxItems() int {
+	return t.degree*2 - 1
+}
+
+//minItems返回每个node允许的最小key数
+func (t *BytesTree) minItems() int {
+	return t.degree - 1
+}
+
+//Insert将key加入到tree中，key已经存在时返回false。tree会保存key的一份拷贝
+func (t *BytesTree) Insert(key []byte) bool {
+	if t.root == nil {
+		t.root = &bytesNode{}
+	}
+	if t.root.len() >= t.maxItems() {
+		key2, second := t.root.split(t.maxItems() / 2)
+		oldRoot := t.root
+		t.root = &bytesNode{children: []*bytesNode{oldRoot, second}}
+		t.root.insertKey(0, key2)
+	}
+	if !t.root.insert(key, t.maxItems()) {
+		return false
+	}
+	t.length++
+	return true
+}
+
+//Delete将key从tree中删除，key不存在时返回false
+func (t *BytesTree) Delete(key []byte) bool {
+	if t.root == nil || t.root.len() == 0 {
+		return false
+	}
+	out := t.root.remove(key, t.minItems(), removeItem)
+	if t.root.len() == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+	if out == nil {
+		return false
+	}
+	t.length--
+	return true
+}
+
+//Has返回key是否在tree中
+func (t *BytesTree) Has(key []byte) bool {
+	for n := t.root; n != nil; {
+		i, found := n.find(key)
+		if found {
+			return true
+		}
+		if len(n.children) == 0 {
+			return false
+		}
+		n = n.children[i]
+	}
+	return false
+}
+
+//当前tree中key的个数
+func (t *BytesTree) Len() int {
+	return t.length
+}
+
+//AscendRange升序处理[greaterOrEqual, lessThan)范围内的每一个key，直到fn返回false
+//greaterOrEqual或lessThan为nil时表示这一边没有边界
+//传给fn的key只在这次调用中有效，需要保存时要自己复制一份
+func (t *BytesTree) AscendRange(greaterOrEqual, lessThan []byte, fn func(key []byte) bool) {
+	if t.root == nil {
+		return
+	}
+	var buf []byte
+	t.root.ascend(greaterOrEqual, lessThan, &buf, fn)
+}
+
+//Ascend升序处理tree中的每一个key，直到fn返回false
+func (t *BytesTree) Ascend(fn func(key []byte) bool) {
+	t.AscendRange(nil, nil, fn)
+}
+
+//PrefixScan直接定位到第一个以prefix开头的key，然后升序处理每一个以prefix开头的key，
+//遇到第一个不以prefix开头的key或者fn返回false时停止
+func (t *BytesTree) PrefixScan(prefix []byte, fn func(key []byte) bool) {
+	if prefix == nil {
+		prefix = []byte{}
+	}
+	t.AscendRange(prefix, nil, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix) && fn(key)
+	})
+}
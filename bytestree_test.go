@@ -0,0 +1,243 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//pathKeys按随机顺序返回n个不同的、有很长的公共前缀的类似URL的key
+func pathKeys(n int) []string {
+	out := make([]string, 0, n)
+	for _, v := range rand.Perm(n) {
+		out = append(out, fmt.Sprintf("https://example.com/users/%d/files/%d.txt", v%37, v))
+	}
+	return out
+}
+
+func allBytes(t *BytesTree) (out []string) {
+	t.Ascend(func(key []byte) bool {
+		out = append(out, string(key))
+		return true
+	})
+	return
+}
+
+func TestBytesTree(t *testing.T) {
+	for _, degree := range []int{2, 3, *btreeDegree} {
+		tr := NewBytesTree(degree)
+		keys := pathKeys(2000)
+		for _, k := range keys {
+			if !tr.Insert([]byte(k)) {
+				t.Fatalf("insert %q reported existing", k)
+			}
+		}
+		for _, k := range keys[:100] {
+			if tr.Insert([]byte(k)) {
+				t.Fatalf("re-insert %q reported new", k)
+			}
+		}
+		want := append([]string(nil), keys...)
+		sort.Strings(want)
+		if got := allBytes(tr); !reflect.DeepEqual(got, want) {
+			t.Fatalf("degree %d: ascend mismatch", degree)
+		}
+		if tr.Len() != len(keys) {
+			t.Fatalf("len: want %d, got %d", len(keys), tr.Len())
+		}
+		for _, k := range keys {
+			if !tr.Has([]byte(k)) {
+				t.Fatalf("missing %q", k)
+			}
+		}
+		if tr.Has([]byte("https://example.com/users/1/files/")) || tr.Has([]byte("a")) || tr.Has([]byte("z")) {
+			t.Fatalf("found a key that was never inserted")
+		}
+		for _, k := range keys[:1000] {
+			if !tr.Delete([]byte(k)) {
+				t.Fatalf("delete %q failed", k)
+			}
+		}
+		if tr.Delete([]byte(keys[0])) {
+			t.Fatalf("deleted %q twice", keys[0])
+		}
+		want = append([]string(nil), keys[1000:]...)
+		sort.Strings(want)
+		if got := allBytes(tr); !reflect.DeepEqual(got, want) {
+			t.Fatalf("degree %d: mismatch after delete", degree)
+		}
+		for _, k := range keys[1000:] {
+			tr.Delete([]byte(k))
+		}
+		if tr.Len() != 0 || len(allBytes(tr)) != 0 {
+			t.Fatalf("tree not empty")
+		}
+	}
+}
+
+func TestBytesTreeEmptyKey(t *testing.T) {
+	tr := NewBytesTree(2)
+	for _, k := range []string{"", "a", "b", "c", "d"} {
+		tr.Insert([]byte(k))
+	}
+	if !tr.Has(nil) || !tr.Delete([]byte{}) || tr.Has(nil) || tr.Len() != 4 {
+		t.Fatalf("empty key not handled, len %d", tr.Len())
+	}
+}
+
+func TestBytesTreePrefixScan(t *testing.T) {
+	tr := NewBytesTree(3)
+	keys := pathKeys(2000)
+	for _, k := range keys {
+		tr.Insert([]byte(k))
+	}
+	tr.Insert([]byte("https://example.com/users/1"))
+	tr.Insert([]byte("https://example.com/users/10"))
+	for _, prefix := range []string{"https://example.com/users/1/", "https://example.com/users/1", "https://", "nope", ""} {
+		var want []string
+		for _, k := range append(keys, "https://example.com/users/1", "https://example.com/users/10") {
+			if strings.HasPrefix(k, prefix) {
+				want = append(want, k)
+			}
+		}
+		sort.Strings(want)
+		var got []string
+		tr.PrefixScan([]byte(prefix), func(key []byte) bool {
+			got = append(got, string(key))
+			return true
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("prefix %q: got %d keys, want %d", prefix, len(got), len(want))
+		}
+	}
+	n := 0
+	tr.PrefixScan([]byte("https://example.com/users/2"), func(key []byte) bool {
+		n++
+		return n < 5
+	})
+	if n != 5 {
+		t.Fatalf("scan did not stop: %d", n)
+	}
+}
+
+func TestBytesTreeAscendRange(t *testing.T) {
+	tr := NewBytesTree(2)
+	for _, v := range rand.Perm(100) {
+		tr.Insert([]byte(fmt.Sprintf("%03d", v)))
+	}
+	var got []string
+	tr.AscendRange([]byte("040"), []byte("060"), func(key []byte) bool {
+		got = append(got, string(key))
+		return true
+	})
+	var want []string
+	for i := 40; i < 60; i++ {
+		want = append(want, fmt.Sprintf("%03d", i))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ascendrange:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+//checkBytesNodes检查每个节点的公共前缀就是第一个和最后一个key的公共前缀，并且arena中没有多余的字节
+func checkBytesNodes(t *testing.T, n *bytesNode) {
+	t.Helper()
+	if n == nil {
+		return
+	}
+	if n.len() > 0 {
+		first, last := n.appendKey(nil, 0), n.appendKey(nil, n.len()-1)
+		if c := commonPrefix(first, last); c != len(n.prefix) {
+			t.Fatalf("prefix %q, first %q, last %q", n.prefix, first, last)
+		}
+		if int(n.ends[n.len()-1]) != len(n.arena) {
+			t.Fatalf("arena has %d bytes, keys end at %d", len(n.arena), n.ends[n.len()-1])
+		}
+	}
+	for _, c := range n.children {
+		checkBytesNodes(t, c)
+	}
+}
+
+func TestBytesTreeRandom(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		tr := NewBytesTree(degree)
+		model := map[string]bool{}
+		keys := append(pathKeys(300), "", "a", "https://example.com/", "https://example.com/users/9")
+		for i := 0; i < 5000; i++ {
+			k := keys[rand.Intn(len(keys))]
+			if rand.Intn(2) == 0 {
+				if tr.Insert([]byte(k)) == model[k] {
+					t.Fatalf("Insert(%q) with exists=%v", k, model[k])
+				}
+				model[k] = true
+			} else {
+				if tr.Delete([]byte(k)) != model[k] {
+					t.Fatalf("Delete(%q) with exists=%v", k, model[k])
+				}
+				delete(model, k)
+			}
+		}
+		checkBytesNodes(t, tr.root)
+		var want []string
+		for k := range model {
+			want = append(want, k)
+		}
+		sort.Strings(want)
+		if got := allBytes(tr); !reflect.DeepEqual(got, want) || tr.Len() != len(want) {
+			t.Fatalf("degree %d: got %d keys, want %d", degree, len(got), len(want))
+		}
+	}
+}
+
+func TestBytesNodeInPlace(t *testing.T) {
+	src := &bytesNode{}
+	for _, k := range []string{"/a/1", "/a/2", "/a/4", "/a/5"} {
+		src.insertKey(src.len(), []byte(k))
+	}
+	n := &bytesNode{}
+	n.appendKeys(src, 0, src.len())
+	key := []byte("/a/3")
+	//插入和删除中间的key只在arena中移动后缀，除了返回的key之外不分配内存
+	allocs := testing.AllocsPerRun(100, func() {
+		n.insertKey(2, key)
+		n.removeKey(2)
+	})
+	if allocs > 1 {
+		t.Fatalf("%v allocations per insert and remove", allocs)
+	}
+	if string(n.prefix) != "/a/" || string(n.arena) != "1245" {
+		t.Fatalf("prefix %q, arena %q", n.prefix, n.arena)
+	}
+}
+
+func BenchmarkBytesTreeInsert(b *testing.B) {
+	keys := pathKeys(benchmarkTreeSize)
+	b.ResetTimer()
+	i := 0
+	for i < b.N {
+		tr := NewBytesTree(*btreeDegree)
+		for _, k := range keys {
+			tr.Insert([]byte(k))
+			i++
+			if i >= b.N {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkBytesTreeHas(b *testing.B) {
+	keys := pathKeys(benchmarkTreeSize)
+	tr := NewBytesTree(*btreeDegree)
+	for _, k := range keys {
+		tr.Insert([]byte(k))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Has([]byte(keys[i%len(keys)]))
+	}
+}
@@ -0,0 +1,315 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//Codec负责item和字节之间的相互转换，分页token、快照等需要序列化item的地方都使用它
+type Codec interface {
+	//将item编码成字节
+	EncodeItem(item Item) ([]byte, error)
+	//将EncodeItem的结果解码成item
+	DecodeItem(data []byte) (Item, error)
+}
+
+//OrderedCodec是可以不解码直接比较编码的Codec：
+//对于同一个类型的a和b，CompareEncoded(EncodeItem(a), EncodeItem(b))小于0当且仅当a.Less(b)
+//MappedTree在Codec实现了OrderedCodec时直接比较映射的字节，只解码交给调用者的item
+type OrderedCodec interface {
+	Codec
+	CompareEncoded(a, b []byte) int
+}
+
+var errShortBuffer = errors.New("btree: short buffer")
+
+//比较两个整数，返回-1、0或1
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+//比较两个varint编码的整数，只读取数值，不创建item
+func compareVarint(a, b []byte) int {
+	va, _ := binary.Varint(a)
+	vb, _ := binary.Varint(b)
+	return compareInt64(va, vb)
+}
+
+//IntCodec是Int的Codec，使用varint编码
+type IntCodec struct{}
+
+func (IntCodec) EncodeItem(item Item) ([]byte, error) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutVarint(buf, int64(item.(Int)))], nil
+}
+
+func (IntCodec) DecodeItem(data []byte) (Item, error) {
+	v, n := binary.Varint(data)
+	if n <= 0 || n != len(data) {
+		return nil, errShortBuffer
+	}
+	return Int(v), nil
+}
+
+func (IntCodec) CompareEncoded(a, b []byte) int {
+	return compareVarint(a, b)
+}
+
+//StringCodec是String的Codec
+type StringCodec struct{}
+
+func (StringCodec) EncodeItem(item Item) ([]byte, error) {
+	return []byte(item.(String)), nil
+}
+
+func (StringCodec) DecodeItem(data []byte) (Item, error) {
+	return String(data), nil
+}
+
+//字符串按字节比较，编码本身就保持顺序
+func (StringCodec) CompareEncoded(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+//BytesCodec是Bytes的Codec
+type BytesCodec struct{}
+
+func (BytesCodec) EncodeItem(item Item) ([]byte, error) {
+	return append([]byte(nil), item.(Bytes)...), nil
+}
+
+func (BytesCodec) DecodeItem(data []byte) (Item, error) {
+	return Bytes(append([]byte(nil), data...)), nil
+}
+
+func (BytesCodec) CompareEncoded(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+//Int64Codec是Int64的Codec，使用varint编码
+type Int64Codec struct{}
+
+func (Int64Codec) EncodeItem(item Item) ([]byte, error) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutVarint(buf, int64(item.(Int64)))], nil
+}
+
+func (Int64Codec) DecodeItem(data []byte) (Item, error) {
+	v, n := binary.Varint(data)
+	if n <= 0 || n != len(data) {
+		return nil, errShortBuffer
+	}
+	return Int64(v), nil
+}
+
+func (Int64Codec) CompareEncoded(a, b []byte) int {
+	return compareVarint(a, b)
+}
+
+//Uint64Codec是Uint64的Codec，使用uvarint编码
+type Uint64Codec struct{}
+
+func (Uint64Codec) EncodeItem(item Item) ([]byte, error) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, uint64(item.(Uint64)))], nil
+}
+
+func (Uint64Codec) DecodeItem(data []byte) (Item, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 || n != len(data) {
+		return nil, errShortBuffer
+	}
+	return Uint64(v), nil
+}
+
+func (Uint64Codec) CompareEncoded(a, b []byte) int {
+	va, _ := binary.Uvarint(a)
+	vb, _ := binary.Uvarint(b)
+	return compareUint64(va, vb)
+}
+
+//Float64Codec是Float64的Codec，按照IEEE 754的位编码，-0和NaN都会原样保留
+type Float64Codec struct{}
+
+func (Float64Codec) EncodeItem(item Item) ([]byte, error) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(float64(item.(Float64))))
+	return buf, nil
+}
+
+func (Float64Codec) DecodeItem(data []byte) (Item, error) {
+	if len(data) != 8 {
+		return nil, errShortBuffer
+	}
+	return Float64(math.Float64frombits(binary.BigEndian.Uint64(data))), nil
+}
+
+//按照Float64.Less的全序比较
+func (Float64Codec) CompareEncoded(a, b []byte) int {
+	if len(a) != 8 || len(b) != 8 {
+		return bytes.Compare(a, b)
+	}
+	fa := float64Key(math.Float64frombits(binary.BigEndian.Uint64(a)))
+	fb := float64Key(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	return compareUint64(fa, fb)
+}
+
+//TimeCodec是Time的Codec，使用time.Time的二进制编码，会保留时区的偏移
+type TimeCodec struct{}
+
+func (TimeCodec) EncodeItem(item Item) ([]byte, error) {
+	return time.Time(item.(Time)).MarshalBinary()
+}
+
+func (TimeCodec) DecodeItem(data []byte) (Item, error) {
+	var t time.Time
+	if err := t.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return Time(t), nil
+}
+
+//TupleCodec是Tuple的Codec，支持元素是内置类型（包括嵌套的Tuple）的Tuple
+//每个元素编码成：类型标记、uvarint长度、元素自己的编码
+type TupleCodec struct{}
+
+var errUnsupportedTupleElem = errors.New("btree: unsupported tuple element type")
+
+//返回内置类型的Codec
+func builtinCodec(rank byte) Codec {
+	switch rank {
+	case rankInt:
+		return IntCodec{}
+	case rankInt64:
+		return Int64Codec{}
+	case rankUint64:
+		return Uint64Codec{}
+	case rankFloat64:
+		return Float64Codec{}
+	case rankString:
+		return StringCodec{}
+	case rankBytes:
+		return BytesCodec{}
+	case rankTime:
+		return TimeCodec{}
+	case rankTuple:
+		return TupleCodec{}
+	}
+	return nil
+}
+
+func (TupleCodec) EncodeItem(item Item) ([]byte, error) {
+	var out []byte
+	var head [1 + binary.MaxVarintLen64]byte
+	for _, elem := range item.(Tuple) {
+		rank := tupleRank(elem)
+		c := builtinCodec(rank)
+		if c == nil {
+			return nil, errUnsupportedTupleElem
+		}
+		data, err := c.EncodeItem(elem)
+		if err != nil {
+			return nil, err
+		}
+		head[0] = rank
+		n := binary.PutUvarint(head[1:], uint64(len(data)))
+		out = append(out, head[:1+n]...)
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+func (TupleCodec) DecodeItem(data []byte) (Item, error) {
+	out := Tuple{}
+	for len(data) > 0 {
+		c := builtinCodec(data[0])
+		if c == nil {
+			return nil, errUnsupportedTupleElem
+		}
+		size, n := binary.Uvarint(data[1:])
+		if n <= 0 || uint64(len(data)-1-n) < size {
+			return nil, errShortBuffer
+		}
+		data = data[1+n:]
+		elem, err := c.DecodeItem(data[:size])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, elem)
+		data = data[size:]
+	}
+	return out, nil
+}
+
+//按名字注册的Codec，用于在文件中只记录Codec的名字，打开文件时再找到对应的Codec
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"int":     IntCodec{},
+		"string":  StringCodec{},
+		"bytes":   BytesCodec{},
+		"int64":   Int64Codec{},
+		"uint64":  Uint64Codec{},
+		"float64": Float64Codec{},
+		"time":    TimeCodec{},
+		"tuple":   TupleCodec{},
+	}
+)
+
+//RegisterCodec用name注册一个Codec，内置类型的Codec已经注册过了
+//Freeze只接受注册过的Codec，并把它的名字写入文件，所以名字和Codec的类型必须一一对应：
+//name已经被使用，或者同一个类型的Codec已经用别的名字注册过时会panic
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, ok := codecs[name]; ok {
+		panic("btree: codec " + name + " already registered")
+	}
+	for other, registered := range codecs {
+		if reflect.TypeOf(registered) == reflect.TypeOf(c) {
+			panic("btree: codec type already registered as " + other)
+		}
+	}
+	codecs[name] = c
+}
+
+//返回name对应的Codec
+func codecByName(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+//返回和c类型相同的已注册Codec的名字
+func codecName(c Codec) (string, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for name, registered := range codecs {
+		if reflect.TypeOf(registered) == reflect.TypeOf(c) {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,602 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//WAL中记录的类型
+const (
+	walInsert byte = 1
+	walDelete byte = 2
+)
+
+//每条WAL记录的头：4字节的长度和4字节的CRC，长度和CRC都只覆盖记录类型和item的编码
+const walHeaderSize = 8
+
+var (
+	//ErrDurableClosed表示DurableBTree已经被关闭
+	ErrDurableClosed = errors.New("btree: durable tree closed")
+	//ErrBadSnapshot表示快照文件损坏
+	ErrBadSnapshot = errors.New("btree: bad snapshot")
+	//ErrMissingWAL表示可用的快照之后缺少一部分WAL，恢复出来的tree会丢失已经提交的修改
+	ErrMissingWAL = errors.New("btree: missing write-ahead log")
+
+	snapshotMagic = []byte("btsnap1\n")
+	crcTable      = crc32.MakeTable(crc32.Castagnoli)
+)
+
+//DurableOptions是DurableBTree的配置，零值表示不自动做checkpoint
+type DurableOptions struct {
+	Degree             int           //tree的degree，为0时使用32
+	CheckpointInterval time.Duration //每隔多久做一次checkpoint，为0时不按时间做checkpoint
+	CheckpointEvery    int           //WAL中累积了多少条记录之后做一次checkpoint，为0时不按记录数做checkpoint
+}
+
+//DurableBTree是一个带有预写日志的内存BTree
+//每次ReplaceOrInsert/Delete都会先修改内存中的tree，再把操作追加到WAL中，等WAL fsync之后才返回，
+//并发的写操作会合并成一次写入和一次fsync（group commit）。
+//checkpoint时用Clone得到tree在某一时刻的快照，并切换到新的WAL文件，然后在不持有锁的情况下把快照序列化到文件中，
+//快照写完之后删除旧的WAL和快照。
+//打开时加载最新的完整快照，再按顺序重放之后的WAL，WAL末尾写了一半的记录（CRC不对或者长度不够）会被截断。
+//
+//目录中的文件：
+//	snapshot-<gen>  包含所有代数小于gen的WAL中的修改
+//	wal-<gen>       快照gen之后的修改
+//
+//写操作返回错误之后，内存中的tree可能已经包含了这次修改，DurableBTree之后的写操作都会返回同一个错误。
+type DurableBTree struct {
+	dir   string
+	codec Codec
+	opts  DurableOptions
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	tree     *BTree
+	gen      uint64   //当前WAL的代数
+	wal      *os.File //当前的WAL
+	buf      []byte   //还没有写入WAL的记录
+	appended uint64   //已经追加到buf中的记录的序号
+	synced   uint64   //已经fsync的记录的序号
+	flushing bool     //是否有写操作正在写WAL
+	records  int      //当前WAL中的记录数
+	err      error    //写WAL失败之后的错误
+	closing  bool     //Close已经开始，不再启动新的checkpoint
+
+	checkpointMu  sync.Mutex //同一时刻只做一次checkpoint
+	checkpointing bool
+	checkpointErr error //后台checkpoint的第一个错误，由下一次Sync或Close返回
+	stop          chan struct{}
+	done          sync.WaitGroup
+}
+
+//OpenDurable打开dir中的DurableBTree，dir不存在时创建它
+//c用来序列化WAL和快照中的item
+func OpenDurable(dir string, c Codec, opts DurableOptions) (*DurableBTree, error) {
+	if opts.Degree == 0 {
+		opts.Degree = 32
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &DurableBTree{dir: dir, codec: c, opts: opts, stop: make(chan struct{})}
+	d.cond = sync.NewCond(&d.mu)
+	if err := d.recover(); err != nil {
+		return nil, err
+	}
+	if opts.CheckpointInterval > 0 {
+		d.done.Add(1)
+		go d.checkpointLoop()
+	}
+	return d, nil
+}
+
+//目录中某一类文件的代数，按升序排列
+func (d *DurableBTree) generations(prefix string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(d.dir, prefix+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	var gens []uint64
+	for _, name := range names {
+		var gen uint64
+		base := filepath.Base(name)
+		if strings.HasSuffix(base, ".tmp") {
+			continue
+		}
+		if _, err := fmt.Sscanf(base, prefix+"-%d", &gen); err == nil {
+			gens = append(gens, gen)
+		}
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+	return gens, nil
+}
+
+func (d *DurableBTree) path(prefix string, gen uint64) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%s-%016d", prefix, gen))
+}
+
+//加载最新的完整快照，重放之后的WAL，并打开最后一个WAL用来追加
+func (d *DurableBTree) recover() error {
+	//checkpoint中途崩溃时留下的临时文件
+	tmps, err := filepath.Glob(filepath.Join(d.dir, "*.tmp"))
+	if err != nil {
+		return err
+	}
+	for _, name := range tmps {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	snapshots, err := d.generations("snapshot")
+	if err != nil {
+		return err
+	}
+	d.tree = New(d.opts.Degree)
+	//从新到旧找到第一个完整的快照，损坏的快照（比如checkpoint时断电）被跳过
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		tree, err := d.loadSnapshot(snapshots[i])
+		if err == nil {
+			d.tree, d.gen = tree, snapshots[i]
+			break
+		}
+		if err != ErrBadSnapshot {
+			return err
+		}
+	}
+	wals, err := d.generations("wal")
+	if err != nil {
+		return err
+	}
+	//快照gen包含了gen之前所有WAL中的修改，之后的WAL必须从gen开始连续，
+	//否则说明较新的快照损坏之后，退回到的快照对应的WAL已经被checkpoint删除了
+	for len(wals) > 0 && wals[0] < d.gen {
+		wals = wals[1:]
+	}
+	if len(wals) == 0 && len(snapshots) > 0 {
+		return ErrMissingWAL
+	}
+	for i, gen := range wals {
+		if gen != d.gen+uint64(i) {
+			return ErrMissingWAL
+		}
+	}
+	for _, gen := range wals {
+		n, err := d.replay(gen)
+		if err != nil {
+			return err
+		}
+		d.gen, d.records = gen, n
+	}
+	d.wal, err = os.OpenFile(d.path("wal", d.gen), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	return syncDir(d.dir)
+}
+
+//读取快照文件：magic、uvarint的item数、每个item的uvarint长度和编码，最后是前面所有内容的CRC
+func (d *DurableBTree) loadSnapshot(gen uint64) (*BTree, error) {
+	data, err := ioutil.ReadFile(d.path("snapshot", gen))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic)+4 || !bytes.HasPrefix(data, snapshotMagic) {
+		return nil, ErrBadSnapshot
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crcTable) != sum {
+		return nil, ErrBadSnapshot
+	}
+	body = body[len(snapshotMagic):]
+	count, n := binary.Uvarint(body)
+	if n <= 0 {
+		return nil, ErrBadSnapshot
+	}
+	body = body[n:]
+	sorted := make(items, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(body)
+		if n <= 0 || uint64(len(body)-n) < size {
+			return nil, ErrBadSnapshot
+		}
+		item, err := d.codec.DecodeItem(body[n : n+int(size)])
+		if err != nil {
+			return nil, ErrBadSnapshot
+		}
+		sorted = append(sorted, item)
+		body = body[n+int(size):]
+	}
+	//快照中的item是升序的，直接批量构建
+	tree := New(d.opts.Degree)
+	if len(sorted) > 0 {
+		tree.root = tree.buildSorted(sorted, true)
+		tree.length = len(sorted)
+	}
+	return tree, nil
+}
+
+//重放一个WAL文件，返回其中完整记录的个数，末尾不完整的记录会被截断
+func (d *DurableBTree) replay(gen uint64) (int, error) {
+	path := d.path("wal", gen)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	offset, count := 0, 0
+	for {
+		rec, size := parseWALRecord(data[offset:])
+		if size == 0 {
+			break
+		}
+		item, err := d.codec.DecodeItem(rec[1:])
+		if err != nil {
+			return 0, err
+		}
+		switch rec[0] {
+		case walInsert:
+			d.tree.ReplaceOrInsert(item)
+		case walDelete:
+			d.tree.Delete(item)
+		default:
+			return 0, fmt.Errorf("btree: bad wal record type %d in %s", rec[0], path)
+		}
+		offset += size
+		count++
+	}
+	if offset < len(data) {
+		//写了一半的记录
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return 0, err
+		}
+		if err := f.Truncate(int64(offset)); err != nil {
+			f.Close()
+			return 0, err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return 0, err
+		}
+		if err := f.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+//解析data开头的一条WAL记录，返回记录内容和它占用的字节数，记录不完整或者CRC不对时返回0
+func parseWALRecord(data []byte) ([]byte, int) {
+	if len(data) < walHeaderSize {
+		return nil, 0
+	}
+	size := binary.BigEndian.Uint32(data)
+	if size == 0 || uint64(len(data)-walHeaderSize) < uint64(size) {
+		return nil, 0
+	}
+	rec := data[walHeaderSize : walHeaderSize+int(size)]
+	if crc32.Checksum(rec, crcTable) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, 0
+	}
+	return rec, walHeaderSize + int(size)
+}
+
+//将一条记录追加到buf中
+func appendWALRecord(buf []byte, typ byte, data []byte) []byte {
+	var head [walHeaderSize]byte
+	start := len(buf)
+	buf = append(buf, head[:]...)
+	buf = append(buf, typ)
+	buf = append(buf, data...)
+	rec := buf[start+walHeaderSize:]
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(rec)))
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.Checksum(rec, crcTable))
+	return buf
+}
+
+//ReplaceOrInsert和BTree.ReplaceOrInsert一样，并且在修改写入WAL之后才返回
+func (d *DurableBTree) ReplaceOrInsert(item Item) (Item, error) {
+	var out Item
+	err := d.write(walInsert, item, func() { out = d.tree.ReplaceOrInsert(item) })
+	return out, err
+}
+
+//Delete和BTree.Delete一样，并且在修改写入WAL之后才返回
+func (d *DurableBTree) Delete(item Item) (Item, error) {
+	var out Item
+	err := d.write(walDelete, item, func() { out = d.tree.Delete(item) })
+	return out, err
+}
+
+//修改tree，把记录追加到buf中，然后等待它被fsync
+func (d *DurableBTree) write(typ byte, item Item, apply func()) error {
+	data, err := d.codec.EncodeItem(item)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	apply()
+	d.buf = appendWALRecord(d.buf, typ, data)
+	d.appended++
+	d.records++
+	seq := d.appended
+	//group commit：没有人在写WAL时由自己把buf中所有的记录一起写入，否则等待正在写的人写完
+	for d.synced < seq && d.err == nil {
+		if d.flushing {
+			d.cond.Wait()
+			continue
+		}
+		d.flushing = true
+		buf, upto, f := d.buf, d.appended, d.wal
+		d.buf = nil
+		d.mu.Unlock()
+		err := writeAndSync(f, buf)
+		d.mu.Lock()
+		d.flushing = false
+		if err != nil {
+			d.err = err
+		} else {
+			d.synced = upto
+		}
+		d.cond.Broadcast()
+	}
+	if d.err == nil && d.opts.CheckpointEvery > 0 && d.records >= d.opts.CheckpointEvery && !d.checkpointing && !d.closing {
+		d.checkpointing = true
+		d.done.Add(1)
+		go func() {
+			defer d.done.Done()
+			d.backgroundCheckpoint()
+		}()
+	}
+	return d.err
+}
+
+func writeAndSync(f *os.File, buf []byte) error {
+	if _, err := f.Write(buf); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+//在持有mu的情况下把buf中剩下的记录写入WAL
+func (d *DurableBTree) flushLocked() error {
+	for d.flushing {
+		d.cond.Wait()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buf) > 0 {
+		if err := writeAndSync(d.wal, d.buf); err != nil {
+			d.err = err
+			return err
+		}
+		d.buf = nil
+		d.synced = d.appended
+		d.cond.Broadcast()
+	}
+	return nil
+}
+
+//后台的checkpoint，记录第一个错误
+func (d *DurableBTree) backgroundCheckpoint() {
+	if err := d.Checkpoint(); err != nil {
+		d.mu.Lock()
+		if d.checkpointErr == nil {
+			d.checkpointErr = err
+		}
+		d.mu.Unlock()
+	}
+}
+
+//Sync把还没有写入的记录写入WAL，并返回上一次Sync之后后台checkpoint遇到的错误
+//后台checkpoint失败不影响已经写入WAL的修改，下一次checkpoint会重新尝试。
+func (d *DurableBTree) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.wal == nil {
+		return ErrDurableClosed
+	}
+	if err := d.flushLocked(); err != nil {
+		return err
+	}
+	err := d.checkpointErr
+	d.checkpointErr = nil
+	return err
+}
+
+//Checkpoint切换到新的WAL，把切换时刻的tree序列化成快照，然后删除旧的WAL和快照
+//写快照的时候不持有锁，写操作可以继续进行
+func (d *DurableBTree) Checkpoint() error {
+	d.checkpointMu.Lock()
+	defer d.checkpointMu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.checkpointing = false
+		d.mu.Unlock()
+	}()
+
+	d.mu.Lock()
+	if d.wal == nil {
+		d.mu.Unlock()
+		return ErrDurableClosed
+	}
+	if err := d.flushLocked(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	gen := d.gen + 1
+	wal, err := os.OpenFile(d.path("wal", gen), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		err = syncDir(d.dir)
+	}
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	old := d.wal
+	d.wal, d.gen, d.records = wal, gen, 0
+	snapshot := d.tree.Clone()
+	d.mu.Unlock()
+	if err := old.Close(); err != nil {
+		return err
+	}
+
+	if err := d.writeSnapshot(gen, snapshot); err != nil {
+		return err
+	}
+	//重命名持久化之前崩溃会丢失新的快照，这时还需要旧的WAL和快照
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+	//快照已经包含了旧WAL中的所有修改
+	for _, prefix := range []string{"wal", "snapshot"} {
+		gens, err := d.generations(prefix)
+		if err != nil {
+			return err
+		}
+		for _, g := range gens {
+			if g < gen {
+				if err := os.Remove(d.path(prefix, g)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return syncDir(d.dir)
+}
+
+//把tree写到临时文件中，fsync之后再重命名成快照文件，这样快照文件要么不存在要么是完整的
+func (d *DurableBTree) writeSnapshot(gen uint64, tree *BTree) error {
+	buf := append([]byte(nil), snapshotMagic...)
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(tree.Len()))]...)
+	var err error
+	tree.Ascend(func(i Item) bool {
+		var data []byte
+		if data, err = d.codec.EncodeItem(i); err != nil {
+			return false
+		}
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)
+		buf = append(buf, data...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf, crcTable))
+	buf = append(buf, sum[:]...)
+
+	path := d.path("snapshot", gen)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := writeAndSync(f, buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//fsync目录，保证文件的创建、重命名和删除已经持久化
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//按照CheckpointInterval定期做checkpoint
+func (d *DurableBTree) checkpointLoop() {
+	defer d.done.Done()
+	ticker := time.NewTicker(d.opts.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.backgroundCheckpoint()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+//Get返回tree中和key相等的item
+func (d *DurableBTree) Get(key Item) Item {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tree.Get(key)
+}
+
+//Has返回tree中是否有和key相等的item
+func (d *DurableBTree) Has(key Item) bool {
+	return d.Get(key) != nil
+}
+
+//Len返回tree中item的个数
+func (d *DurableBTree) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tree.Len()
+}
+
+//Clone返回内存中tree当前的一个克隆，可以在上面做任意的读取和遍历，对它的修改不会写入WAL
+func (d *DurableBTree) Clone() *BTree {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tree.Clone()
+}
+
+//Close等待后台的checkpoint结束，写入剩下的记录并关闭WAL
+//还没有被Sync返回的后台checkpoint错误会由Close返回。
+func (d *DurableBTree) Close() error {
+	d.mu.Lock()
+	if d.wal == nil || d.closing {
+		d.mu.Unlock()
+		return ErrDurableClosed
+	}
+	d.closing = true
+	d.mu.Unlock()
+	close(d.stop)
+	d.done.Wait()
+	d.checkpointMu.Lock()
+	defer d.checkpointMu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.flushLocked()
+	if cerr := d.wal.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = d.checkpointErr
+	}
+	d.wal, d.checkpointErr = nil, nil
+	if d.err == nil {
+		d.err = ErrDurableClosed
+	}
+	return err
+}
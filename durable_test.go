@@ -0,0 +1,253 @@
+package btree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openDurable(t *testing.T, dir string, opts DurableOptions) *DurableBTree {
+	t.Helper()
+	d, err := OpenDurable(dir, IntCodec{}, opts)
+	if err != nil {
+		t.Fatalf("OpenDurable: %v", err)
+	}
+	return d
+}
+
+func durableItems(d *DurableBTree) []Item {
+	return all(d.Clone())
+}
+
+func walFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "wal-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestDurableRecover(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{Degree: 3})
+	for _, v := range perm(200) {
+		if _, err := d.ReplaceOrInsert(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i += 2 {
+		if out, err := d.Delete(Int(i)); err != nil || out != Int(i) {
+			t.Fatalf("Delete(%d) = %v, %v", i, out, err)
+		}
+	}
+	want := durableItems(d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReplaceOrInsert(Int(1)); err != ErrDurableClosed {
+		t.Fatalf("write after Close: %v", err)
+	}
+
+	d = openDurable(t, dir, DurableOptions{Degree: 3})
+	if got := durableItems(d); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered:\n got: %v\nwant: %v", got, want)
+	}
+	//checkpoint之后只剩下新的WAL，重启之后从快照恢复
+	if err := d.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(walFiles(t, dir)); n != 1 {
+		t.Fatalf("%d wal files after checkpoint", n)
+	}
+	d.ReplaceOrInsert(Int(1000))
+	d.Delete(Int(1))
+	want = durableItems(d)
+	d.Close()
+	d = openDurable(t, dir, DurableOptions{Degree: 5})
+	defer d.Close()
+	if got := durableItems(d); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered after checkpoint:\n got: %v\nwant: %v", got, want)
+	}
+	verifyTree(t, d.Clone())
+}
+
+func TestDurableTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	for i := 0; i < 10; i++ {
+		d.ReplaceOrInsert(Int(i))
+	}
+	d.Close()
+	wal := walFiles(t, dir)[0]
+	good, _ := ioutil.ReadFile(wal)
+	//模拟写到一半断电：追加一条只写了一部分的记录
+	torn := appendWALRecord(nil, walInsert, []byte{42})
+	f, _ := os.OpenFile(wal, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write(torn[:len(torn)-1])
+	f.Close()
+
+	d = openDurable(t, dir, DurableOptions{})
+	if got := durableItems(d); !reflect.DeepEqual(got, rang(10)) {
+		t.Fatalf("recovered: %v", got)
+	}
+	if data, _ := ioutil.ReadFile(wal); len(data) != len(good) {
+		t.Fatalf("wal not truncated: %d bytes, want %d", len(data), len(good))
+	}
+	d.ReplaceOrInsert(Int(10))
+	d.Close()
+	d = openDurable(t, dir, DurableOptions{})
+	defer d.Close()
+	if got := durableItems(d); !reflect.DeepEqual(got, rang(11)) {
+		t.Fatalf("recovered after torn write: %v", got)
+	}
+}
+
+func TestDurableBadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	for i := 0; i < 10; i++ {
+		d.ReplaceOrInsert(Int(i))
+	}
+	d.Close()
+	//模拟checkpoint时断电：新的WAL已经创建，但是快照没有写完
+	ioutil.WriteFile(filepath.Join(dir, "snapshot-0000000000000001"), []byte("btsnap1\ngarbage"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "wal-0000000000000001"), nil, 0644)
+	d = openDurable(t, dir, DurableOptions{})
+	defer d.Close()
+	if got := durableItems(d); !reflect.DeepEqual(got, rang(10)) {
+		t.Fatalf("recovered: %v", got)
+	}
+}
+
+func TestDurableGroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{Degree: 4, CheckpointEvery: 100})
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := d.ReplaceOrInsert(Int(w*100 + i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = openDurable(t, dir, DurableOptions{})
+	defer d.Close()
+	if got := durableItems(d); !reflect.DeepEqual(got, rang(800)) {
+		t.Fatalf("recovered %d items", len(got))
+	}
+}
+
+func TestDurableStaleTmp(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	d.ReplaceOrInsert(Int(1))
+	d.Close()
+	//checkpoint写快照时崩溃留下的临时文件
+	tmp := filepath.Join(dir, "snapshot-0000000000000001.tmp")
+	ioutil.WriteFile(tmp, []byte("btsnap1\n"), 0644)
+	d = openDurable(t, dir, DurableOptions{})
+	defer d.Close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("stale tmp file: %v", err)
+	}
+	if got := durableItems(d); !reflect.DeepEqual(got, rang(2)[1:]) {
+		t.Fatalf("recovered: %v", got)
+	}
+}
+
+func TestDurableBackgroundCheckpointError(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{CheckpointEvery: 5})
+	//让下一次checkpoint无法创建临时快照文件
+	block := filepath.Join(dir, "snapshot-0000000000000001.tmp")
+	if err := os.MkdirAll(filepath.Join(block, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := d.ReplaceOrInsert(Int(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.done.Wait()
+	if err := d.Sync(); err == nil {
+		t.Fatal("Sync did not return the checkpoint error")
+	}
+	if err := d.Sync(); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	//之前的WAL和快照都还在，修改没有丢失
+	if err := d.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(block)
+	block = filepath.Join(dir, "snapshot-0000000000000003.tmp")
+	os.MkdirAll(filepath.Join(block, "x"), 0755)
+	for i := 5; i < 11; i++ {
+		d.ReplaceOrInsert(Int(i))
+	}
+	if err := d.Close(); err == nil {
+		t.Fatal("Close did not return the checkpoint error")
+	}
+	os.RemoveAll(block)
+	d = openDurable(t, dir, DurableOptions{})
+	defer d.Close()
+	if got := durableItems(d); !reflect.DeepEqual(got, rang(11)) {
+		t.Fatalf("recovered: %v", got)
+	}
+}
+
+func TestDurableConcurrentClose(t *testing.T) {
+	d := openDurable(t, t.TempDir(), DurableOptions{CheckpointInterval: time.Hour})
+	d.ReplaceOrInsert(Int(1))
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() { errs <- d.Close() }()
+	}
+	closed := 0
+	for i := 0; i < 8; i++ {
+		switch err := <-errs; err {
+		case nil:
+			closed++
+		case ErrDurableClosed:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("%d Close calls succeeded", closed)
+	}
+}
+
+func TestDurableMissingWAL(t *testing.T) {
+	dir := t.TempDir()
+	d := openDurable(t, dir, DurableOptions{})
+	for i := 0; i < 10; i++ {
+		d.ReplaceOrInsert(Int(i))
+	}
+	if err := d.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	d.ReplaceOrInsert(Int(10))
+	if err := d.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	//最新的快照损坏，更早的快照和WAL已经被checkpoint删除，不能恢复成空的tree
+	ioutil.WriteFile(filepath.Join(dir, "snapshot-0000000000000002"), []byte("btsnap1\ngarbage"), 0644)
+	if _, err := OpenDurable(dir, IntCodec{}, DurableOptions{}); err != ErrMissingWAL {
+		t.Fatalf("OpenDurable: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package btree
+
+import (
+	"sync"
+	"time"
+)
+
+//Clock是ExpiringTree使用的时钟，测试时可以替换成手动推进的时钟
+type Clock interface {
+	//当前时间
+	Now() time.Time
+	//返回每隔d触发一次的channel，以及停止它的函数
+	Ticker(d time.Duration) (<-chan time.Time, func())
+}
+
+//使用系统时间的Clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+//主tree中的item和它的过期时间，按item排序
+type expiringEntry struct {
+	item    Item
+	expires time.Time //零值表示永不过期
+}
+
+func (a expiringEntry) Less(b Item) bool {
+	return a.item.Less(b.(expiringEntry).item)
+}
+
+//过期时间索引中的key，先按过期时间再按item排序，item为nil时排在同一时间的所有item之前
+type expiryKey struct {
+	at   time.Time
+	item Item
+}
+
+func (a expiryKey) Less(b Item) bool {
+	bk := b.(expiryKey)
+	if !a.at.Equal(bk.at) {
+		return a.at.Before(bk.at)
+	}
+	if a.item == nil || bk.item == nil {
+		return a.item == nil && bk.item != nil
+	}
+	return a.item.Less(bk.item)
+}
+
+//ExpiringTree是item可以带有过期时间的tree，适合用作缓存
+//除了按item排序的主tree之外，还有一个按过期时间排序的索引，Reap用一次范围删除清除所有过期的item。
+//过期但还没有被Reap清除的item对Get、Has和Ascend不可见，但是仍然计入Len。
+//可以用StartReaper启动一个定期调用Reap的goroutine，所有方法都可以并发调用。
+type ExpiringTree struct {
+	mu     sync.RWMutex
+	items  *BTree //expiringEntry
+	expiry *BTree //expiryKey，只包含有过期时间的item
+	clock  Clock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+//NewExpiringTree创建一个ExpiringTree，clock为nil时使用系统时间
+func NewExpiringTree(degree int, clock Clock) *ExpiringTree {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &ExpiringTree{items: New(degree), expiry: New(degree), clock: clock}
+}
+
+//ReplaceOrInsert插入一个永不过期的item，返回被替换的item，被替换的item已经过期时也返回它
+func (e *ExpiringTree) ReplaceOrInsert(item Item) Item {
+	return e.ReplaceOrInsertTTL(item, 0)
+}
+
+//ReplaceOrInsertTTL插入一个在ttl之后过期的item，ttl<=0表示永不过期，返回被替换的item
+func (e *ExpiringTree) ReplaceOrInsertTTL(item Item, ttl time.Duration) Item {
+	if item == nil {
+		panic("nil item being added to ExpiringTree")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	entry := expiringEntry{item: item}
+	if ttl > 0 {
+		entry.expires = e.clock.Now().Add(ttl)
+		e.expiry.ReplaceOrInsert(expiryKey{at: entry.expires, item: item})
+	}
+	old := e.items.ReplaceOrInsert(entry)
+	if old == nil {
+		return nil
+	}
+	oldEntry := old.(expiringEntry)
+	if !oldEntry.expires.IsZero() && !oldEntry.expires.Equal(entry.expires) {
+		e.expiry.Delete(expiryKey{at: oldEntry.expires, item: oldEntry.item})
+	}
+	return oldEntry.item
+}
+
+//SetTTL把和item相等的item的过期时间设置成ttl之后，ttl<=0表示永不过期
+//item不存在或者已经过期时返回false
+func (e *ExpiringTree) SetTTL(item Item, ttl time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.clock.Now()
+	got := e.items.Get(expiringEntry{item: item})
+	if got == nil || expired(got.(expiringEntry), now) {
+		return false
+	}
+	entry := got.(expiringEntry)
+	if !entry.expires.IsZero() {
+		e.expiry.Delete(expiryKey{at: entry.expires, item: entry.item})
+	}
+	entry.expires = time.Time{}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+		e.expiry.ReplaceOrInsert(expiryKey{at: entry.expires, item: entry.item})
+	}
+	e.items.ReplaceOrInsert(entry)
+	return true
+}
+
+//TTL返回和item相等的item还有多久过期，永不过期时返回0和true，不存在或者已经过期时返回false
+func (e *ExpiringTree) TTL(item Item) (time.Duration, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	now := e.clock.Now()
+	got := e.items.Get(expiringEntry{item: item})
+	if got == nil || expired(got.(expiringEntry), now) {
+		return 0, false
+	}
+	if expires := got.(expiringEntry).expires; !expires.IsZero() {
+		return expires.Sub(now), true
+	}
+	return 0, true
+}
+
+//在now时entry是否已经过期
+func expired(entry expiringEntry, now time.Time) bool {
+	return !entry.expires.IsZero() && !now.Before(entry.expires)
+}
+
+//Get返回和key相等并且没有过期的item
+func (e *ExpiringTree) Get(key Item) Item {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	got := e.items.Get(expiringEntry{item: key})
+	if got == nil || expired(got.(expiringEntry), e.clock.Now()) {
+		return nil
+	}
+	return got.(expiringEntry).item
+}
+
+//Has表示是否存在和key相等并且没有过期的item
+func (e *ExpiringTree) Has(key Item) bool {
+	return e.Get(key) != nil
+}
+
+//Delete删除和item相等的item并返回它，已经过期的item也会被删除并返回
+func (e *ExpiringTree) Delete(item Item) Item {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old := e.items.Delete(expiringEntry{item: item})
+	if old == nil {
+		return nil
+	}
+	entry := old.(expiringEntry)
+	if !entry.expires.IsZero() {
+		e.expiry.Delete(expiryKey{at: entry.expires, item: entry.item})
+	}
+	return entry.item
+}
+
+//Len返回item的个数，包括已经过期但还没有被Reap清除的item
+func (e *ExpiringTree) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.items.Len()
+}
+
+//Ascend按升序遍历所有没有过期的item，直到iterator返回false，iterator中不能修改ExpiringTree
+func (e *ExpiringTree) Ascend(iterator ItemIterator) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	now := e.clock.Now()
+	e.items.Ascend(func(i Item) bool {
+		entry := i.(expiringEntry)
+		if expired(entry, now) {
+			return true
+		}
+		return iterator(entry.item)
+	})
+}
+
+//Reap删除所有在now时已经过期的item，返回删除的个数
+//过期时间索引中所有过期的key都在开头的一段范围中，用一次DeleteFunc删除，再用ApplyBatch从主tree中删除对应的item。
+func (e *ExpiringTree) Reap(now time.Time) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var ops []Op
+	e.expiry.DeleteFunc(nil, expiryKey{at: now.Add(time.Nanosecond)}, func(i Item) bool {
+		ops = append(ops, Op{Kind: OpDelete, Item: expiringEntry{item: i.(expiryKey).item}})
+		return true
+	})
+	if len(ops) > 0 {
+		e.items.ApplyBatch(ops)
+	}
+	return len(ops)
+}
+
+//StartReaper启动一个每隔interval用Clock的当前时间调用一次Reap的goroutine，已经启动时什么也不做
+func (e *ExpiringTree) StartReaper(interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stop != nil {
+		return
+	}
+	ticks, stopTicker := e.clock.Ticker(interval)
+	stop, done := make(chan struct{}), make(chan struct{})
+	e.stop, e.done = stop, done
+	go func() {
+		defer close(done)
+		defer stopTicker()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticks:
+				e.Reap(e.clock.Now())
+			}
+		}
+	}()
+}
+
+//StopReaper停止StartReaper启动的goroutine并等待它退出
+func (e *ExpiringTree) StopReaper() {
+	e.mu.Lock()
+	stop, done := e.stop, e.done
+	e.stop, e.done = nil, nil
+	e.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
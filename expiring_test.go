@@ -0,0 +1,114 @@
+package btree
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeClock只在测试调用advance时前进，并且由测试发送tick
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	ticks chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0), ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	return c.ticks, func() {}
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func allExpiring(e *ExpiringTree) (out []Item) {
+	e.Ascend(func(i Item) bool {
+		out = append(out, i)
+		return true
+	})
+	return out
+}
+
+func TestExpiringTree(t *testing.T) {
+	clock := newFakeClock()
+	e := NewExpiringTree(3, clock)
+	for i := 0; i < 10; i++ {
+		e.ReplaceOrInsertTTL(Int(i), time.Duration(i+1)*time.Second)
+	}
+	e.ReplaceOrInsert(Int(100))
+	if ttl, ok := e.TTL(Int(4)); !ok || ttl != 5*time.Second {
+		t.Fatalf("ttl %v %v", ttl, ok)
+	}
+
+	clock.advance(3 * time.Second)
+	if e.Get(Int(2)) != nil || e.Has(Int(0)) || e.Get(Int(3)) == nil {
+		t.Fatalf("expired items visible")
+	}
+	if got, want := allExpiring(e), []Item{Int(3), Int(4), Int(5), Int(6), Int(7), Int(8), Int(9), Int(100)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ascend %v, want %v", got, want)
+	}
+	if e.SetTTL(Int(1), time.Hour) {
+		t.Fatalf("SetTTL revived an expired item")
+	}
+	if !e.SetTTL(Int(5), 0) || !e.SetTTL(Int(100), time.Second) {
+		t.Fatalf("SetTTL failed")
+	}
+	if e.Len() != 11 {
+		t.Fatalf("len %d before reap", e.Len())
+	}
+	if n := e.Reap(clock.Now()); n != 3 {
+		t.Fatalf("reaped %d, want 3", n)
+	}
+	if e.Len() != 8 {
+		t.Fatalf("len %d after reap", e.Len())
+	}
+
+	//用新的TTL重新插入时必须删除原来的过期时间
+	e.ReplaceOrInsertTTL(Int(3), time.Hour)
+	clock.advance(time.Minute)
+	if n := e.Reap(clock.Now()); n != 6 {
+		t.Fatalf("reaped %d, want 6", n)
+	}
+	if got, want := allExpiring(e), []Item{Int(3), Int(5)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after reap %v, want %v", got, want)
+	}
+	if e.Delete(Int(3)) == nil || e.Reap(clock.Now().Add(2*time.Hour)) != 0 {
+		t.Fatalf("deleted item left an expiry behind")
+	}
+}
+
+func TestExpiringTreeReaper(t *testing.T) {
+	clock := newFakeClock()
+	e := NewExpiringTree(3, clock)
+	for i := 0; i < 100; i++ {
+		e.ReplaceOrInsertTTL(Int(i), time.Duration(i%2+1)*time.Second)
+	}
+	e.StartReaper(time.Second)
+	clock.advance(time.Second)
+	//第一次Reap完成之后第二次发送才会完成
+	clock.ticks <- clock.Now()
+	clock.ticks <- clock.Now()
+	if e.Len() != 50 {
+		t.Fatalf("len %d after first reap", e.Len())
+	}
+	clock.advance(time.Second)
+	clock.ticks <- clock.Now()
+	e.StopReaper()
+	if e.Len() != 0 {
+		t.Fatalf("len %d after second reap", e.Len())
+	}
+	e.StopReaper()
+}
@@ -0,0 +1,171 @@
+package btree
+
+//History在一个BTree上提供撤销和重做，每个checkpoint都是一次Clone，只会复制之后被修改的节点
+//checkpoint按时间排成一条线：Undo和Redo在相邻的checkpoint之间移动，Goto跳到指定label的checkpoint，
+//它们都把Tree恢复成那个checkpoint的内容，上一个checkpoint之后还没有记录的修改会被丢弃。
+//在撤销之后调用Checkpoint会丢弃当前位置之后的所有checkpoint，和编辑器的行为一样。
+//
+//checkpoint超过上限时驱逐最老的一个，只被它引用的节点会被放回tree的FreeList。
+//为了保证这些节点没有被别的tree引用，一旦发现Tree被History之外的Clone共享过，之后就不再回收节点，只交给GC。
+//恢复checkpoint时直接替换Tree的内容，不会通知Observer。
+type History struct {
+	tree    *BTree
+	max     int
+	snaps   []checkpoint
+	pos     int                 //Tree当前所在的checkpoint，没有checkpoint时为-1
+	working *copyOnWriteContext //History分配给Tree的写上下文，不一样说明Tree被Clone过
+	epoch   uint64              //最后分配的代数
+	escaped bool                //Tree被History之外的Clone共享过
+}
+
+//一个checkpoint
+type checkpoint struct {
+	label string
+	tree  *BTree
+	after uint64 //这个checkpoint之后创建的节点的写上下文的代数都不小于after
+}
+
+//NewHistory创建在t上记录历史的History，最多保留maxCheckpoints个checkpoint，小于1时不限制
+//t之后只能通过History.Tree修改，不应该再Clone它。
+func NewHistory(t *BTree, maxCheckpoints int) *History {
+	h := &History{tree: t, max: maxCheckpoints, pos: -1}
+	h.newWorkingContext()
+	return h
+}
+
+//给Tree分配一个新的写上下文，之后的修改创建的节点都属于它，原来的节点都变成只读的
+func (h *History) newWorkingContext() {
+	cow := *h.tree.cow
+	h.epoch++
+	cow.epoch = h.epoch
+	h.tree.cow = &cow
+	h.working = h.tree.cow
+}
+
+//检查Tree是否被History之外的Clone共享过
+func (h *History) checkEscaped() {
+	if h.tree.cow != h.working {
+		h.escaped = true
+	}
+}
+
+//Tree返回被记录历史的tree，Undo、Redo和Goto之后仍然是同一个*BTree
+func (h *History) Tree() *BTree {
+	return h.tree
+}
+
+//Checkpoint用label记录Tree当前的内容，label可以重复，Goto时使用最新的一个
+func (h *History) Checkpoint(label string) {
+	h.checkEscaped()
+	for i := h.pos + 1; i < len(h.snaps); i++ {
+		h.snaps[i] = checkpoint{}
+	}
+	h.snaps = h.snaps[:h.pos+1]
+	snap := h.tree.Clone()
+	h.newWorkingContext()
+	h.snaps = append(h.snaps, checkpoint{label: label, tree: snap, after: h.epoch})
+	h.pos = len(h.snaps) - 1
+	for h.max > 0 && len(h.snaps) > h.max {
+		h.evict()
+	}
+}
+
+//Undo恢复到上一个checkpoint，已经在最早的checkpoint时返回false
+func (h *History) Undo() bool {
+	if h.pos <= 0 {
+		return false
+	}
+	h.restore(h.pos - 1)
+	return true
+}
+
+//Redo恢复到下一个checkpoint，已经在最新的checkpoint时返回false
+func (h *History) Redo() bool {
+	if h.pos+1 >= len(h.snaps) {
+		return false
+	}
+	h.restore(h.pos + 1)
+	return true
+}
+
+//Goto恢复到最新的一个标记为label的checkpoint，没有时返回false
+func (h *History) Goto(label string) bool {
+	for i := len(h.snaps) - 1; i >= 0; i-- {
+		if h.snaps[i].label == label {
+			h.restore(i)
+			return true
+		}
+	}
+	return false
+}
+
+//Labels按时间顺序返回所有保留的checkpoint的label
+func (h *History) Labels() []string {
+	out := make([]string, len(h.snaps))
+	for i, s := range h.snaps {
+		out[i] = s.label
+	}
+	return out
+}
+
+//Current返回Tree当前所在的checkpoint的label，还没有checkpoint时返回false
+func (h *History) Current() (string, bool) {
+	if h.pos < 0 {
+		return "", false
+	}
+	return h.snaps[h.pos].label, true
+}
+
+//把Tree的内容替换成第i个checkpoint
+func (h *History) restore(i int) {
+	h.checkEscaped()
+	s := h.snaps[i].tree
+	h.tree.root, h.tree.length = s.root, s.length
+	h.newWorkingContext()
+	h.pos = i
+}
+
+//驱逐最老的checkpoint，把只被它引用的节点放回FreeList
+//checkpoint是一条线，在第0个和第1个checkpoint中都存在的节点一定也在它们之间的每一个版本中，
+//所以第1个checkpoint中在第0个之前就存在的节点（写上下文的代数小于第0个的after）一定和第0个共享，
+//从第1个的root向下只需要展开之后新建的节点，就能找到所有共享的节点，其余的都只被第0个引用。
+func (h *History) evict() {
+	old, next := h.snaps[0], h.snaps[1]
+	h.snaps[0] = checkpoint{}
+	h.snaps = h.snaps[1:]
+	h.pos--
+	if h.escaped || old.tree.root == nil {
+		return
+	}
+	shared := make(map[*node]bool)
+	var mark func(n *node)
+	mark = func(n *node) {
+		if n.cow.epoch == 0 || n.cow.epoch < old.after {
+			shared[n] = true
+			return
+		}
+		for _, child := range n.children {
+			mark(child)
+		}
+	}
+	if next.tree.root != nil {
+		mark(next.tree.root)
+	}
+	var release func(n *node) bool
+	release = func(n *node) bool {
+		if shared[n] {
+			return true
+		}
+		for _, child := range n.children {
+			if !release(child) {
+				return false
+			}
+		}
+		//History之外的节点可能被别的tree引用
+		if n.cow.epoch == 0 {
+			return true
+		}
+		return n.cow.freeNode(n) != ftFreelistFull
+	}
+	release(old.tree.root)
+}
@@ -0,0 +1,118 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(New(3), 0)
+	tr := h.Tree()
+	if h.Undo() || h.Redo() {
+		t.Fatalf("undo/redo without checkpoints")
+	}
+	h.Checkpoint("empty")
+	for i := 0; i < 10; i++ {
+		tr.ReplaceOrInsert(Int(i))
+	}
+	h.Checkpoint("ten")
+	tr.Delete(Int(5))
+	h.Checkpoint("nine")
+	tr.ReplaceOrInsert(Int(100)) //没有checkpoint，Undo时丢弃
+
+	if !h.Undo() || !reflect.DeepEqual(all(tr), rang(10)) {
+		t.Fatalf("undo to ten: %v", all(tr))
+	}
+	if !h.Undo() || tr.Len() != 0 || h.Undo() {
+		t.Fatalf("undo to empty: %v", all(tr))
+	}
+	if !h.Redo() || !reflect.DeepEqual(all(tr), rang(10)) {
+		t.Fatalf("redo to ten: %v", all(tr))
+	}
+	if !h.Goto("nine") || tr.Len() != 9 || tr.Has(Int(5)) || h.Redo() {
+		t.Fatalf("goto nine: %v", all(tr))
+	}
+	if h.Goto("missing") {
+		t.Fatalf("goto missing label")
+	}
+	h.Goto("ten")
+	tr.ReplaceOrInsert(Int(10))
+	h.Checkpoint("eleven")
+	if got, want := h.Labels(), []string{"empty", "ten", "eleven"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("labels %v, want %v", got, want)
+	}
+	if label, _ := h.Current(); label != "eleven" || h.Redo() {
+		t.Fatalf("current %q after checkpoint", label)
+	}
+	if h.Tree() != tr {
+		t.Fatalf("tree pointer changed")
+	}
+}
+
+func TestHistoryEviction(t *testing.T) {
+	f := NewFreeList(1 << 16)
+	h := NewHistory(NewWithFreeList(3, f), 4)
+	tr := h.Tree()
+	var want [][]Item
+	freed := 0
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 20; i++ {
+			v := Int(rand.Intn(500))
+			if rand.Intn(3) == 0 {
+				tr.Delete(v)
+			} else {
+				tr.ReplaceOrInsert(v)
+			}
+		}
+		before := len(f.freelist)
+		h.Checkpoint(fmt.Sprint(round))
+		freed += len(f.freelist) - before
+		want = append(want, all(tr))
+	}
+	if freed == 0 {
+		t.Fatalf("evicted checkpoints returned no nodes to the freelist")
+	}
+	//这些修改会重用释放的节点，保留下来的checkpoint不能受到影响
+	for i := 0; i < 200; i++ {
+		tr.ReplaceOrInsert(Int(1000 + i))
+	}
+	if got := h.Labels(); !reflect.DeepEqual(got, []string{"46", "47", "48", "49"}) {
+		t.Fatalf("labels %v", got)
+	}
+	for i := 49; i >= 46; i-- {
+		h.Goto(fmt.Sprint(i))
+		if got := all(tr); !reflect.DeepEqual(got, want[i]) {
+			t.Fatalf("checkpoint %d: got %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestHistoryEscapedClone(t *testing.T) {
+	f := NewFreeList(1 << 16)
+	h := NewHistory(NewWithFreeList(3, f), 2)
+	tr := h.Tree()
+	h.Checkpoint("a")
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	h.Checkpoint("b")
+	outside := tr.Clone()
+	tr.Delete(Int(1))
+	h.Checkpoint("c")
+	for i := 0; i < 100; i++ {
+		tr.Delete(Int(i))
+	}
+	before := len(f.freelist)
+	h.Checkpoint("d") // evicts "b", which shares nodes with outside
+	if len(f.freelist) != before {
+		t.Fatalf("freed %d nodes that a clone may share", len(f.freelist)-before)
+	}
+	for i := 0; i < 100; i++ {
+		tr.ReplaceOrInsert(Int(-i))
+	}
+	if !reflect.DeepEqual(all(outside), rang(100)) {
+		t.Fatalf("outside clone corrupted")
+	}
+}
@@ -0,0 +1,79 @@
+package btree
+
+import "context"
+
+//ItemErrIterator和ItemIterator一样处理每一个item，返回非nil的error时停止遍历，并把error返回给调用方
+type ItemErrIterator func(i Item) error
+
+//将ctx和iterator包装成ItemIterator，遍历停止的原因保存在err中
+//每处理一个item之前都检查一次ctx是否已经取消
+func ctxIterator(ctx context.Context, iterator ItemErrIterator, err *error) ItemIterator {
+	done := ctx.Done()
+	return func(i Item) bool {
+		select {
+		case <-done:
+			*err = ctx.Err()
+			return false
+		default:
+		}
+		if e := iterator(i); e != nil {
+			*err = e
+			return false
+		}
+		return true
+	}
+}
+
+//根据ctx执行iterate，返回ctx的错误或者iterator返回的错误
+func (t *BTree) iterateCtx(ctx context.Context, dir direction, start, stop Item, includeStart bool, iterator ItemErrIterator) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t.root == nil {
+		return nil
+	}
+	var err error
+	t.root.iterate(dir, start, stop, includeStart, false, ctxIterator(ctx, iterator, &err))
+	return err
+}
+
+//AscendRangeCtx和AscendRange一样升序处理[greaterOrEqual, lessThan)范围内的item，
+//ctx取消或者iterator返回error的时候停止，并返回对应的error
+func (t *BTree) AscendRangeCtx(ctx context.Context, greaterOrEqual, lessThan Item, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, ascend, greaterOrEqual, lessThan, true, iterator)
+}
+
+//AscendLessThanCtx是可以取消的AscendLessThan
+func (t *BTree) AscendLessThanCtx(ctx context.Context, pivot Item, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, ascend, nil, pivot, false, iterator)
+}
+
+//AscendGreaterOrEqualCtx是可以取消的AscendGreaterOrEqual
+func (t *BTree) AscendGreaterOrEqualCtx(ctx context.Context, pivot Item, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, ascend, pivot, nil, true, iterator)
+}
+
+//AscendCtx是可以取消的Ascend
+func (t *BTree) AscendCtx(ctx context.Context, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, ascend, nil, nil, false, iterator)
+}
+
+//DescendRangeCtx是可以取消的DescendRange
+func (t *BTree) DescendRangeCtx(ctx context.Context, lessOrEqual, greaterThan Item, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, descend, lessOrEqual, greaterThan, true, iterator)
+}
+
+//DescendLessOrEqualCtx是可以取消的DescendLessOrEqual
+func (t *BTree) DescendLessOrEqualCtx(ctx context.Context, pivot Item, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, descend, pivot, nil, true, iterator)
+}
+
+//DescendGreaterThanCtx是可以取消的DescendGreaterThan
+func (t *BTree) DescendGreaterThanCtx(ctx context.Context, pivot Item, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, descend, nil, pivot, false, iterator)
+}
+
+//DescendCtx是可以取消的Descend
+func (t *BTree) DescendCtx(ctx context.Context, iterator ItemErrIterator) error {
+	return t.iterateCtx(ctx, descend, nil, nil, false, iterator)
+}
@@ -0,0 +1,79 @@
+package btree
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIterateCtx(t *testing.T) {
+	tr := New(3)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	var got []Item
+	collect := func(a Item) error {
+		got = append(got, a)
+		return nil
+	}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		scan func() error
+		want []Item
+	}{
+		{"AscendRangeCtx", func() error { return tr.AscendRangeCtx(ctx, Int(40), Int(60), collect) }, rang(100)[40:60]},
+		{"AscendLessThanCtx", func() error { return tr.AscendLessThanCtx(ctx, Int(60), collect) }, rang(100)[:60]},
+		{"AscendGreaterOrEqualCtx", func() error { return tr.AscendGreaterOrEqualCtx(ctx, Int(40), collect) }, rang(100)[40:]},
+		{"AscendCtx", func() error { return tr.AscendCtx(ctx, collect) }, rang(100)},
+		{"DescendRangeCtx", func() error { return tr.DescendRangeCtx(ctx, Int(60), Int(40), collect) }, rangrev(100)[39:59]},
+		{"DescendLessOrEqualCtx", func() error { return tr.DescendLessOrEqualCtx(ctx, Int(40), collect) }, rangrev(100)[59:]},
+		{"DescendGreaterThanCtx", func() error { return tr.DescendGreaterThanCtx(ctx, Int(40), collect) }, rangrev(100)[:59]},
+		{"DescendCtx", func() error { return tr.DescendCtx(ctx, collect) }, rangrev(100)},
+	} {
+		got = got[:0]
+		if err := tc.scan(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s:\n got: %v\nwant: %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIterateCtxStops(t *testing.T) {
+	tr := New(3)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	errStop := errors.New("stop")
+	n := 0
+	err := tr.AscendCtx(context.Background(), func(a Item) error {
+		if n++; a.(Int) == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 11 {
+		t.Fatalf("got err=%v after %d items", err, n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err = tr.DescendCtx(ctx, func(a Item) error {
+		if n++; n == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || n != 5 {
+		t.Fatalf("got err=%v after %d items", err, n)
+	}
+	if err := tr.AscendCtx(ctx, func(Item) error { t.Fatal("called after cancel"); return nil }); err != context.Canceled {
+		t.Fatalf("canceled context: got %v", err)
+	}
+	if err := New(2).AscendCtx(context.Background(), func(Item) error { return errStop }); err != nil {
+		t.Fatalf("empty tree: got %v", err)
+	}
+}
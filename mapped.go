@@ -0,0 +1,577 @@
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync"
+)
+
+var (
+	//ErrBadMappedFile表示冻结文件的格式不对或者已经损坏
+	ErrBadMappedFile = errors.New("btree: bad mapped file")
+	//ErrUnregisteredCodec表示Freeze使用的Codec没有用RegisterCodec注册
+	ErrUnregisteredCodec = errors.New("btree: codec not registered")
+
+	frozenMagic = []byte("btfrz1\n\x00")
+)
+
+//冻结文件的格式：
+//	头部：magic、uint64的item数、root节点的偏移（0表示空tree）、所有节点的CRC、Codec名字的长度和名字、前面所有内容的CRC
+//	节点：uint32的item数、uint32的子节点数、每个子节点的uint64偏移、每个item编码结束位置的uint32、所有item的编码
+//节点按照后序写入，子节点总是在父节点之前，所有整数都是大端序。
+//
+//Freeze把t当前的内容写入path，t的节点结构原样保留，写入时不能修改t，可以对Clone调用Freeze。
+//文件先写到临时文件中，fsync之后再重命名成path。
+func Freeze(t *BTree, path string, c Codec) error {
+	name, ok := codecName(c)
+	if !ok {
+		return ErrUnregisteredCodec
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path + ".tmp")
+	header := frozenHeader(0, 0, 0, name)
+	w := &frozenWriter{w: bufio.NewWriter(f), off: uint64(len(header)), codec: c}
+	//先占住头部的位置，写完所有节点之后再回来写入真正的头部
+	w.write(header)
+	w.crc = 0
+	var root uint64
+	if t.root != nil && len(t.root.items) > 0 {
+		root = w.writeNode(t.root)
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err == nil {
+		_, w.err = f.WriteAt(frozenHeader(t.Len(), root, w.crc, name), 0)
+	}
+	if w.err == nil {
+		w.err = f.Sync()
+	}
+	if cerr := f.Close(); w.err == nil {
+		w.err = cerr
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//头部的长度只和Codec名字有关，item数、root偏移和CRC使用定长编码
+func frozenHeader(length int, root uint64, body uint32, name string) []byte {
+	buf := append([]byte(nil), frozenMagic...)
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], uint64(length))
+	buf = append(buf, tmp[:]...)
+	binary.BigEndian.PutUint64(tmp[:], root)
+	buf = append(buf, tmp[:]...)
+	binary.BigEndian.PutUint32(tmp[:], body)
+	buf = append(buf, tmp[:4]...)
+	binary.BigEndian.PutUint16(tmp[:], uint16(len(name)))
+	buf = append(buf, tmp[:2]...)
+	buf = append(buf, name...)
+	binary.BigEndian.PutUint32(tmp[:], crc32.Checksum(buf, crcTable))
+	return append(buf, tmp[:4]...)
+}
+
+//记录写入偏移和CRC的writer，第一个错误之后不再写入
+type frozenWriter struct {
+	w     *bufio.Writer
+	off   uint64
+	crc   uint32
+	codec Codec
+	err   error
+	tmp   [8]byte
+}
+
+func (w *frozenWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = crc32.Update(w.crc, crcTable, data)
+	_, w.err = w.w.Write(data)
+}
+
+func (w *frozenWriter) uint32(v uint32) {
+	binary.BigEndian.PutUint32(w.tmp[:], v)
+	w.write(w.tmp[:4])
+}
+
+//后序写入以n为根的子树，返回n的偏移
+func (w *frozenWriter) writeNode(n *node) uint64 {
+	offsets := make([]uint64, len(n.children))
+	for i, child := range n.children {
+		offsets[i] = w.writeNode(child)
+	}
+	encoded := make([][]byte, len(n.items))
+	size := 8 + 8*len(offsets) + 4*len(n.items)
+	for i, item := range n.items {
+		data, err := w.codec.EncodeItem(item)
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		encoded[i] = data
+		size += len(data)
+	}
+	off := w.off
+	w.uint32(uint32(len(n.items)))
+	w.uint32(uint32(len(n.children)))
+	for _, o := range offsets {
+		binary.BigEndian.PutUint64(w.tmp[:], o)
+		w.write(w.tmp[:])
+	}
+	end := uint32(0)
+	for _, data := range encoded {
+		end += uint32(len(data))
+		w.uint32(end)
+	}
+	for _, data := range encoded {
+		w.write(data)
+	}
+	w.off += uint64(size)
+	return off
+}
+
+//MappedTree是用OpenMapped打开的只读tree
+//节点不会被加载到堆上，查找和遍历时直接在映射的字节上定位节点。
+//Codec实现了OrderedCodec时直接比较映射的字节，只有交给调用者的item才会解码；否则比较时也需要解码。
+//映射的文件在Close之前不能被修改或者截断。MappedTree可以被多个goroutine并发读取。
+//
+//打开时只校验头部，节点中的偏移在访问节点时才检查，遇到越界的偏移或者无法解码的item时，
+//查找返回nil、遍历提前结束，第一个错误由Err返回。需要完整校验时调用Verify。
+type MappedTree struct {
+	data    []byte
+	unmap   func([]byte) error
+	codec   Codec
+	ordered OrderedCodec //codec实现了OrderedCodec时不为nil
+	length  int
+	root    uint64
+	start   uint64 //第一个节点的偏移
+	body    uint32 //所有节点的CRC，只在Verify中使用
+
+	mu  sync.Mutex
+	err error //访问节点时遇到的第一个错误
+}
+
+//OpenMapped用mmap打开Freeze写入的文件
+//打开时只校验头部的CRC和root的偏移，不会读取节点，所以打开的耗时和文件大小无关。
+func OpenMapped(path string) (*MappedTree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(len(frozenMagic))+26 || int64(int(size)) != size {
+		return nil, ErrBadMappedFile
+	}
+	data, unmap, err := mmapFile(f, int(size))
+	if err != nil {
+		return nil, err
+	}
+	m := &MappedTree{data: data, unmap: unmap}
+	if err := m.parseHeader(); err != nil {
+		unmap(data)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *MappedTree) parseHeader() error {
+	d := m.data
+	if !bytes.HasPrefix(d, frozenMagic) {
+		return ErrBadMappedFile
+	}
+	p := len(frozenMagic)
+	length, root := binary.BigEndian.Uint64(d[p:]), binary.BigEndian.Uint64(d[p+8:])
+	body := binary.BigEndian.Uint32(d[p+16:])
+	nameLen := int(binary.BigEndian.Uint16(d[p+20:]))
+	end := p + 22 + nameLen
+	if end+4 > len(d) || crc32.Checksum(d[:end], crcTable) != binary.BigEndian.Uint32(d[end:]) {
+		return ErrBadMappedFile
+	}
+	c, ok := codecByName(string(d[p+22 : end]))
+	if !ok {
+		return ErrUnregisteredCodec
+	}
+	start := uint64(end + 4)
+	if (root == 0) != (length == 0) || root != 0 && (root < start || root > uint64(len(d))-8) {
+		return ErrBadMappedFile
+	}
+	m.codec, m.length, m.root, m.start, m.body = c, int(length), root, start, body
+	m.ordered, _ = c.(OrderedCodec)
+	return nil
+}
+
+//Verify校验所有节点的CRC，并检查每个节点的结构和item的总数，不会解码任何item
+//它读取整个文件，可以在打开之后按需调用，文件损坏时返回ErrBadMappedFile。
+func (m *MappedTree) Verify() error {
+	if crc32.Checksum(m.data[m.start:], crcTable) != m.body {
+		return ErrBadMappedFile
+	}
+	if m.root == 0 {
+		return nil
+	}
+	if count, ok := m.checkNodes(m.start, m.root); !ok || count != uint64(m.length) {
+		return ErrBadMappedFile
+	}
+	return nil
+}
+
+//Err返回查找或者遍历时遇到的第一个错误
+func (m *MappedTree) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+//访问节点失败时的panic值，只在MappedTree内部使用，由导出的方法recover
+type mappedFault struct{}
+
+//记录第一个错误，然后回到导出的方法中
+func (m *MappedTree) fail(err error) {
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.mu.Unlock()
+	panic(mappedFault{})
+}
+
+//recover访问节点失败时的panic，其它panic继续向上传递
+func (m *MappedTree) catch() {
+	if r := recover(); r != nil {
+		if _, ok := r.(mappedFault); !ok {
+			panic(r)
+		}
+	}
+}
+
+//检查从root开始的所有节点都在[start, len(data))范围内并且结构正确，返回item的总数
+//节点按后序写入，子节点的偏移一定小于父节点，每个节点只能被引用一次，所以检查一定会结束。
+func (m *MappedTree) checkNodes(start, root uint64) (uint64, bool) {
+	size := uint64(len(m.data))
+	seen := make(map[uint64]bool)
+	stack := []uint64{root}
+	var count uint64
+	for len(stack) > 0 {
+		off := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if off < start || off > size-8 || seen[off] {
+			return 0, false
+		}
+		seen[off] = true
+		d := m.data[off:]
+		nitems := uint64(binary.BigEndian.Uint32(d))
+		nchildren := uint64(binary.BigEndian.Uint32(d[4:]))
+		if nchildren != 0 && nchildren != nitems+1 {
+			return 0, false
+		}
+		head := 8 + 8*nchildren + 4*nitems
+		if head > uint64(len(d)) {
+			return 0, false
+		}
+		prev := uint64(0)
+		for i := uint64(0); i < nitems; i++ {
+			end := uint64(binary.BigEndian.Uint32(d[8+8*nchildren+4*i:]))
+			if end < prev || head+end > uint64(len(d)) {
+				return 0, false
+			}
+			prev = end
+		}
+		for i := uint64(0); i < nchildren; i++ {
+			child := binary.BigEndian.Uint64(d[8+8*i:])
+			if child >= off {
+				return 0, false
+			}
+			stack = append(stack, child)
+		}
+		count += nitems
+	}
+	return count, true
+}
+
+//Close解除文件的映射，之后不能再使用m
+func (m *MappedTree) Close() error {
+	data := m.data
+	m.data = nil
+	return m.unmap(data)
+}
+
+//查找时使用的key，Codec是OrderedCodec时同时保存它的编码，只编码一次
+type mkey struct {
+	item Item
+	enc  []byte
+}
+
+func (m *MappedTree) key(item Item) *mkey {
+	if item == nil {
+		return nil
+	}
+	k := &mkey{item: item}
+	if m.ordered != nil {
+		if enc, err := m.ordered.EncodeItem(item); err == nil {
+			k.enc = enc
+		}
+	}
+	return k
+}
+
+//映射文件中的一个节点，node已经检查过节点头部和偏移数组都在文件范围内
+type mnode struct {
+	m         *MappedTree
+	off       uint64
+	data      []byte //从节点开始到文件末尾
+	nitems    int
+	nchildren int
+}
+
+//返回偏移为off的节点，偏移越界或者节点头部不对时记录ErrBadMappedFile
+func (m *MappedTree) node(off uint64) mnode {
+	if off < m.start || off > uint64(len(m.data))-8 {
+		m.fail(ErrBadMappedFile)
+	}
+	d := m.data[off:]
+	nitems := uint64(binary.BigEndian.Uint32(d))
+	nchildren := uint64(binary.BigEndian.Uint32(d[4:]))
+	if nchildren != 0 && nchildren != nitems+1 || 8+8*nchildren+4*nitems > uint64(len(d)) {
+		m.fail(ErrBadMappedFile)
+	}
+	return mnode{m: m, off: off, data: d, nitems: int(nitems), nchildren: int(nchildren)}
+}
+
+//第i个子节点，子节点总是在父节点之前写入，所以沿着子节点向下一定会结束
+func (n mnode) child(i int) mnode {
+	off := binary.BigEndian.Uint64(n.data[8+8*i:])
+	if off >= n.off {
+		n.m.fail(ErrBadMappedFile)
+	}
+	return n.m.node(off)
+}
+
+//第i个item的编码
+func (n mnode) raw(i int) []byte {
+	ends := n.data[8+8*n.nchildren:]
+	base := ends[4*n.nitems:]
+	start := uint32(0)
+	if i > 0 {
+		start = binary.BigEndian.Uint32(ends[4*(i-1):])
+	}
+	end := binary.BigEndian.Uint32(ends[4*i:])
+	if start > end || uint64(end) > uint64(len(base)) {
+		n.m.fail(ErrBadMappedFile)
+	}
+	return base[start:end]
+}
+
+//解码第i个item
+func (n mnode) item(i int) Item {
+	item, err := n.m.codec.DecodeItem(n.raw(i))
+	if err != nil {
+		n.m.fail(err)
+	}
+	return item
+}
+
+//比较key和第i个item，返回-1、0或1
+func (n mnode) compare(k *mkey, i int) int {
+	if k.enc != nil {
+		return n.m.ordered.CompareEncoded(k.enc, n.raw(i))
+	}
+	item := n.item(i)
+	switch {
+	case k.item.Less(item):
+		return -1
+	case item.Less(k.item):
+		return 1
+	}
+	return 0
+}
+
+//和items.find一样
+func (n mnode) find(k *mkey) (int, bool) {
+	i := sort.Search(n.nitems, func(i int) bool {
+		return n.compare(k, i) < 0
+	})
+	if i > 0 && n.compare(k, i-1) == 0 {
+		return i - 1, true
+	}
+	return i, false
+}
+
+//和node.iterate一样，只有交给iter的item才会解码
+func (n mnode) iterate(dir direction, start, stop *mkey, includeStart bool, hit bool, iter ItemIterator) (bool, bool) {
+	var ok, found bool
+	var index int
+	switch dir {
+	case ascend:
+		if start != nil {
+			index, _ = n.find(start)
+		}
+		for i := index; i < n.nitems; i++ {
+			if n.nchildren > 0 {
+				if hit, ok = n.child(i).iterate(dir, start, stop, includeStart, hit, iter); !ok {
+					return hit, false
+				}
+			}
+			if !includeStart && !hit && start != nil && n.compare(start, i) >= 0 {
+				hit = true
+				continue
+			}
+			hit = true
+			if stop != nil && n.compare(stop, i) <= 0 {
+				return hit, false
+			}
+			if !iter(n.item(i)) {
+				return hit, false
+			}
+		}
+		if n.nchildren > 0 {
+			if hit, ok = n.child(n.nchildren-1).iterate(dir, start, stop, includeStart, hit, iter); !ok {
+				return hit, false
+			}
+		}
+	case descend:
+		if start != nil {
+			index, found = n.find(start)
+			if !found {
+				index = index - 1
+			}
+		} else {
+			index = n.nitems - 1
+		}
+		for i := index; i >= 0; i-- {
+			if start != nil && n.compare(start, i) <= 0 {
+				if !includeStart || hit || n.compare(start, i) < 0 {
+					continue
+				}
+			}
+			if n.nchildren > 0 {
+				if hit, ok = n.child(i+1).iterate(dir, start, stop, includeStart, hit, iter); !ok {
+					return hit, false
+				}
+			}
+			if stop != nil && n.compare(stop, i) >= 0 {
+				return hit, false
+			}
+			hit = true
+			if !iter(n.item(i)) {
+				return hit, false
+			}
+		}
+		if n.nchildren > 0 {
+			if hit, ok = n.child(0).iterate(dir, start, stop, includeStart, hit, iter); !ok {
+				return hit, false
+			}
+		}
+	}
+	return hit, true
+}
+
+func (m *MappedTree) iterate(dir direction, start, stop Item, includeStart bool, iter ItemIterator) {
+	if m.root == 0 {
+		return
+	}
+	defer m.catch()
+	m.node(m.root).iterate(dir, m.key(start), m.key(stop), includeStart, false, iter)
+}
+
+//Get在tree中查找和key相等的item
+func (m *MappedTree) Get(key Item) Item {
+	if m.root == 0 {
+		return nil
+	}
+	defer m.catch()
+	k := m.key(key)
+	for n := m.node(m.root); ; {
+		i, found := n.find(k)
+		if found {
+			return n.item(i)
+		}
+		if n.nchildren == 0 {
+			return nil
+		}
+		n = n.child(i)
+	}
+}
+
+//Has返回tree中是否有和key相等的item
+func (m *MappedTree) Has(key Item) bool {
+	return m.Get(key) != nil
+}
+
+//Len返回tree中item的个数
+func (m *MappedTree) Len() int {
+	return m.length
+}
+
+//Min返回tree中最小的item
+func (m *MappedTree) Min() Item {
+	var out Item
+	m.Ascend(func(i Item) bool {
+		out = i
+		return false
+	})
+	return out
+}
+
+//Max返回tree中最大的item
+func (m *MappedTree) Max() Item {
+	var out Item
+	m.Descend(func(i Item) bool {
+		out = i
+		return false
+	})
+	return out
+}
+
+//AscendRange和BTree.AscendRange一样
+func (m *MappedTree) AscendRange(greaterOrEqual, lessThan Item, iterator ItemIterator) {
+	m.iterate(ascend, greaterOrEqual, lessThan, true, iterator)
+}
+
+//AscendLessThan和BTree.AscendLessThan一样
+func (m *MappedTree) AscendLessThan(pivot Item, iterator ItemIterator) {
+	m.iterate(ascend, nil, pivot, false, iterator)
+}
+
+//AscendGreaterOrEqual和BTree.AscendGreaterOrEqual一样
+func (m *MappedTree) AscendGreaterOrEqual(pivot Item, iterator ItemIterator) {
+	m.iterate(ascend, pivot, nil, true, iterator)
+}
+
+//Ascend和BTree.Ascend一样
+func (m *MappedTree) Ascend(iterator ItemIterator) {
+	m.iterate(ascend, nil, nil, false, iterator)
+}
+
+//DescendRange和BTree.DescendRange一样
+func (m *MappedTree) DescendRange(lessOrEqual, greaterThan Item, iterator ItemIterator) {
+	m.iterate(descend, lessOrEqual, greaterThan, true, iterator)
+}
+
+//DescendLessOrEqual和BTree.DescendLessOrEqual一样
+func (m *MappedTree) DescendLessOrEqual(pivot Item, iterator ItemIterator) {
+	m.iterate(descend, pivot, nil, true, iterator)
+}
+
+//DescendGreaterThan和BTree.DescendGreaterThan一样
+func (m *MappedTree) DescendGreaterThan(pivot Item, iterator ItemIterator) {
+	m.iterate(descend, nil, pivot, false, iterator)
+}
+
+//Descend和BTree.Descend一样
+func (m *MappedTree) Descend(iterator ItemIterator) {
+	m.iterate(descend, nil, nil, false, iterator)
+}
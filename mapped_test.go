@@ -0,0 +1,233 @@
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type unregisteredCodec struct{ IntCodec }
+
+//countingCodec统计DecodeItem的调用次数
+type countingCodec struct{ StringCodec }
+
+var (
+	countingDecodes  int
+	registerCounting sync.Once
+)
+
+func (c countingCodec) DecodeItem(data []byte) (Item, error) {
+	countingDecodes++
+	return c.StringCodec.DecodeItem(data)
+}
+
+func TestFreezeAndOpenMapped(t *testing.T) {
+	dir := t.TempDir()
+	for _, size := range []int{0, 1, 10, 1000} {
+		for _, degree := range []int{2, 7, *btreeDegree} {
+			tr := New(degree)
+			for _, v := range perm(size) {
+				tr.ReplaceOrInsert(v)
+			}
+			//删除一部分，让节点不是满的
+			for i := 0; i < size; i += 3 {
+				tr.Delete(Int(i))
+			}
+			path := filepath.Join(dir, "frozen")
+			if err := Freeze(tr.Clone(), path, IntCodec{}); err != nil {
+				t.Fatal(err)
+			}
+			m, err := OpenMapped(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Verify(); err != nil {
+				t.Fatal(err)
+			}
+			checkPagedScans(t, m, tr)
+			for v := -1; v <= size; v++ {
+				if got, want := m.Get(Int(v)), tr.Get(Int(v)); got != want {
+					t.Fatalf("Get(%d) = %v, want %v", v, got, want)
+				}
+			}
+			if err := m.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestOpenMappedErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "frozen")
+	tr := New(4)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	if err := Freeze(tr, path, unregisteredCodec{}); err != ErrUnregisteredCodec {
+		t.Fatalf("Freeze with unregistered codec: %v", err)
+	}
+	if err := Freeze(tr, path, IntCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte{0xff}, 10)
+	f.Close()
+	if _, err := OpenMapped(path); err != ErrBadMappedFile {
+		t.Fatalf("corrupted header: %v", err)
+	}
+	ioutil.WriteFile(path, []byte("short"), 0644)
+	if _, err := OpenMapped(path); err != ErrBadMappedFile {
+		t.Fatalf("short file: %v", err)
+	}
+}
+
+func TestOpenMappedCorruptBody(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "frozen")
+	tr := New(3)
+	for _, v := range perm(500) {
+		tr.ReplaceOrInsert(v)
+	}
+	if err := Freeze(tr, path, IntCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		data := append([]byte(nil), orig...)
+		pos := rand.Intn(len(data))
+		data[pos] ^= byte(1 + rand.Intn(255))
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := OpenMapped(path)
+		if err != nil {
+			//头部损坏在打开时就能发现
+			if err != ErrBadMappedFile {
+				t.Fatalf("flipped byte %d: %v", pos, err)
+			}
+			continue
+		}
+		if err := m.Verify(); err != ErrBadMappedFile {
+			t.Fatalf("flipped byte %d was not detected by Verify: %v", pos, err)
+		}
+		//没有Verify时，查找和遍历损坏的文件也不能panic
+		for j := -1; j <= 500; j++ {
+			m.Get(Int(j))
+		}
+		m.Ascend(func(Item) bool { return true })
+		m.DescendRange(Int(400), Int(100), func(Item) bool { return true })
+		m.AscendRange(Int(100), Int(400), func(Item) bool { return true })
+		m.Close()
+	}
+}
+
+func TestMappedOrderedCodec(t *testing.T) {
+	registerCounting.Do(func() { RegisterCodec("test-counting", countingCodec{}) })
+	tr := New(4)
+	for i := 0; i < 1000; i++ {
+		tr.ReplaceOrInsert(String(fmt.Sprintf("k%04d", i)))
+	}
+	path := filepath.Join(t.TempDir(), "frozen")
+	if err := Freeze(tr, path, countingCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	countingDecodes = 0
+	if got := m.Get(String("k0500")); got != String("k0500") {
+		t.Fatalf("Get = %v", got)
+	}
+	if m.Get(String("k0500x")) != nil {
+		t.Fatalf("Get of a missing key found something")
+	}
+	if countingDecodes != 1 {
+		t.Fatalf("Get decoded %d items, want 1", countingDecodes)
+	}
+	countingDecodes = 0
+	n := 0
+	m.AscendRange(String("k0100"), String("k0110"), func(Item) bool {
+		n++
+		return true
+	})
+	m.DescendRange(String("k0110"), String("k0100"), func(Item) bool {
+		n++
+		return true
+	})
+	if n != 20 || countingDecodes != n {
+		t.Fatalf("range scans returned %d items and decoded %d", n, countingDecodes)
+	}
+}
+
+func TestRegisterCodecDuplicateType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("registering IntCodec twice did not panic")
+		}
+	}()
+	RegisterCodec("int-again", IntCodec{})
+}
+
+func BenchmarkMappedGet(b *testing.B) {
+	tr := New(*btreeDegree)
+	for _, v := range perm(benchmarkTreeSize) {
+		tr.ReplaceOrInsert(v)
+	}
+	path := filepath.Join(b.TempDir(), "frozen")
+	if err := Freeze(tr, path, IntCodec{}); err != nil {
+		b.Fatal(err)
+	}
+	m, err := OpenMapped(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Get(Int(i % benchmarkTreeSize))
+	}
+}
+
+func TestMappedBadChildOffset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frozen")
+	tr := New(3)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	if err := Freeze(tr, path, IntCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	root := binary.BigEndian.Uint64(data[len(frozenMagic)+8:])
+	//root的第一个子节点指向文件之外，只有访问到它时才会发现
+	binary.BigEndian.PutUint64(data[root+8:], uint64(len(data)))
+	ioutil.WriteFile(path, data, 0644)
+	m, err := OpenMapped(path)
+	if err != nil {
+		t.Fatalf("OpenMapped read the nodes: %v", err)
+	}
+	defer m.Close()
+	if m.Max() != Int(99) || m.Err() != nil {
+		t.Fatalf("Max() = %v, err %v", m.Max(), m.Err())
+	}
+	if got := m.Get(Int(0)); got != nil || m.Err() != ErrBadMappedFile {
+		t.Fatalf("Get(0) = %v, err %v", got, m.Err())
+	}
+	if err := m.Verify(); err != ErrBadMappedFile {
+		t.Fatalf("Verify() = %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package btree
+
+import (
+	"reflect"
+)
+
+//Conflict是两边都修改了并且没有被resolve解决的key，不存在的一边为nil
+type Conflict struct {
+	Base, Ours, Theirs Item
+}
+
+//Merge3合并从base克隆出来并且分别修改过的ours和theirs，返回合并之后的新tree和没有解决的冲突，三棵tree都不会被修改
+//只有一边修改过的key采用修改过的一边，两边修改成一样的key直接采用，两边修改得不一样的key调用resolve，
+//参数中不存在的一边为nil。resolve返回(item, true)时采用item（item为nil表示删除这个key），
+//返回false或者resolve为nil时保留ours的版本并记录一个Conflict。
+//比较时同时遍历base和另一棵tree，Clone之后没有被修改的子树在两边是同一个节点，会被整个跳过，
+//所以耗时和修改的数量成正比，而不是和tree的大小成正比。
+//两个item相等（互相都不Less）并且值相同时才认为没有修改：可比较的类型用==，其他的以及==会panic的用reflect.DeepEqual。
+func Merge3(base, ours, theirs *BTree, resolve func(base, ours, theirs Item) (Item, bool)) (*BTree, []Conflict) {
+	out := ours.Clone()
+	var conflicts []Conflict
+	dOurs, dTheirs := diffTrees(base, ours), diffTrees(base, theirs)
+	for i, j := 0, 0; j < len(dTheirs); {
+		var o *itemDiff
+		if i < len(dOurs) {
+			o = &dOurs[i]
+		}
+		t := &dTheirs[j]
+		switch {
+		case o != nil && o.key().Less(t.key()):
+			//只有ours修改了，out中已经是ours的版本
+			i++
+			continue
+		case o == nil || t.key().Less(o.key()):
+			//只有theirs修改了
+			out.setItem(t.key(), t.new)
+			j++
+			continue
+		}
+		i++
+		j++
+		if sameItem(o.new, t.new) {
+			continue
+		}
+		if resolve != nil {
+			if item, ok := resolve(o.old, o.new, t.new); ok {
+				out.setItem(o.key(), item)
+				continue
+			}
+		}
+		conflicts = append(conflicts, Conflict{Base: o.old, Ours: o.new, Theirs: t.new})
+	}
+	return out, conflicts
+}
+
+//把和key相等的item设置成item，item为nil时删除
+func (t *BTree) setItem(key, item Item) {
+	if item == nil {
+		t.Delete(key)
+	} else {
+		t.ReplaceOrInsert(item)
+	}
+}
+
+//两个都可能为nil的item是否相同
+func sameItem(a, b Item) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.Less(b) || b.Less(a) {
+		return false
+	}
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+	if ta.Comparable() {
+		return equalItems(a, b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+//用==比较类型可比较的两个item
+//类型可比较时，接口字段中保存的slice或map仍然会让==在运行时panic，这时改用reflect.DeepEqual
+func equalItems(a, b Item) (eq bool) {
+	defer func() {
+		if recover() != nil {
+			eq = reflect.DeepEqual(a, b)
+		}
+	}()
+	return a == b
+}
+
+//一个key在两棵tree中的差异，old或new为nil表示这个key在那一边不存在
+type itemDiff struct {
+	old, new Item
+}
+
+func (d *itemDiff) key() Item {
+	if d.old != nil {
+		return d.old
+	}
+	return d.new
+}
+
+//按key的升序返回b相对于a的所有差异
+func diffTrees(a, b *BTree) []itemDiff {
+	var out []itemDiff
+	ca, cb := newDiffCursor(a.root), newDiffCursor(b.root)
+	for {
+		na, ia := ca.peek()
+		nb, ib := cb.peek()
+		switch {
+		case na == nil && ia == nil && nb == nil && ib == nil:
+			return out
+		case na != nil && na == nb:
+			//同一个节点，整个子树都相同
+			ca.skip()
+			cb.skip()
+		case na != nil && nb != nil:
+			//先展开大的子树，小的那个可能就是它里面共享的节点
+			if na.size >= nb.size {
+				ca.descend()
+			}
+			if nb.size >= na.size {
+				cb.descend()
+			}
+		case na != nil:
+			ca.descend()
+		case nb != nil:
+			cb.descend()
+		case ib == nil || (ia != nil && ia.Less(ib)):
+			out = append(out, itemDiff{old: ia})
+			ca.skip()
+		case ia == nil || ib.Less(ia):
+			out = append(out, itemDiff{new: ib})
+			cb.skip()
+		default:
+			if !sameItem(ia, ib) {
+				out = append(out, itemDiff{old: ia, new: ib})
+			}
+			ca.skip()
+			cb.skip()
+		}
+	}
+}
+
+//按顺序遍历tree的游标，当前位置可能是一整个子树或者一个item
+type diffCursor struct {
+	stack []diffFrame
+}
+
+//节点中的位置，pos为偶数时是第pos/2个子节点，为奇数时是第pos/2个item
+type diffFrame struct {
+	n   *node
+	pos int
+}
+
+func newDiffCursor(root *node) *diffCursor {
+	c := &diffCursor{}
+	if root != nil && root.size > 0 {
+		c.stack = append(c.stack, diffFrame{n: root})
+		c.normalize()
+	}
+	return c
+}
+
+//弹出已经走完的节点，并跳过叶子节点中不存在的子节点位置
+func (c *diffCursor) normalize() {
+	for len(c.stack) > 0 {
+		f := &c.stack[len(c.stack)-1]
+		if f.pos > 2*len(f.n.items) {
+			c.stack = c.stack[:len(c.stack)-1]
+			if len(c.stack) > 0 {
+				c.stack[len(c.stack)-1].pos++
+			}
+			continue
+		}
+		if f.pos%2 == 0 && len(f.n.children) == 0 {
+			f.pos++
+			continue
+		}
+		return
+	}
+}
+
+//返回当前位置的子树或者item，走完之后都为nil
+func (c *diffCursor) peek() (*node, Item) {
+	if len(c.stack) == 0 {
+		return nil, nil
+	}
+	f := c.stack[len(c.stack)-1]
+	if f.pos%2 == 0 {
+		return f.n.children[f.pos/2], nil
+	}
+	return nil, f.n.items[f.pos/2]
+}
+
+//跳过当前位置的子树或者item
+func (c *diffCursor) skip() {
+	c.stack[len(c.stack)-1].pos++
+	c.normalize()
+}
+
+//进入当前位置的子树
+func (c *diffCursor) descend() {
+	f := c.stack[len(c.stack)-1]
+	c.stack = append(c.stack, diffFrame{n: f.n.children[f.pos/2]})
+	c.normalize()
+}
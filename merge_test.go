@@ -0,0 +1,139 @@
+package btree
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//kv只按k排序，所以替换时v可以改变
+type kv struct {
+	k int
+	v string
+}
+
+func (a kv) Less(b Item) bool {
+	return a.k < b.(kv).k
+}
+
+func TestMerge3(t *testing.T) {
+	base := New(3)
+	for i := 0; i < 1000; i++ {
+		base.ReplaceOrInsert(kv{i, "base"})
+	}
+	ours, theirs := base.Clone(), base.Clone()
+	ours.ReplaceOrInsert(kv{10, "ours"})     //只有ours修改
+	theirs.ReplaceOrInsert(kv{20, "theirs"}) //只有theirs修改
+	theirs.Delete(kv{k: 30})                 //只有theirs删除
+	ours.ReplaceOrInsert(kv{2000, "ours"})   //ours中新插入
+	ours.ReplaceOrInsert(kv{40, "same"})     //两边修改成一样
+	theirs.ReplaceOrInsert(kv{40, "same"})
+	ours.Delete(kv{k: 50}) //两边都删除
+	theirs.Delete(kv{k: 50})
+	ours.ReplaceOrInsert(kv{60, "ours"}) //修改冲突
+	theirs.ReplaceOrInsert(kv{60, "theirs"})
+	ours.ReplaceOrInsert(kv{70, "ours"}) //一边修改一边删除
+	theirs.Delete(kv{k: 70})
+	ours.ReplaceOrInsert(kv{3000, "ours"}) //插入冲突
+	theirs.ReplaceOrInsert(kv{3000, "theirs"})
+
+	var calls []int
+	resolve := func(b, o, th Item) (Item, bool) {
+		calls = append(calls, o.(kv).k)
+		if th == nil {
+			return nil, true
+		}
+		if o.(kv).k == 3000 {
+			return nil, false
+		}
+		return kv{o.(kv).k, "merged"}, true
+	}
+	merged, conflicts := Merge3(base, ours, theirs, resolve)
+	if want := []int{60, 70, 3000}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("resolve called for %v, want %v", calls, want)
+	}
+	if want := []Conflict{{nil, kv{3000, "ours"}, kv{3000, "theirs"}}}; !reflect.DeepEqual(conflicts, want) {
+		t.Fatalf("conflicts %v, want %v", conflicts, want)
+	}
+	for k, want := range map[int]Item{10: kv{10, "ours"}, 20: kv{20, "theirs"}, 30: nil, 40: kv{40, "same"},
+		50: nil, 60: kv{60, "merged"}, 70: nil, 2000: kv{2000, "ours"}, 3000: kv{3000, "ours"}, 999: kv{999, "base"}} {
+		if got := merged.Get(kv{k: k}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("key %d: got %v, want %v", k, got, want)
+		}
+	}
+	if merged.Len() != 1000-3+2 {
+		t.Fatalf("merged has %d items", merged.Len())
+	}
+	if ours.Get(kv{k: 20}).(kv).v != "base" || theirs.Get(kv{k: 10}).(kv).v != "base" {
+		t.Fatalf("inputs were modified")
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	for _, degree := range []int{2, 3, 8} {
+		a := New(degree)
+		for _, v := range perm(500) {
+			a.ReplaceOrInsert(v)
+		}
+		b := a.Clone()
+		want := map[Item]bool{}
+		for i := 0; i < 50; i++ {
+			v := Int(rand.Intn(700))
+			if b.Has(v) {
+				b.Delete(v)
+			} else {
+				b.ReplaceOrInsert(v)
+			}
+			want[v] = !want[v]
+		}
+		got := map[Item]bool{}
+		for _, d := range diffTrees(a, b) {
+			got[d.key()] = true
+		}
+		for k, changed := range want {
+			if changed != got[k] {
+				t.Fatalf("degree %d: key %v changed=%v, diff reported %v", degree, k, changed, got[k])
+			}
+			delete(got, k)
+		}
+		if len(got) != 0 {
+			t.Fatalf("degree %d: spurious diffs %v", degree, got)
+		}
+		if d := diffTrees(a, a.Clone()); len(d) != 0 {
+			t.Fatalf("clone differs: %v", d)
+		}
+		//没有共享节点的tree逐个item比较
+		if d := diffTrees(a, a.Rebuild(degree+1)); len(d) != 0 {
+			t.Fatalf("rebuilt tree differs: %v", d)
+		}
+	}
+}
+
+//类型可比较，但是接口字段中保存的是slice
+type anyKV struct {
+	k int
+	v interface{}
+}
+
+func (a anyKV) Less(b Item) bool {
+	return a.k < b.(anyKV).k
+}
+
+func TestMerge3UncomparableValues(t *testing.T) {
+	base := New(3)
+	for i := 0; i < 10; i++ {
+		base.ReplaceOrInsert(anyKV{i, []int{i}})
+	}
+	ours, theirs := base.Clone(), base.Clone()
+	ours.ReplaceOrInsert(anyKV{1, []int{1, 1}}) //两边修改成一样
+	theirs.ReplaceOrInsert(anyKV{1, []int{1, 1}})
+	ours.ReplaceOrInsert(anyKV{2, []int{2, 0}}) //修改冲突
+	theirs.ReplaceOrInsert(anyKV{2, []int{2, 1}})
+	merged, conflicts := Merge3(base, ours, theirs, nil)
+	if len(conflicts) != 1 || conflicts[0].Ours.(anyKV).k != 2 {
+		t.Fatalf("conflicts %v", conflicts)
+	}
+	if got := merged.Get(anyKV{k: 1}); !reflect.DeepEqual(got, anyKV{1, []int{1, 1}}) {
+		t.Fatalf("key 1: got %v", got)
+	}
+}
@@ -0,0 +1,541 @@
+package btree
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+	"unsafe"
+)
+
+//Hash是SHA-256的结果
+type Hash [sha256.Size]byte
+
+//ItemHasher计算item的hash，相等并且内容相同的item必须得到相同的hash
+type ItemHasher func(item Item) Hash
+
+//hash中的域分隔，避免item的hash和节点的hash、范围的hash混淆
+const (
+	hashItem  byte = 0
+	hashNode  byte = 1
+	hashRange byte = 2
+)
+
+//ErrNoHasher表示tree没有设置ItemHasher
+var ErrNoHasher = errors.New("btree: no item hasher")
+
+//HasherFromCodec返回对c的编码计算SHA-256的ItemHasher，编码失败时panic
+func HasherFromCodec(c Codec) ItemHasher {
+	return func(item Item) Hash {
+		data, err := c.EncodeItem(item)
+		if err != nil {
+			panic(err)
+		}
+		h := sha256.New()
+		h.Write([]byte{hashItem})
+		h.Write(data)
+		var out Hash
+		h.Sum(out[:0])
+		return out
+	}
+}
+
+//用指针区分不同的ItemHasher，节点中缓存的hash只有在由同一个hasher计算时才有效
+type itemHasher struct {
+	fn ItemHasher
+}
+
+//节点中缓存的hash，节点被修改之前一直有效，通过copy on write在clone之间共享
+type nodeDigest struct {
+	by    *itemHasher
+	hash  Hash   //节点的Merkle hash，依赖于tree的形状
+	sum   setSum //子树中所有item hash的和，只依赖于子树中的item
+	items []Hash //节点中每个item的hash
+}
+
+//item hash的和，按4个64位分量分别相加，和item的顺序以及tree的形状无关
+type setSum [4]uint64
+
+func (s *setSum) add(h Hash) {
+	for i := range s {
+		s[i] += binary.BigEndian.Uint64(h[i*8:])
+	}
+}
+
+func (s *setSum) addSum(o setSum) {
+	for i := range s {
+		s[i] += o[i]
+	}
+}
+
+func (s *setSum) subSum(o setSum) {
+	for i := range s {
+		s[i] -= o[i]
+	}
+}
+
+//SetItemHasher设置计算Merkle hash时使用的item hash函数，RootHash、RangeHash、SyncSession和证明都需要它
+//节点的hash在第一次需要时计算并缓存在节点中，节点被修改时失效，Clone之后没有被修改的节点共享缓存的hash。
+//Clone出来的tree沿用t的hasher，重新设置hasher之后所有的hash都会在需要时重新计算。
+func (t *BTree) SetItemHasher(h ItemHasher) {
+	if h == nil {
+		t.cow.hasher = nil
+		return
+	}
+	t.cow.hasher = &itemHasher{fn: h}
+}
+
+//返回节点由h计算的hash，没有缓存时先计算所有子节点的hash
+//多个goroutine可以同时读取共享的节点，所以缓存使用原子操作读写，同时计算的结果是一样的
+func (n *node) digestFor(h *itemHasher) *nodeDigest {
+	if d := (*nodeDigest)(atomic.LoadPointer(&n.digest)); d != nil && d.by == h {
+		return d
+	}
+	d := &nodeDigest{by: h, items: make([]Hash, len(n.items))}
+	var childHashes []Hash
+	if len(n.children) > 0 {
+		childHashes = make([]Hash, len(n.children))
+		for i, child := range n.children {
+			cd := child.digestFor(h)
+			childHashes[i] = cd.hash
+			d.sum.addSum(cd.sum)
+		}
+	}
+	for i, item := range n.items {
+		d.items[i] = h.fn(item)
+		d.sum.add(d.items[i])
+	}
+	d.hash = nodeHash(d.items, childHashes)
+	atomic.StorePointer(&n.digest, unsafe.Pointer(d))
+	return d
+}
+
+//节点的Merkle hash：sha256(1, 子节点数, item数, child[0], item[0], child[1], ..., item[k-1], child[k])
+//叶子节点没有子节点，只包含item的hash
+func nodeHash(items, children []Hash) Hash {
+	w := sha256.New()
+	var hdr [1 + 2*binary.MaxVarintLen64]byte
+	hdr[0] = hashNode
+	size := 1 + binary.PutUvarint(hdr[1:], uint64(len(children)))
+	size += binary.PutUvarint(hdr[size:], uint64(len(items)))
+	w.Write(hdr[:size])
+	for i := range items {
+		if len(children) > 0 {
+			w.Write(children[i][:])
+		}
+		w.Write(items[i][:])
+	}
+	if len(children) > 0 {
+		w.Write(children[len(children)-1][:])
+	}
+	var out Hash
+	w.Sum(out[:0])
+	return out
+}
+
+//范围的hash：sha256(2, item数, item hash的和)
+func rangeHash(count int, sum setSum) Hash {
+	var buf [1 + binary.MaxVarintLen64 + 32]byte
+	buf[0] = hashRange
+	size := 1 + binary.PutUvarint(buf[1:], uint64(count))
+	for i, v := range sum {
+		binary.BigEndian.PutUint64(buf[size+i*8:], v)
+	}
+	return sha256.Sum256(buf[:size+32])
+}
+
+//返回t的hasher，没有设置时panic
+func (t *BTree) hasher() *itemHasher {
+	if t.cow.hasher == nil {
+		panic(ErrNoHasher)
+	}
+	return t.cow.hasher
+}
+
+//RootHash返回tree的Merkle hash，空tree返回零值
+//两棵tree的RootHash相等说明它们的item和形状都相同。相同的item以不同的顺序插入可能得到不同的形状，
+//只比较内容时使用RangeHash(nil, nil)。
+//没有设置ItemHasher时panic。
+func (t *BTree) RootHash() Hash {
+	h := t.hasher()
+	if t.root == nil || t.length == 0 {
+		return Hash{}
+	}
+	return t.root.digestFor(h).hash
+}
+
+//RangeHash返回[greaterOrEqual, lessThan)范围内的item的hash，nil表示不限制这一端
+//结果只依赖于范围内的item而和tree的形状无关，包含相同item的两棵tree对同一个范围得到相同的hash。
+//利用节点中缓存的子树hash，只需要O(log n)次节点访问。没有设置ItemHasher时panic。
+func (t *BTree) RangeHash(greaterOrEqual, lessThan Item) Hash {
+	count, sum := t.rangeDigest(greaterOrEqual, lessThan)
+	return rangeHash(count, sum)
+}
+
+//返回[ge, lt)范围内item的个数和hash的和
+func (t *BTree) rangeDigest(ge, lt Item) (int, setSum) {
+	h := t.hasher()
+	if t.root == nil || t.length == 0 || (ge != nil && lt != nil && !ge.Less(lt)) {
+		return 0, setSum{}
+	}
+	count, sum := t.root.size, t.root.digestFor(h).sum
+	if lt != nil {
+		count, sum = t.root.prefixDigest(lt, h)
+	}
+	if ge != nil {
+		c, s := t.root.prefixDigest(ge, h)
+		count -= c
+		sum.subSum(s)
+	}
+	return count, sum
+}
+
+//返回子树中所有小于key的item的个数和hash的和
+func (n *node) prefixDigest(key Item, h *itemHasher) (count int, sum setSum) {
+	for {
+		d := n.digestFor(h)
+		i, found := n.find(key)
+		for j := 0; j < i; j++ {
+			if len(n.children) > 0 {
+				count += n.children[j].size
+				sum.addSum(n.children[j].digestFor(h).sum)
+			}
+			count++
+			sum.add(d.items[j])
+		}
+		if len(n.children) == 0 {
+			return count, sum
+		}
+		if found {
+			//items[i]等于key，它左边的子树整个都小于key
+			count += n.children[i].size
+			sum.addSum(n.children[i].digestFor(h).sum)
+			return count, sum
+		}
+		n = n.children[i]
+	}
+}
+
+//同步消息中对一个范围的回答
+const (
+	syncMatch byte = 0 //两边的hash相同
+	syncItems byte = 1 //后面是源tree在这个范围内的所有item
+	syncSplit byte = 2 //后面是把范围拆分成子范围的边界
+)
+
+//ErrSyncProtocol表示收到了格式不对的同步消息
+var ErrSyncProtocol = errors.New("btree: bad sync message")
+
+//SyncSession通过任意的io.ReadWriter在两个tree之间做反熵（anti-entropy）同步
+//一端调用Serve作为源，另一端调用Pull，Pull结束之后它的tree和源tree包含相同的item：
+//Pull发送一批范围和自己在这些范围内的RangeHash，Serve对每个范围回答hash相同、
+//范围内的所有item（源tree在这个范围内的item不超过LeafSize个时），或者把范围按源tree的item拆分成Fanout个子范围，
+//Pull删除和替换不一致的范围内的item，并在下一轮继续比较拆分出来的子范围。
+//只有hash不同的范围会被继续拆分，所以传输的数据量和不同的item数成正比，而不是和tree的大小成正比。
+//两端必须使用相同的Codec和ItemHasher。同步期间不能在其他goroutine中修改tree。
+type SyncSession struct {
+	Fanout   int //hash不同的范围被拆分成多少个子范围，为0时使用16
+	LeafSize int //源tree在范围内的item不超过这么多个时直接发送item，为0时使用32，不能小于Fanout
+
+	Stats SyncStats //本次同步的统计
+
+	tree  *BTree
+	codec Codec
+}
+
+//SyncStats是同步的统计
+type SyncStats struct {
+	Rounds int //往返的次数
+	Ranges int //比较过的范围数
+	Items  int //传输的item数
+}
+
+//NewSyncSession创建一个同步t的SyncSession，c用来在消息中编码item
+//t没有设置ItemHasher时使用HasherFromCodec(c)
+func NewSyncSession(t *BTree, c Codec) *SyncSession {
+	if t.cow.hasher == nil {
+		t.SetItemHasher(HasherFromCodec(c))
+	}
+	return &SyncSession{tree: t, codec: c}
+}
+
+//一个等待比较的范围[lo, hi)，nil表示不限制这一端
+type syncRange struct {
+	lo, hi Item
+}
+
+//读写同步消息，第一个错误之后不再读写
+type syncConn struct {
+	r     *bufio.Reader
+	w     *bufio.Writer
+	codec Codec
+	err   error
+	tmp   [binary.MaxVarintLen64]byte
+}
+
+func newSyncConn(rw io.ReadWriter, c Codec) *syncConn {
+	return &syncConn{r: bufio.NewReader(rw), w: bufio.NewWriter(rw), codec: c}
+}
+
+func (c *syncConn) write(data []byte) {
+	if c.err != nil {
+		return
+	}
+	_, c.err = c.w.Write(data)
+}
+
+func (c *syncConn) writeUvarint(v uint64) {
+	c.write(c.tmp[:binary.PutUvarint(c.tmp[:], v)])
+}
+
+//写入一个item，item前面是它的编码长度
+func (c *syncConn) writeItem(item Item) {
+	if c.err != nil {
+		return
+	}
+	data, err := c.codec.EncodeItem(item)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.writeUvarint(uint64(len(data)))
+	c.write(data)
+}
+
+//写入范围的一端，nil写成0，否则写成1和item
+func (c *syncConn) writeBound(item Item) {
+	if item == nil {
+		c.write([]byte{0})
+		return
+	}
+	c.write([]byte{1})
+	c.writeItem(item)
+}
+
+func (c *syncConn) flush() error {
+	if c.err == nil {
+		c.err = c.w.Flush()
+	}
+	return c.err
+}
+
+func (c *syncConn) readByte() byte {
+	if c.err != nil {
+		return 0
+	}
+	b, err := c.r.ReadByte()
+	c.err = err
+	return b
+}
+
+func (c *syncConn) readUvarint() uint64 {
+	if c.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(c.r)
+	c.err = err
+	return v
+}
+
+func (c *syncConn) readFull(buf []byte) {
+	if c.err != nil {
+		return
+	}
+	_, c.err = io.ReadFull(c.r, buf)
+}
+
+func (c *syncConn) readItem() Item {
+	size := c.readUvarint()
+	if c.err != nil {
+		return nil
+	}
+	if size > 1<<30 {
+		c.err = ErrSyncProtocol
+		return nil
+	}
+	data := make([]byte, size)
+	c.readFull(data)
+	if c.err != nil {
+		return nil
+	}
+	item, err := c.codec.DecodeItem(data)
+	if err != nil {
+		c.err = err
+	}
+	return item
+}
+
+func (c *syncConn) readBound() Item {
+	switch c.readByte() {
+	case 0:
+		return nil
+	case 1:
+		return c.readItem()
+	}
+	if c.err == nil {
+		c.err = ErrSyncProtocol
+	}
+	return nil
+}
+
+//一轮中的范围数不超过这么多，防止错误的消息导致分配过多的内存
+const maxSyncBatch = 1 << 24
+
+//Serve作为源回答Pull的请求，直到Pull发送结束消息
+func (s *SyncSession) Serve(rw io.ReadWriter) error {
+	fanout, leafSize := s.Fanout, s.LeafSize
+	if fanout < 2 {
+		fanout = 16
+	}
+	if leafSize <= 0 {
+		leafSize = 32
+	}
+	if leafSize < fanout {
+		leafSize = fanout
+	}
+	c := newSyncConn(rw, s.codec)
+	for {
+		count := c.readUvarint()
+		if c.err != nil {
+			return c.err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count > maxSyncBatch {
+			return ErrSyncProtocol
+		}
+		s.Stats.Rounds++
+		ranges := make([]syncRange, count)
+		hashes := make([]Hash, count)
+		for i := range ranges {
+			ranges[i].lo = c.readBound()
+			ranges[i].hi = c.readBound()
+			c.readFull(hashes[i][:])
+		}
+		if c.err != nil {
+			return c.err
+		}
+		for i, r := range ranges {
+			s.Stats.Ranges++
+			n, sum := s.tree.rangeDigest(r.lo, r.hi)
+			switch {
+			case rangeHash(n, sum) == hashes[i]:
+				c.write([]byte{syncMatch})
+			case n <= leafSize:
+				c.write([]byte{syncItems})
+				c.writeUvarint(uint64(n))
+				s.tree.AscendRange(r.lo, r.hi, func(item Item) bool {
+					c.writeItem(item)
+					return c.err == nil
+				})
+				s.Stats.Items += n
+			default:
+				//按源tree中的排名把范围平均拆分，每个子范围都至少包含一个源tree的item
+				c.write([]byte{syncSplit})
+				c.writeUvarint(uint64(fanout - 1))
+				base := 0
+				if r.lo != nil {
+					base, _ = s.tree.root.prefixDigest(r.lo, s.tree.hasher())
+				}
+				for j := 1; j < fanout; j++ {
+					c.writeItem(s.tree.At(base + n*j/fanout))
+				}
+			}
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+//Pull从Serve的一端同步，结束之后s的tree和源tree包含相同的item
+func (s *SyncSession) Pull(rw io.ReadWriter) error {
+	c := newSyncConn(rw, s.codec)
+	pending := []syncRange{{}}
+	for len(pending) > 0 {
+		s.Stats.Rounds++
+		c.writeUvarint(uint64(len(pending)))
+		for _, r := range pending {
+			c.writeBound(r.lo)
+			c.writeBound(r.hi)
+			h := s.tree.RangeHash(r.lo, r.hi)
+			c.write(h[:])
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+		var next []syncRange
+		for _, r := range pending {
+			s.Stats.Ranges++
+			switch c.readByte() {
+			case syncMatch:
+			case syncItems:
+				count := c.readUvarint()
+				if count > maxSyncBatch {
+					return ErrSyncProtocol
+				}
+				remote := make([]Item, 0, count)
+				for i := uint64(0); i < count && c.err == nil; i++ {
+					remote = append(remote, c.readItem())
+				}
+				if c.err != nil {
+					return c.err
+				}
+				s.Stats.Items += len(remote)
+				s.replaceRange(r, remote)
+			case syncSplit:
+				count := c.readUvarint()
+				if count > maxSyncBatch {
+					return ErrSyncProtocol
+				}
+				lo := r.lo
+				for i := uint64(0); i < count && c.err == nil; i++ {
+					bound := c.readItem()
+					next = append(next, syncRange{lo: lo, hi: bound})
+					lo = bound
+				}
+				next = append(next, syncRange{lo: lo, hi: r.hi})
+			default:
+				if c.err == nil {
+					c.err = ErrSyncProtocol
+				}
+			}
+			if c.err != nil {
+				return c.err
+			}
+		}
+		pending = next
+	}
+	c.writeUvarint(0)
+	return c.flush()
+}
+
+//把tree在r中的item替换成remote，remote是升序的，hash相同的item保持不变
+func (s *SyncSession) replaceRange(r syncRange, remote []Item) {
+	h := s.tree.hasher()
+	var stale []Item
+	same := make([]bool, len(remote))
+	j := 0
+	s.tree.AscendRange(r.lo, r.hi, func(item Item) bool {
+		for j < len(remote) && remote[j].Less(item) {
+			j++
+		}
+		if j == len(remote) || item.Less(remote[j]) {
+			stale = append(stale, item)
+		} else {
+			same[j] = h.fn(item) == h.fn(remote[j])
+		}
+		return true
+	})
+	for _, item := range stale {
+		s.tree.Delete(item)
+	}
+	for i, item := range remote {
+		if !same[i] {
+			s.tree.ReplaceOrInsert(item)
+		}
+	}
+}
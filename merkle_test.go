@@ -0,0 +1,145 @@
+package btree
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func hashedTree(degree int, values []Item) *BTree {
+	tr := New(degree)
+	tr.SetItemHasher(HasherFromCodec(IntCodec{}))
+	for _, v := range values {
+		tr.ReplaceOrInsert(v)
+	}
+	return tr
+}
+
+func TestRootHash(t *testing.T) {
+	values := perm(100)
+	tr := hashedTree(3, values)
+	if tr.RootHash() == (Hash{}) {
+		t.Fatalf("zero root hash for non-empty tree")
+	}
+	before := tr.RootHash()
+	clone := tr.Clone()
+	tr.Delete(Int(50))
+	if tr.RootHash() == before {
+		t.Fatalf("root hash unchanged after delete")
+	}
+	if clone.RootHash() != before {
+		t.Fatalf("clone root hash changed after original was modified")
+	}
+	//在新的tree上执行相同的操作得到相同的形状，所以增量维护的hash必须和重新计算的一样
+	fresh := New(3)
+	for _, v := range values {
+		fresh.ReplaceOrInsert(v)
+	}
+	fresh.Delete(Int(50))
+	fresh.SetItemHasher(HasherFromCodec(IntCodec{}))
+	if got, want := tr.RootHash(), fresh.RootHash(); got != want {
+		t.Fatalf("root hash %x, want %x", got, want)
+	}
+	if got := hashedTree(3, nil).RootHash(); got != (Hash{}) {
+		t.Fatalf("empty tree root hash %x", got)
+	}
+}
+
+func TestRangeHash(t *testing.T) {
+	a := hashedTree(2, perm(200))
+	b := hashedTree(7, rang(200))
+	for _, r := range [][2]Item{{nil, nil}, {Int(0), Int(200)}, {Int(13), Int(77)}, {nil, Int(5)}, {Int(150), nil}, {Int(40), Int(41)}} {
+		if a.RangeHash(r[0], r[1]) != b.RangeHash(r[0], r[1]) {
+			t.Fatalf("range %v: hashes differ between tree shapes", r)
+		}
+		want := hashedTree(3, nil)
+		a.AscendRange(r[0], r[1], func(i Item) bool {
+			want.ReplaceOrInsert(i)
+			return true
+		})
+		if got := a.RangeHash(r[0], r[1]); got != want.RangeHash(nil, nil) {
+			t.Fatalf("range %v: hash does not match a tree of the same items", r)
+		}
+	}
+	if a.RangeHash(Int(10), Int(10)) != hashedTree(3, nil).RangeHash(nil, nil) {
+		t.Fatalf("empty range hash differs from empty tree")
+	}
+	b.Delete(Int(60))
+	if a.RangeHash(Int(50), Int(70)) == b.RangeHash(Int(50), Int(70)) {
+		t.Fatalf("range hash unchanged after delete")
+	}
+	if a.RangeHash(Int(70), nil) != b.RangeHash(Int(70), nil) {
+		t.Fatalf("range hash outside the change differs")
+	}
+}
+
+func TestSyncSession(t *testing.T) {
+	const size = 5000
+	src := hashedTree(8, perm(size))
+	dst := src.Clone()
+	for _, v := range []int{3, 1000, 1001, 4321} {
+		dst.Delete(Int(v))
+	}
+	for _, v := range []int{-5, size + 10} {
+		dst.ReplaceOrInsert(Int(v))
+	}
+	src.Delete(Int(2500))
+	src.ReplaceOrInsert(Int(size + 1))
+
+	a, b := net.Pipe()
+	server := NewSyncSession(src, IntCodec{})
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(a)
+		a.Close()
+	}()
+	client := NewSyncSession(dst, IntCodec{})
+	if err := client.Pull(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	if got, want := all(dst), all(src); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after sync got %d items, want %d", len(got), len(want))
+	}
+	if src.RangeHash(nil, nil) != dst.RangeHash(nil, nil) {
+		t.Fatalf("range hashes differ after sync")
+	}
+	if client.Stats.Items == 0 || client.Stats.Items > size/10 {
+		t.Fatalf("transferred %d items for a handful of differences", client.Stats.Items)
+	}
+}
+
+func TestRootHashCacheInvalidation(t *testing.T) {
+	hasher := HasherFromCodec(IntCodec{})
+	tr := hashedTree(3, perm(300))
+	check := func(what string) {
+		t.Helper()
+		cached := tr.RootHash()
+		//换一个新的hasher之后每个节点都要重新计算hash
+		tr.SetItemHasher(hasher)
+		if fresh := tr.RootHash(); cached != fresh {
+			t.Fatalf("%s: cached root hash %x, recomputed %x", what, cached, fresh)
+		}
+	}
+	check("insert")
+	clone := tr.Clone()
+	tr.Delete(Int(7))
+	check("delete")
+	tr.DeleteMin()
+	tr.DeleteMax()
+	check("delete min/max")
+	tr.Upsert(Int(1000), func(old Item, exists bool) (Item, bool) { return Int(1000), true })
+	check("upsert")
+	tr.ApplyBatch([]Op{{Kind: OpDelete, Item: Int(20)}, {Kind: OpInsert, Item: Int(-1)}, {Kind: OpDelete, Item: Int(150)}})
+	check("apply batch")
+	tr.DeleteFunc(Int(100), Int(200), func(i Item) bool { return i.(Int)%3 == 0 })
+	check("delete func")
+	tr = clone
+	check("clone")
+	tr.Clear(true)
+	check("clear")
+}
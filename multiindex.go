@@ -0,0 +1,277 @@
+package btree
+
+import "sync"
+
+//IndexDef定义MultiIndex中的一个索引
+type IndexDef struct {
+	Name string
+	//从记录中取出这个索引的key
+	Key func(record interface{}) interface{}
+	//比较两个key，为nil时key必须实现Item，使用Item的Less
+	Less func(a, b interface{}) bool
+}
+
+//返回a是否小于b
+func (d *IndexDef) less(a, b interface{}) bool {
+	if d.Less != nil {
+		return d.Less(a, b)
+	}
+	return a.(Item).Less(b.(Item))
+}
+
+//MultiIndex在多个BTree中同时保存同一批记录：一个主索引和任意个命名的二级索引
+//主索引的key是唯一的，二级索引的key可以重复，key相同的记录再按主索引的key排序。
+//每次修改都会先取出所有索引的key，然后在锁内一起更新所有索引，
+//所以任何时候读到的各个索引都是一致的，key提取函数panic时也不会只更新了一部分索引。
+//MultiIndex可以并发使用，但是遍历的回调中不能修改它。
+type MultiIndex struct {
+	mu        sync.RWMutex
+	primary   *index
+	secondary []*index
+	byName    map[string]*index
+}
+
+//MultiIndex中的一个索引
+type index struct {
+	def     IndexDef
+	tree    *BTree
+	primary *index //二级索引中key相同时用主索引的key排序，主索引自己为nil
+}
+
+//索引中保存的条目
+type indexEntry struct {
+	idx    *index
+	key    interface{}
+	pkey   interface{} //记录的主索引key，查找用的条目为nil，表示排在所有key相同的条目之前
+	record interface{}
+	skeys  []interface{} //主索引的条目中保存插入时记录的所有二级索引key，删除时不依赖记录当前的内容
+}
+
+func (a indexEntry) Less(than Item) bool {
+	b := than.(indexEntry)
+	if a.idx.def.less(a.key, b.key) {
+		return true
+	}
+	if a.idx.primary == nil || a.idx.def.less(b.key, a.key) {
+		return false
+	}
+	//key相同，用主索引的key排序
+	switch {
+	case b.pkey == nil:
+		return false
+	case a.pkey == nil:
+		return true
+	}
+	return a.idx.primary.def.less(a.pkey, b.pkey)
+}
+
+//NewMultiIndex用primary作为主索引，secondary作为二级索引创建一个MultiIndex，每个索引都是一个degree的BTree
+func NewMultiIndex(degree int, primary IndexDef, secondary ...IndexDef) *MultiIndex {
+	m := &MultiIndex{byName: map[string]*index{}}
+	m.primary = m.addIndex(primary, degree, nil)
+	for _, def := range secondary {
+		m.secondary = append(m.secondary, m.addIndex(def, degree, m.primary))
+	}
+	return m
+}
+
+func (m *MultiIndex) addIndex(def IndexDef, degree int, primary *index) *index {
+	if def.Key == nil {
+		panic("nil key func for index " + def.Name)
+	}
+	if _, ok := m.byName[def.Name]; ok {
+		panic("duplicate index " + def.Name)
+	}
+	idx := &index{def: def, tree: New(degree), primary: primary}
+	m.byName[def.Name] = idx
+	return idx
+}
+
+//一条记录在每个索引中的条目，entries[0]是主索引的条目
+func (m *MultiIndex) entries(record interface{}) []indexEntry {
+	skeys := make([]interface{}, len(m.secondary))
+	for i, idx := range m.secondary {
+		skeys[i] = idx.def.Key(record)
+	}
+	return m.entriesFor(m.primary.def.Key(record), skeys, record)
+}
+
+//用已经取出的key构造记录在每个索引中的条目
+func (m *MultiIndex) entriesFor(pkey interface{}, skeys []interface{}, record interface{}) []indexEntry {
+	out := make([]indexEntry, 1+len(m.secondary))
+	out[0] = indexEntry{idx: m.primary, key: pkey, pkey: pkey, record: record, skeys: skeys}
+	for i, idx := range m.secondary {
+		out[i+1] = indexEntry{idx: idx, key: skeys[i], pkey: pkey, record: record}
+	}
+	return out
+}
+
+//返回主索引中key为key的记录当前在每个索引中的条目，不存在时返回nil
+//记录插入之后可能被调用方修改过，所以使用插入时保存的key，而不是重新调用Key
+func (m *MultiIndex) lookup(key interface{}) []indexEntry {
+	out := m.primary.tree.Get(indexEntry{idx: m.primary, key: key})
+	if out == nil {
+		return nil
+	}
+	e := out.(indexEntry)
+	return m.entriesFor(e.key, e.skeys, e.record)
+}
+
+//Insert插入record，主索引中已经有相同key的记录时什么也不做并返回false
+func (m *MultiIndex) Insert(record interface{}) bool {
+	entries := m.entries(record)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.primary.tree.Has(entries[0]) {
+		return false
+	}
+	for i, e := range entries {
+		m.indexAt(i).tree.ReplaceOrInsert(e)
+	}
+	return true
+}
+
+//Replace插入record，主索引中已经有相同key的记录时替换它并返回原来的记录，否则返回nil
+//二级索引中原来记录的条目会被删除，再加入新记录的条目
+func (m *MultiIndex) Replace(record interface{}) interface{} {
+	entries := m.entries(record)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	//先取出原来记录的所有条目，再修改索引
+	var old interface{}
+	oldEntries := m.lookup(entries[0].key)
+	if oldEntries != nil {
+		old = oldEntries[0].record
+		for i, e := range oldEntries[1:] {
+			m.secondary[i].tree.Delete(e)
+		}
+	}
+	for i, e := range entries {
+		m.indexAt(i).tree.ReplaceOrInsert(e)
+	}
+	return old
+}
+
+//Delete删除主索引key为key的记录并返回它，不存在时返回nil
+func (m *MultiIndex) Delete(key interface{}) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.lookup(key)
+	if entries == nil {
+		return nil
+	}
+	for i, e := range entries {
+		m.indexAt(i).tree.Delete(e)
+	}
+	return entries[0].record
+}
+
+//Get返回主索引key为key的记录，不存在时返回nil
+func (m *MultiIndex) Get(key interface{}) interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if out := m.primary.tree.Get(indexEntry{idx: m.primary, key: key}); out != nil {
+		return out.(indexEntry).record
+	}
+	return nil
+}
+
+//记录的个数
+func (m *MultiIndex) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.primary.tree.Len()
+}
+
+//Clone在同一时刻克隆所有的索引，返回的MultiIndex和m互不影响
+func (m *MultiIndex) Clone() *MultiIndex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &MultiIndex{byName: map[string]*index{}}
+	clone := func(idx *index, primary *index) *index {
+		c := &index{def: idx.def, tree: idx.tree.Clone(), primary: primary}
+		out.byName[c.def.Name] = c
+		return c
+	}
+	out.primary = clone(m.primary, nil)
+	//条目中引用的仍然是m的index，比较时只用到def，和克隆出来的index等价
+	for _, idx := range m.secondary {
+		out.secondary = append(out.secondary, clone(idx, out.primary))
+	}
+	return out
+}
+
+//第i个索引，0是主索引
+func (m *MultiIndex) indexAt(i int) *index {
+	if i == 0 {
+		return m.primary
+	}
+	return m.secondary[i-1]
+}
+
+//IndexView是MultiIndex中一个索引的只读视图
+type IndexView struct {
+	m   *MultiIndex
+	idx *index
+}
+
+//Index返回名为name的索引，主索引也可以用它的名字访问，不存在时panic
+func (m *MultiIndex) Index(name string) IndexView {
+	idx, ok := m.byName[name]
+	if !ok {
+		panic("unknown index " + name)
+	}
+	return IndexView{m: m, idx: idx}
+}
+
+//查找用的条目，排在所有key相同的条目之前
+func (v IndexView) pivot(key interface{}) Item {
+	if key == nil {
+		return nil
+	}
+	return indexEntry{idx: v.idx, key: key}
+}
+
+//返回只传递记录的iterator
+func records(fn func(record interface{}) bool) ItemIterator {
+	return func(i Item) bool {
+		return fn(i.(indexEntry).record)
+	}
+}
+
+//AscendRange按照这个索引的key升序处理key在[greaterOrEqual, lessThan)范围内的每一条记录，直到fn返回false
+//greaterOrEqual或lessThan为nil时表示这一边没有边界
+func (v IndexView) AscendRange(greaterOrEqual, lessThan interface{}, fn func(record interface{}) bool) {
+	v.m.mu.RLock()
+	defer v.m.mu.RUnlock()
+	ge, lt := v.pivot(greaterOrEqual), v.pivot(lessThan)
+	switch {
+	case ge == nil && lt == nil:
+		v.idx.tree.Ascend(records(fn))
+	case ge == nil:
+		v.idx.tree.AscendLessThan(lt, records(fn))
+	case lt == nil:
+		v.idx.tree.AscendGreaterOrEqual(ge, records(fn))
+	default:
+		v.idx.tree.AscendRange(ge, lt, records(fn))
+	}
+}
+
+//Ascend按照这个索引的key升序处理每一条记录，直到fn返回false
+func (v IndexView) Ascend(fn func(record interface{}) bool) {
+	v.AscendRange(nil, nil, fn)
+}
+
+//Descend按照这个索引的key降序处理每一条记录，直到fn返回false
+func (v IndexView) Descend(fn func(record interface{}) bool) {
+	v.m.mu.RLock()
+	defer v.m.mu.RUnlock()
+	v.idx.tree.Descend(records(fn))
+}
+
+//Len返回这个索引中的条目数，和MultiIndex的Len相同
+func (v IndexView) Len() int {
+	v.m.mu.RLock()
+	defer v.m.mu.RUnlock()
+	return v.idx.tree.Len()
+}
@@ -0,0 +1,169 @@
+package btree
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type testRecord struct {
+	id    int
+	ts    int
+	owner string
+}
+
+func newRecordIndex() *MultiIndex {
+	return NewMultiIndex(3,
+		IndexDef{Name: "id", Key: func(r interface{}) interface{} { return Int(r.(testRecord).id) }},
+		IndexDef{Name: "ts", Key: func(r interface{}) interface{} { return r.(testRecord).ts },
+			Less: func(a, b interface{}) bool { return a.(int) < b.(int) }},
+		IndexDef{Name: "owner", Key: func(r interface{}) interface{} { return String(r.(testRecord).owner) }},
+	)
+}
+
+func collect(v IndexView, ge, lt interface{}) (out []int) {
+	v.AscendRange(ge, lt, func(r interface{}) bool {
+		out = append(out, r.(testRecord).id)
+		return true
+	})
+	return out
+}
+
+func TestMultiIndex(t *testing.T) {
+	m := newRecordIndex()
+	want := map[int]testRecord{}
+	owners := []string{"alice", "bob", "carol"}
+	for i := 0; i < 3000; i++ {
+		r := testRecord{id: rand.Intn(200), ts: rand.Intn(50), owner: owners[rand.Intn(len(owners))]}
+		switch rand.Intn(3) {
+		case 0:
+			_, exists := want[r.id]
+			if m.Insert(r) == exists {
+				t.Fatalf("Insert(%v) with exists=%v", r, exists)
+			}
+			if !exists {
+				want[r.id] = r
+			}
+		case 1:
+			old := m.Replace(r)
+			if prev, ok := want[r.id]; ok != (old != nil) || (ok && old.(testRecord) != prev) {
+				t.Fatalf("Replace(%v) returned %v, want %v", r, old, prev)
+			}
+			want[r.id] = r
+		case 2:
+			old := m.Delete(Int(r.id))
+			if prev, ok := want[r.id]; ok != (old != nil) || (ok && old.(testRecord) != prev) {
+				t.Fatalf("Delete(%v) returned %v, want %v", r.id, old, prev)
+			}
+			delete(want, r.id)
+		}
+	}
+	if m.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", m.Len(), len(want))
+	}
+	var records []testRecord
+	for _, r := range want {
+		records = append(records, r)
+		if got := m.Get(Int(r.id)); got != r {
+			t.Fatalf("Get(%d) = %v, want %v", r.id, got, r)
+		}
+	}
+	//所有索引都按key升序、key相同时按id升序
+	check := func(name string, less func(a, b testRecord) bool, ge, lt interface{}, in func(r testRecord) bool) {
+		sort.Slice(records, func(i, j int) bool {
+			a, b := records[i], records[j]
+			return less(a, b) || (!less(b, a) && a.id < b.id)
+		})
+		var expect []int
+		for _, r := range records {
+			if in(r) {
+				expect = append(expect, r.id)
+			}
+		}
+		if got := collect(m.Index(name), ge, lt); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("index %s [%v, %v):\n got: %v\nwant: %v", name, ge, lt, got, expect)
+		}
+		if m.Index(name).Len() != len(want) {
+			t.Fatalf("index %s has %d entries", name, m.Index(name).Len())
+		}
+	}
+	check("id", func(a, b testRecord) bool { return a.id < b.id }, Int(50), Int(150),
+		func(r testRecord) bool { return r.id >= 50 && r.id < 150 })
+	check("ts", func(a, b testRecord) bool { return a.ts < b.ts }, 10, 20,
+		func(r testRecord) bool { return r.ts >= 10 && r.ts < 20 })
+	check("ts", func(a, b testRecord) bool { return a.ts < b.ts }, nil, 5,
+		func(r testRecord) bool { return r.ts < 5 })
+	check("owner", func(a, b testRecord) bool { return a.owner < b.owner }, String("bob"), nil,
+		func(r testRecord) bool { return r.owner >= "bob" })
+}
+
+func TestMultiIndexClone(t *testing.T) {
+	m := newRecordIndex()
+	for i := 0; i < 100; i++ {
+		m.Insert(testRecord{id: i, ts: i % 10, owner: "alice"})
+	}
+	clone := m.Clone()
+	for i := 0; i < 50; i++ {
+		m.Delete(Int(i))
+		clone.Replace(testRecord{id: i, ts: 100, owner: "bob"})
+	}
+	if got := collect(m.Index("owner"), String("bob"), nil); len(got) != 0 {
+		t.Fatalf("clone modified original: %v", got)
+	}
+	if got := collect(clone.Index("ts"), 100, nil); len(got) != 50 {
+		t.Fatalf("clone has %d records with ts 100", len(got))
+	}
+	if m.Len() != 50 || clone.Len() != 100 || clone.Index("owner").Len() != 100 {
+		t.Fatalf("lengths: %d %d %d", m.Len(), clone.Len(), clone.Index("owner").Len())
+	}
+}
+
+func TestMultiIndexPanickingKey(t *testing.T) {
+	m := NewMultiIndex(2,
+		IndexDef{Name: "id", Key: func(r interface{}) interface{} { return Int(r.(int)) }},
+		IndexDef{Name: "half", Key: func(r interface{}) interface{} {
+			if r.(int) < 0 {
+				panic("negative")
+			}
+			return Int(r.(int) / 2)
+		}},
+	)
+	m.Insert(1)
+	func() {
+		defer func() { recover() }()
+		m.Insert(-1)
+	}()
+	if m.Len() != 1 || m.Index("half").Len() != 1 {
+		t.Fatalf("indexes out of sync after panic: %d %d", m.Len(), m.Index("half").Len())
+	}
+}
+
+func TestMultiIndexMutatedRecord(t *testing.T) {
+	m := NewMultiIndex(2,
+		IndexDef{Name: "id", Key: func(r interface{}) interface{} { return Int(r.(*testRecord).id) }},
+		IndexDef{Name: "ts", Key: func(r interface{}) interface{} { return Int(r.(*testRecord).ts) }},
+	)
+	for i := 0; i < 20; i++ {
+		m.Insert(&testRecord{id: i, ts: i})
+	}
+	//插入之后直接修改记录，二级索引中的条目仍然在原来的位置
+	r := m.Get(Int(5)).(*testRecord)
+	r.ts = 100
+	m.Replace(&testRecord{id: 5, ts: 50})
+	r = m.Get(Int(6)).(*testRecord)
+	r.ts = 200
+	m.Delete(Int(6))
+	if m.Index("ts").Len() != 19 {
+		t.Fatalf("ts index has %d entries, want 19", m.Index("ts").Len())
+	}
+	var ts []int
+	m.Index("ts").Ascend(func(r interface{}) bool {
+		ts = append(ts, r.(*testRecord).ts)
+		return true
+	})
+	want := []int{0, 1, 2, 3, 4, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 50}
+	if !reflect.DeepEqual(ts, want) {
+		t.Fatalf("ts index = %v, want %v", ts, want)
+	}
+}
@@ -0,0 +1,73 @@
+package btree
+
+//Observer在tree被修改之后收到通知，用来同步维护缓存、计数器等附属的数据结构
+//所有回调都在修改完成之后同步调用：回调中看到的是修改之后的tree（Len、Get等已经反映了这次修改），
+//回调中可以读取tree，但是不能修改它。
+//ApplyBatch和DeleteFunc在整个批量修改完成之后，按照key的升序依次通知每一个变化。
+type Observer interface {
+	//插入了一个新的item
+	OnInsert(item Item)
+	//old被和它相等的new替换
+	OnReplace(old, new Item)
+	//删除了item
+	OnDelete(item Item)
+	//tree被Clear清空
+	OnClear()
+}
+
+//Observe注册一个Observer，之后对tree的每一次修改都会通知它，多个Observer按照注册的顺序调用
+//Clone出来的tree不会继承Observer，需要继承时使用CloneWithObservers
+func (t *BTree) Observe(o Observer) {
+	t.observers = append(t.observers, o)
+}
+
+//CloneWithObservers和Clone一样，但是返回的tree也会通知t当前的所有Observer
+func (t *BTree) CloneWithObservers() *BTree {
+	out := t.Clone()
+	out.observers = append([]Observer(nil), t.observers...)
+	return out
+}
+
+//一次修改的类型
+type changeKind int
+
+const (
+	changeInsert changeKind = iota
+	changeReplace
+	changeDelete
+)
+
+//批量修改中记录下来的一次变化
+type change struct {
+	kind     changeKind
+	old, new Item
+}
+
+//变化的key，删除时new为nil
+func (c change) key() Item {
+	if c.new != nil {
+		return c.new
+	}
+	return c.old
+}
+
+//通知一次变化
+func (t *BTree) notify(kind changeKind, old, new Item) {
+	for _, o := range t.observers {
+		switch kind {
+		case changeInsert:
+			o.OnInsert(new)
+		case changeReplace:
+			o.OnReplace(old, new)
+		case changeDelete:
+			o.OnDelete(old)
+		}
+	}
+}
+
+//依次通知批量修改中记录下来的变化
+func (t *BTree) notifyAll(changes []change) {
+	for _, c := range changes {
+		t.notify(c.kind, c.old, c.new)
+	}
+}
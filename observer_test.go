@@ -0,0 +1,169 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+//mirrorObserver用一个map同步维护tree中的item，并检查回调时看到的是修改之后的tree
+type mirrorObserver struct {
+	t      *testing.T
+	tr     *BTree
+	mirror map[Int]bool
+	events int
+}
+
+func (m *mirrorObserver) OnInsert(item Item) {
+	m.events++
+	if m.mirror[item.(Int)] {
+		m.t.Fatalf("OnInsert(%v) for an existing item", item)
+	}
+	m.mirror[item.(Int)] = true
+	m.check(item, true)
+}
+
+func (m *mirrorObserver) OnReplace(old, new Item) {
+	m.events++
+	if !m.mirror[old.(Int)] || old.(Int) != new.(Int) {
+		m.t.Fatalf("OnReplace(%v, %v) for a missing item", old, new)
+	}
+	m.check(new, true)
+}
+
+func (m *mirrorObserver) OnDelete(item Item) {
+	m.events++
+	if !m.mirror[item.(Int)] {
+		m.t.Fatalf("OnDelete(%v) for a missing item", item)
+	}
+	delete(m.mirror, item.(Int))
+	m.check(item, false)
+}
+
+func (m *mirrorObserver) OnClear() {
+	m.events++
+	m.mirror = map[Int]bool{}
+	m.check(nil, false)
+}
+
+func (m *mirrorObserver) check(item Item, has bool) {
+	if item != nil && m.tr.Has(item) != has {
+		m.t.Fatalf("hook for %v sees Has()=%v", item, !has)
+	}
+}
+
+func (m *mirrorObserver) verify() {
+	m.t.Helper()
+	if m.tr.Len() != len(m.mirror) {
+		m.t.Fatalf("Len()=%d, mirror has %d", m.tr.Len(), len(m.mirror))
+	}
+	for v := range m.mirror {
+		if !m.tr.Has(v) {
+			m.t.Fatalf("mirror has %v, tree doesn't", v)
+		}
+	}
+}
+
+func TestObserver(t *testing.T) {
+	tr := New(3)
+	m := &mirrorObserver{t: t, tr: tr, mirror: map[Int]bool{}}
+	tr.Observe(m)
+	for round := 0; round < 2000; round++ {
+		v := Int(rand.Intn(300))
+		switch rand.Intn(8) {
+		case 0:
+			tr.ReplaceOrInsert(v)
+		case 1:
+			tr.Delete(v)
+		case 2:
+			tr.DeleteMin()
+		case 3:
+			tr.DeleteMax()
+		case 4:
+			tr.Upsert(v, func(old Item, exists bool) (Item, bool) { return v, !exists })
+		case 5:
+			before := m.events
+			if _, inserted := tr.GetOrInsert(v); !inserted && m.events != before {
+				t.Fatalf("GetOrInsert of an existing item notified observer")
+			}
+		case 6:
+			ops := []Op{{Kind: OpInsert, Item: v}, {Kind: OpDelete, Item: v + 1}, {Kind: OpInsert, Item: v + 2}}
+			tr.ApplyBatch(ops)
+		case 7:
+			tr.DeleteFunc(v, v+20, func(a Item) bool { return a.(Int)%3 == 0 })
+		}
+		m.verify()
+	}
+	tr.Clear(true)
+	m.verify()
+	if len(m.mirror) != 0 {
+		t.Fatalf("mirror not cleared")
+	}
+}
+
+func TestObserverClone(t *testing.T) {
+	tr := New(*btreeDegree)
+	m := &mirrorObserver{t: t, tr: tr, mirror: map[Int]bool{}}
+	tr.Observe(m)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	events := m.events
+	clone := tr.Clone()
+	clone.Delete(Int(1))
+	clone.Clear(false)
+	if m.events != events {
+		t.Fatalf("Clone inherited observers")
+	}
+	clone = tr.CloneWithObservers()
+	m.tr = clone
+	clone.Delete(Int(1))
+	if m.events != events+1 || m.mirror[1] {
+		t.Fatalf("CloneWithObservers didn't notify")
+	}
+}
+
+//orderObserver检查一次批量修改中的通知是否按key升序
+type orderObserver struct {
+	t    *testing.T
+	keys []Int
+}
+
+func (o *orderObserver) OnInsert(item Item)      { o.keys = append(o.keys, item.(Int)) }
+func (o *orderObserver) OnReplace(old, new Item) { o.keys = append(o.keys, new.(Int)) }
+func (o *orderObserver) OnDelete(item Item)      { o.keys = append(o.keys, item.(Int)) }
+func (o *orderObserver) OnClear()                {}
+
+func (o *orderObserver) verify() {
+	o.t.Helper()
+	for i := 1; i < len(o.keys); i++ {
+		if !o.keys[i-1].Less(o.keys[i]) {
+			o.t.Fatalf("observer events not ascending at %d: %v then %v", i, o.keys[i-1], o.keys[i])
+		}
+	}
+	o.keys = o.keys[:0]
+}
+
+func TestObserverBatchOrder(t *testing.T) {
+	tr := New(3)
+	o := &orderObserver{t: t}
+	tr.Observe(o)
+	for _, v := range perm(500) {
+		tr.ReplaceOrInsert(v)
+	}
+	o.keys = o.keys[:0]
+	for round := 0; round < 200; round++ {
+		var ops []Op
+		for i := 0; i < 50; i++ {
+			kind := OpInsert
+			if rand.Intn(2) == 0 {
+				kind = OpDelete
+			}
+			ops = append(ops, Op{Kind: kind, Item: Int(rand.Intn(600))})
+		}
+		tr.ApplyBatch(ops)
+		o.verify()
+		lo := Int(rand.Intn(600))
+		tr.DeleteFunc(lo, lo+100, func(a Item) bool { return rand.Intn(3) == 0 })
+		o.verify()
+	}
+}
@@ -0,0 +1,154 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//Direction是分页的方向
+type Direction int
+
+const (
+	Ascending  = Direction(ascend)  //升序
+	Descending = Direction(descend) //降序
+)
+
+//ErrBadPageToken表示无法解码的分页token
+var ErrBadPageToken = errors.New("btree: bad page token")
+
+//PageToken记录了分页的位置，零值表示从头开始按key分页
+//按key分页时token记录上一页的最后一个key，下一页从严格大于（降序时严格小于）它的item开始，
+//所以两页之间插入或删除item都不会导致重复或遗漏，同一个token也可以用在Clone出来的tree上。
+//按位置分页时token记录下一页的起始位置，适合在不变的快照（比如Clone）上跳页。
+type PageToken struct {
+	dir      Direction
+	started  bool //是否已经翻过页，翻过页之后沿用token中的方向
+	byOffset bool
+	offset   int
+	last     Item
+	done     bool
+}
+
+//OffsetToken返回一个从第offset个item开始按位置分页的token
+func OffsetToken(offset int) PageToken {
+	if offset < 0 {
+		offset = 0
+	}
+	return PageToken{byOffset: true, offset: offset}
+}
+
+//Done表示已经没有下一页了
+func (p PageToken) Done() bool {
+	return p.done
+}
+
+//Page返回从start开始最多limit个item，以及下一页的token
+//dir只在start还没有翻过页的时候生效，之后的页沿用token中的方向
+func (t *BTree) Page(start PageToken, limit int, dir Direction) ([]Item, PageToken) {
+	if start.done || limit <= 0 {
+		return nil, start
+	}
+	next := start
+	if !next.started {
+		next.dir = dir
+		next.started = true
+	}
+	//多取一个，用来判断是否还有下一页
+	out := make([]Item, 0, limit+1)
+	collect := func(i Item) bool {
+		out = append(out, i)
+		return len(out) <= limit
+	}
+	if next.byOffset {
+		if t.root != nil && next.offset < t.length {
+			index := next.offset
+			if next.dir == Descending {
+				index = t.length - 1 - next.offset
+			}
+			t.root.iterate(direction(next.dir), t.root.at(index), nil, true, false, collect)
+		}
+	} else if t.root != nil {
+		t.root.iterate(direction(next.dir), next.last, nil, false, false, collect)
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	} else {
+		next.done = true
+	}
+	if len(out) > 0 {
+		next.last = out[len(out)-1]
+	}
+	next.offset += len(out)
+	return out, next
+}
+
+const (
+	tokenStarted = 1 << iota
+	tokenByOffset
+	tokenDone
+	tokenDescending
+	tokenHasLast
+)
+
+//Encode将token编码成字节，c用来编码token中的key
+func (p PageToken) Encode(c Codec) ([]byte, error) {
+	var flags byte
+	if p.started {
+		flags |= tokenStarted
+	}
+	if p.byOffset {
+		flags |= tokenByOffset
+	}
+	if p.done {
+		flags |= tokenDone
+	}
+	if p.dir == Descending {
+		flags |= tokenDescending
+	}
+	if p.last != nil && !p.byOffset {
+		flags |= tokenHasLast
+	}
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = flags
+	buf = buf[:1+binary.PutUvarint(buf[1:], uint64(p.offset))]
+	if flags&tokenHasLast != 0 {
+		key, err := c.EncodeItem(p.last)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, key...)
+	}
+	return buf, nil
+}
+
+//DecodePageToken解码Encode的结果
+func DecodePageToken(data []byte, c Codec) (PageToken, error) {
+	var p PageToken
+	if len(data) < 2 {
+		return p, ErrBadPageToken
+	}
+	flags := data[0]
+	offset, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return p, ErrBadPageToken
+	}
+	data = data[1+n:]
+	p.started = flags&tokenStarted != 0
+	p.byOffset = flags&tokenByOffset != 0
+	p.done = flags&tokenDone != 0
+	p.dir = Ascending
+	if flags&tokenDescending != 0 {
+		p.dir = Descending
+	}
+	p.offset = int(offset)
+	if flags&tokenHasLast != 0 {
+		last, err := c.DecodeItem(data)
+		if err != nil {
+			return PageToken{}, ErrBadPageToken
+		}
+		p.last = last
+	} else if len(data) != 0 {
+		return PageToken{}, ErrBadPageToken
+	}
+	return p, nil
+}
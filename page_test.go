@@ -0,0 +1,101 @@
+package btree
+
+import (
+	"reflect"
+	"testing"
+)
+
+//pageAll从token开始逐页读取tr，返回读到的所有item
+func pageAll(t *testing.T, tr *BTree, token PageToken, limit int, dir Direction) (out []Item) {
+	for pages := 0; !token.Done(); pages++ {
+		if pages > tr.Len()+1 {
+			t.Fatalf("too many pages")
+		}
+		var page []Item
+		page, token = tr.Page(token, limit, dir)
+		if len(page) > limit {
+			t.Fatalf("page of %d items, limit %d", len(page), limit)
+		}
+		//和HTTP handler一样把token编码之后再解码
+		data, err := token.Encode(IntCodec{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token, err = DecodePageToken(data, IntCodec{}); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, page...)
+	}
+	return out
+}
+
+func TestPage(t *testing.T) {
+	tr := New(3)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	for _, limit := range []int{1, 7, 10, 100, 1000} {
+		if got, want := pageAll(t, tr, PageToken{}, limit, Ascending), rang(100); !reflect.DeepEqual(got, want) {
+			t.Fatalf("ascending limit %d:\n got: %v\nwant: %v", limit, got, want)
+		}
+		if got, want := pageAll(t, tr, PageToken{}, limit, Descending), rangrev(100); !reflect.DeepEqual(got, want) {
+			t.Fatalf("descending limit %d:\n got: %v\nwant: %v", limit, got, want)
+		}
+		if got, want := pageAll(t, tr, OffsetToken(0), limit, Ascending), rang(100); !reflect.DeepEqual(got, want) {
+			t.Fatalf("offset ascending limit %d:\n got: %v\nwant: %v", limit, got, want)
+		}
+		if got, want := pageAll(t, tr, OffsetToken(0), limit, Descending), rangrev(100); !reflect.DeepEqual(got, want) {
+			t.Fatalf("offset descending limit %d:\n got: %v\nwant: %v", limit, got, want)
+		}
+	}
+	got, next := tr.Page(OffsetToken(95), 10, Ascending)
+	if want := rang(100)[95:]; !reflect.DeepEqual(got, want) || !next.Done() {
+		t.Fatalf("offset 95: got %v done=%v", got, next.Done())
+	}
+}
+
+func TestPageMutationsBetweenPages(t *testing.T) {
+	tr := New(3)
+	for i := 0; i < 100; i += 2 {
+		tr.ReplaceOrInsert(Int(i))
+	}
+	page, token := tr.Page(PageToken{}, 10, Ascending)
+	if want := []Item{Int(0), Int(2), Int(4), Int(6), Int(8), Int(10), Int(12), Int(14), Int(16), Int(18)}; !reflect.DeepEqual(page, want) {
+		t.Fatalf("first page: %v", page)
+	}
+	//删除上一页的最后一个key，并在它前后各插入一个
+	tr.Delete(Int(18))
+	tr.ReplaceOrInsert(Int(17))
+	tr.ReplaceOrInsert(Int(19))
+	page, _ = tr.Page(token, 3, Ascending)
+	if want := []Item{Int(19), Int(20), Int(22)}; !reflect.DeepEqual(page, want) {
+		t.Fatalf("second page: got %v, want %v", page, want)
+	}
+}
+
+func TestPageClone(t *testing.T) {
+	tr := New(*btreeDegree)
+	for _, v := range perm(100) {
+		tr.ReplaceOrInsert(v)
+	}
+	snapshot := tr.Clone()
+	for _, v := range perm(50) {
+		tr.Delete(v)
+	}
+	if got, want := pageAll(t, snapshot, OffsetToken(0), 9, Ascending), rang(100); !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot:\n got: %v\nwant: %v", got, want)
+	}
+	//从快照中得到的key token可以在原来的tree上继续，并且保持原来的方向
+	_, token := snapshot.Page(PageToken{}, 60, Ascending)
+	if got, want := pageAll(t, tr, token, 9, Descending), rang(100)[60:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("resume:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestDecodePageTokenErrors(t *testing.T) {
+	for _, data := range [][]byte{nil, {0}, {tokenHasLast, 0}, {0, 0, 1}} {
+		if _, err := DecodePageToken(data, IntCodec{}); err != ErrBadPageToken {
+			t.Errorf("DecodePageToken(%v): got %v", data, err)
+		}
+	}
+}
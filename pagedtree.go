@@ -0,0 +1,781 @@
+package btree
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+//PagedOptions是PagedBTree的配置，已经存在的文件使用文件中记录的degree和页大小
+type PagedOptions struct {
+	Degree   int //tree的degree，为0时使用32
+	PageSize int //页大小，为0时使用DefaultPageSize
+	PoolSize int //缓冲池最多缓存的节点数，为0时使用DefaultPoolSize
+}
+
+//PagedBTree是保存在本地文件中的B-Tree，每个节点保存在一页（放不下时是一串页）中
+//读取时通过有界的LRU缓冲池按需加载节点，所以tree可以比内存大得多。
+//修改使用影子分页（shadow paging）：和mutableFor一样，已经提交的节点是只读的，
+//修改时先在内存中复制出一个新节点，Commit时把所有修改过的节点写到空闲页上，
+//fsync之后再写入新的元数据，所以任何时候崩溃，文件中都是上一次或者这一次提交的完整tree。
+//Commit之前的修改只在内存中，Rollback或者Close会丢弃它们。
+//
+//查询和修改的方法和BTree一样。读取页面失败或者页面损坏时，第一个错误保存下来由Err、Commit和Close返回，
+//之后查询返回空的结果，修改不再生效，只能关闭之后重新打开。
+//PagedBTree不能并发使用。
+type PagedBTree struct {
+	pager  *pager
+	pool   *bufferPool
+	codec  Codec
+	degree int
+	length int
+	root   pageRef
+	meta   pageMeta //上一次提交的元数据
+	err    error    //读取节点或者提交失败之后的错误，之后只能关闭
+}
+
+//对子节点的引用：已经提交的节点只记录页号，修改过的节点直接引用内存中的节点
+type pageRef struct {
+	id uint64
+	n  *pnode
+}
+
+//PagedBTree的节点
+type pnode struct {
+	items    items
+	children []pageRef
+	id       uint64   //节点所在的第一页，修改过的节点为0
+	pages    []uint64 //节点所在的所有页
+	dirty    bool     //是否是修改过还没有提交的节点
+}
+
+//OpenPaged打开path中的PagedBTree，文件不存在时创建它
+//c用来把item编码到页中
+func OpenPaged(path string, c Codec, opts PagedOptions) (*PagedBTree, error) {
+	if opts.Degree == 0 {
+		opts.Degree = 32
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.Degree <= 1 {
+		panic("bad degree")
+	}
+	if opts.PageSize < minPageSize {
+		panic("bad page size")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	t := &PagedBTree{
+		pager: &pager{file: f},
+		pool:  newBufferPool(opts.PoolSize),
+		codec: c,
+	}
+	if err := t.load(opts); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+//读取元数据和空闲页列表，空文件时初始化元数据页
+func (t *PagedBTree) load(opts PagedOptions) error {
+	info, err := t.pager.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		t.meta = pageMeta{pageSize: uint32(opts.PageSize), degree: uint32(opts.Degree), pageCount: metaPages}
+		t.pager.pageSize, t.pager.pageCount = opts.PageSize, metaPages
+		t.degree = opts.Degree
+		//先写满整个元数据页，再把两份元数据都写一遍，保证之后总有一份是有效的
+		if _, err := t.pager.file.WriteAt(make([]byte, opts.PageSize), 0); err != nil {
+			return err
+		}
+		for i := 0; i < metaSlots; i++ {
+			if err := t.writeMeta(i); err != nil {
+				return err
+			}
+		}
+		return t.pager.file.Sync()
+	}
+	var best pageMeta
+	found := false
+	buf := make([]byte, pageMetaSize)
+	for i := 0; i < metaSlots; i++ {
+		if _, err := t.pager.file.ReadAt(buf, int64(i*metaSlotSize)); err != nil {
+			continue
+		}
+		if m, ok := decodePageMeta(buf); ok && (!found || m.txid > best.txid) {
+			best, found = m, true
+		}
+	}
+	if !found {
+		return ErrBadPageFile
+	}
+	t.meta = best
+	t.pager.pageSize, t.pager.pageCount = int(best.pageSize), best.pageCount
+	t.degree, t.length = int(best.degree), int(best.length)
+	t.root = pageRef{id: best.root}
+	if best.freelist != 0 {
+		data, ids, err := t.pager.readBlob(best.freelist)
+		if err != nil {
+			return err
+		}
+		if t.pager.free, err = decodePageList(data); err != nil {
+			return err
+		}
+		t.pager.blobPages = ids
+	}
+	return nil
+}
+
+//把当前的元数据写到元数据页中的第slot个位置
+func (t *PagedBTree) writeMeta(slot int) error {
+	buf := make([]byte, metaSlotSize)
+	copy(buf, t.meta.encode())
+	_, err := t.pager.file.WriteAt(buf, int64(slot*metaSlotSize))
+	return err
+}
+
+//maxItems返回每个node允许的最大items数
+func (t *PagedBTree) maxItems() int {
+	return t.degree*2 - 1
+}
+
+//minItems返回每个node允许的最小items数
+func (t *PagedBTree) minItems() int {
+	return t.degree - 1
+}
+
+//节点的编码：uvarint的item数和子节点数，子节点的页号，每个item的uvarint长度和编码
+func (t *PagedBTree) encodeNode(n *pnode) ([]byte, error) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf := append([]byte(nil), tmp[:binary.PutUvarint(tmp[:], uint64(len(n.items)))]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(n.children)))]...)
+	for _, c := range n.children {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], c.id)]...)
+	}
+	for _, item := range n.items {
+		data, err := t.codec.EncodeItem(item)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+func (t *PagedBTree) decodeNode(data []byte) (*pnode, error) {
+	n := &pnode{}
+	count, k := binary.Uvarint(data)
+	if k <= 0 || count > uint64(len(data)) {
+		return nil, ErrBadPageFile
+	}
+	data = data[k:]
+	nchildren, k := binary.Uvarint(data)
+	if k <= 0 || nchildren > uint64(len(data)) {
+		return nil, ErrBadPageFile
+	}
+	data = data[k:]
+	for i := uint64(0); i < nchildren; i++ {
+		id, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, ErrBadPageFile
+		}
+		n.children = append(n.children, pageRef{id: id})
+		data = data[k:]
+	}
+	n.items = make(items, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, k := binary.Uvarint(data)
+		if k <= 0 || uint64(len(data)-k) < size {
+			return nil, ErrBadPageFile
+		}
+		item, err := t.codec.DecodeItem(data[k : k+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+		data = data[k+int(size):]
+	}
+	return n, nil
+}
+
+//node读取节点失败时的panic值，只在PagedBTree内部使用，由导出的方法recover
+type pageFault struct{}
+
+//recover读取节点失败时的panic，其它panic继续向上传递
+func (t *PagedBTree) catch() {
+	if r := recover(); r != nil {
+		if _, ok := r.(pageFault); !ok {
+			panic(r)
+		}
+	}
+}
+
+//记录读取节点的错误，然后回到导出的方法中
+func (t *PagedBTree) fail(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+	panic(pageFault{})
+}
+
+//返回ref引用的节点，已经提交的节点先在缓冲池中查找，没有时从文件中读取
+func (t *PagedBTree) node(ref pageRef) *pnode {
+	if ref.n != nil {
+		return ref.n
+	}
+	if n := t.pool.get(ref.id); n != nil {
+		return n
+	}
+	data, pages, err := t.pager.readBlob(ref.id)
+	if err != nil {
+		t.fail(err)
+	}
+	n, err := t.decodeNode(data)
+	if err != nil {
+		t.fail(err)
+	}
+	n.id, n.pages = ref.id, pages
+	t.pool.put(n)
+	return n
+}
+
+//和mutableFor一样，已经提交的节点不能修改，复制出一个修改过的节点，原来的页在提交之后释放
+func (t *PagedBTree) mutable(n *pnode) *pnode {
+	if n.dirty {
+		return n
+	}
+	out := &pnode{dirty: true}
+	out.items = append(make(items, 0, len(n.items)+1), n.items...)
+	if len(n.children) > 0 {
+		out.children = append(make([]pageRef, 0, len(n.children)+1), n.children...)
+	}
+	t.release(n)
+	return out
+}
+
+//释放已经提交的节点所在的页
+func (t *PagedBTree) release(n *pnode) {
+	if n.dirty {
+		return
+	}
+	t.pool.remove(n.id)
+	t.pager.pending = append(t.pager.pending, n.pages...)
+}
+
+//让n的第i个子节点可变
+func (t *PagedBTree) mutableChild(n *pnode, i int) *pnode {
+	c := t.mutable(t.node(n.children[i]))
+	n.children[i] = pageRef{n: c}
+	return c
+}
+
+func (t *PagedBTree) mutableRoot() *pnode {
+	r := t.mutable(t.node(t.root))
+	t.root = pageRef{n: r}
+	return r
+}
+
+//和node.split一样，拆分n并返回第i个item和新节点
+func (t *PagedBTree) split(n *pnode, i int) (Item, *pnode) {
+	item := n.items[i]
+	next := &pnode{dirty: true}
+	next.items = append(next.items, n.items[i+1:]...)
+	n.items.truncate(i)
+	if len(n.children) > 0 {
+		next.children = append(next.children, n.children[i+1:]...)
+		for j := i + 1; j < len(n.children); j++ {
+			n.children[j] = pageRef{}
+		}
+		n.children = n.children[:i+1]
+	}
+	return item, next
+}
+
+//和node.maybeSplitChild一样，子节点已满时拆分它
+func (t *PagedBTree) maybeSplitChild(n *pnode, i, maxItems int) bool {
+	if len(t.node(n.children[i]).items) < maxItems {
+		return false
+	}
+	first := t.mutableChild(n, i)
+	item, second := t.split(first, maxItems/2)
+	n.items.insertAt(i, item)
+	n.children = append(n.children, pageRef{})
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = pageRef{n: second}
+	return true
+}
+
+//和node.insert一样，n必须是可变的
+func (t *PagedBTree) insert(n *pnode, item Item, maxItems int) Item {
+	i, found := n.items.find(item)
+	if found {
+		out := n.items[i]
+		n.items[i] = item
+		return out
+	}
+	if len(n.children) == 0 {
+		n.items.insertAt(i, item)
+		return nil
+	}
+	if t.maybeSplitChild(n, i, maxItems) {
+		inTree := n.items[i]
+		switch {
+		case item.Less(inTree):
+		case inTree.Less(item):
+			i++
+		default:
+			out := n.items[i]
+			n.items[i] = item
+			return out
+		}
+	}
+	return t.insert(t.mutableChild(n, i), item, maxItems)
+}
+
+//和node.remove一样，n必须是可变的
+func (t *PagedBTree) remove(n *pnode, item Item, minItems int, typ toRemove) Item {
+	var i int
+	var found bool
+	switch typ {
+	case removeMax:
+		if len(n.children) == 0 {
+			return n.items.pop()
+		}
+		i = len(n.items)
+	case removeMin:
+		if len(n.children) == 0 {
+			return n.items.removeAt(0)
+		}
+		i = 0
+	case removeItem:
+		i, found = n.items.find(item)
+		if len(n.children) == 0 {
+			if found {
+				return n.items.removeAt(i)
+			}
+			return nil
+		}
+	default:
+		panic("invalid type")
+	}
+	if len(t.node(n.children[i]).items) <= minItems {
+		return t.growChildAndRemove(n, i, item, minItems, typ)
+	}
+	child := t.mutableChild(n, i)
+	if found {
+		out := n.items[i]
+		n.items[i] = t.remove(child, nil, minItems, removeMax)
+		return out
+	}
+	return t.remove(child, item, minItems, typ)
+}
+
+//和node.growChildAndRemove一样，先从兄弟节点窃取或者和兄弟节点合并，再重新删除
+func (t *PagedBTree) growChildAndRemove(n *pnode, i int, item Item, minItems int, typ toRemove) Item {
+	if i > 0 && len(t.node(n.children[i-1]).items) > minItems {
+		//从左子节点窃取
+		child := t.mutableChild(n, i)
+		stealFrom := t.mutableChild(n, i-1)
+		stolenItem := stealFrom.items.pop()
+		child.items.insertAt(0, n.items[i-1])
+		n.items[i-1] = stolenItem
+		if len(stealFrom.children) > 0 {
+			last := len(stealFrom.children) - 1
+			child.children = append([]pageRef{stealFrom.children[last]}, child.children...)
+			stealFrom.children = stealFrom.children[:last]
+		}
+	} else if i < len(n.items) && len(t.node(n.children[i+1]).items) > minItems {
+		//从右子节点窃取
+		child := t.mutableChild(n, i)
+		stealFrom := t.mutableChild(n, i+1)
+		stolenItem := stealFrom.items.removeAt(0)
+		child.items = append(child.items, n.items[i])
+		n.items[i] = stolenItem
+		if len(stealFrom.children) > 0 {
+			child.children = append(child.children, stealFrom.children[0])
+			stealFrom.children = append(stealFrom.children[:0], stealFrom.children[1:]...)
+		}
+	} else {
+		//和右子节点合并
+		if i >= len(n.items) {
+			i--
+		}
+		child := t.mutableChild(n, i)
+		mergeItem := n.items.removeAt(i)
+		mergeChild := t.node(n.children[i+1])
+		copy(n.children[i+1:], n.children[i+2:])
+		n.children = n.children[:len(n.children)-1]
+		child.items = append(child.items, mergeItem)
+		child.items = append(child.items, mergeChild.items...)
+		child.children = append(child.children, mergeChild.children...)
+		t.release(mergeChild)
+	}
+	return t.remove(n, item, minItems, typ)
+}
+
+//和node.iterate一样
+func (t *PagedBTree) iterate(n *pnode, dir direction, start, stop Item, includeStart bool, hit bool, iter ItemIterator) (bool, bool) {
+	var ok, found bool
+	var index int
+	switch dir {
+	case ascend:
+		if start != nil {
+			index, _ = n.items.find(start)
+		}
+		for i := index; i < len(n.items); i++ {
+			if len(n.children) > 0 {
+				if hit, ok = t.iterate(t.node(n.children[i]), dir, start, stop, includeStart, hit, iter); !ok {
+					return hit, false
+				}
+			}
+			if !includeStart && !hit && start != nil && !start.Less(n.items[i]) {
+				hit = true
+				continue
+			}
+			hit = true
+			if stop != nil && !n.items[i].Less(stop) {
+				return hit, false
+			}
+			if !iter(n.items[i]) {
+				return hit, false
+			}
+		}
+		if len(n.children) > 0 {
+			if hit, ok = t.iterate(t.node(n.children[len(n.children)-1]), dir, start, stop, includeStart, hit, iter); !ok {
+				return hit, false
+			}
+		}
+	case descend:
+		if start != nil {
+			index, found = n.items.find(start)
+			if !found {
+				index = index - 1
+			}
+		} else {
+			index = len(n.items) - 1
+		}
+		for i := index; i >= 0; i-- {
+			if start != nil && !n.items[i].Less(start) {
+				if !includeStart || hit || start.Less(n.items[i]) {
+					continue
+				}
+			}
+			if len(n.children) > 0 {
+				if hit, ok = t.iterate(t.node(n.children[i+1]), dir, start, stop, includeStart, hit, iter); !ok {
+					return hit, false
+				}
+			}
+			if stop != nil && !stop.Less(n.items[i]) {
+				return hit, false
+			}
+			hit = true
+			if !iter(n.items[i]) {
+				return hit, false
+			}
+		}
+		if len(n.children) > 0 {
+			if hit, ok = t.iterate(t.node(n.children[0]), dir, start, stop, includeStart, hit, iter); !ok {
+				return hit, false
+			}
+		}
+	}
+	return hit, true
+}
+
+//tree是否为空
+func (t *PagedBTree) empty() bool {
+	return t.root.n == nil && t.root.id == 0
+}
+
+//ReplaceOrInsert和BTree.ReplaceOrInsert一样，修改在Commit之后才会写入文件
+func (t *PagedBTree) ReplaceOrInsert(item Item) Item {
+	if item == nil {
+		panic("nil item being added to BTree")
+	}
+	if t.err != nil {
+		return nil
+	}
+	defer t.catch()
+	if t.empty() {
+		t.root = pageRef{n: &pnode{items: items{item}, dirty: true}}
+		t.length++
+		return nil
+	}
+	root := t.mutableRoot()
+	if len(root.items) >= t.maxItems() {
+		item2, second := t.split(root, t.maxItems()/2)
+		root = &pnode{items: items{item2}, children: []pageRef{{n: root}, {n: second}}, dirty: true}
+		t.root = pageRef{n: root}
+	}
+	out := t.insert(root, item, t.maxItems())
+	if out == nil {
+		t.length++
+	}
+	return out
+}
+
+//Delete和BTree.Delete一样，修改在Commit之后才会写入文件
+func (t *PagedBTree) Delete(item Item) Item {
+	return t.deleteItem(item, removeItem)
+}
+
+//DeleteMin删除tree中最小的item，并把它返回，不存在就返回nil
+func (t *PagedBTree) DeleteMin() Item {
+	return t.deleteItem(nil, removeMin)
+}
+
+//DeleteMax删除tree中最大的item，并把它返回，不存在就返回nil
+func (t *PagedBTree) DeleteMax() Item {
+	return t.deleteItem(nil, removeMax)
+}
+
+func (t *PagedBTree) deleteItem(item Item, typ toRemove) Item {
+	if t.err != nil {
+		return nil
+	}
+	defer t.catch()
+	if t.empty() || len(t.node(t.root).items) == 0 {
+		return nil
+	}
+	root := t.mutableRoot()
+	out := t.remove(root, item, t.minItems(), typ)
+	if len(root.items) == 0 && len(root.children) > 0 {
+		t.root = root.children[0]
+	}
+	if out != nil {
+		t.length--
+	}
+	return out
+}
+
+//AscendRange和BTree.AscendRange一样
+func (t *PagedBTree) AscendRange(greaterOrEqual, lessThan Item, iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), ascend, greaterOrEqual, lessThan, true, false, iterator)
+}
+
+//AscendLessThan和BTree.AscendLessThan一样
+func (t *PagedBTree) AscendLessThan(pivot Item, iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), ascend, nil, pivot, false, false, iterator)
+}
+
+//AscendGreaterOrEqual和BTree.AscendGreaterOrEqual一样
+func (t *PagedBTree) AscendGreaterOrEqual(pivot Item, iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), ascend, pivot, nil, true, false, iterator)
+}
+
+//Ascend和BTree.Ascend一样
+func (t *PagedBTree) Ascend(iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), ascend, nil, nil, false, false, iterator)
+}
+
+//DescendRange和BTree.DescendRange一样
+func (t *PagedBTree) DescendRange(lessOrEqual, greaterThan Item, iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), descend, lessOrEqual, greaterThan, true, false, iterator)
+}
+
+//DescendLessOrEqual和BTree.DescendLessOrEqual一样
+func (t *PagedBTree) DescendLessOrEqual(pivot Item, iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), descend, pivot, nil, true, false, iterator)
+}
+
+//DescendGreaterThan和BTree.DescendGreaterThan一样
+func (t *PagedBTree) DescendGreaterThan(pivot Item, iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), descend, nil, pivot, false, false, iterator)
+}
+
+//Descend和BTree.Descend一样
+func (t *PagedBTree) Descend(iterator ItemIterator) {
+	if t.empty() || t.err != nil {
+		return
+	}
+	defer t.catch()
+	t.iterate(t.node(t.root), descend, nil, nil, false, false, iterator)
+}
+
+//Get在tree中查找和key相等的item
+func (t *PagedBTree) Get(key Item) Item {
+	if t.empty() || t.err != nil {
+		return nil
+	}
+	defer t.catch()
+	for n := t.node(t.root); ; {
+		i, found := n.items.find(key)
+		if found {
+			return n.items[i]
+		}
+		if len(n.children) == 0 {
+			return nil
+		}
+		n = t.node(n.children[i])
+	}
+}
+
+//Has返回tree中是否有和key相等的item
+func (t *PagedBTree) Has(key Item) bool {
+	return t.Get(key) != nil
+}
+
+//Min返回tree中最小的item
+func (t *PagedBTree) Min() Item {
+	var out Item
+	t.Ascend(func(i Item) bool {
+		out = i
+		return false
+	})
+	return out
+}
+
+//Max返回tree中最大的item
+func (t *PagedBTree) Max() Item {
+	var out Item
+	t.Descend(func(i Item) bool {
+		out = i
+		return false
+	})
+	return out
+}
+
+//Err返回读取节点或者提交时遇到的第一个错误
+func (t *PagedBTree) Err() error {
+	return t.err
+}
+
+//Len返回tree中item的个数，包括还没有提交的修改
+func (t *PagedBTree) Len() int {
+	return t.length
+}
+
+//把修改过的节点从下往上写到新分配的页中，返回节点所在的第一页
+func (t *PagedBTree) writeNode(n *pnode) (uint64, error) {
+	for i, c := range n.children {
+		//只有修改过的节点才会被直接引用
+		if c.n != nil {
+			id, err := t.writeNode(c.n)
+			if err != nil {
+				return 0, err
+			}
+			n.children[i] = pageRef{id: id}
+		}
+	}
+	data, err := t.encodeNode(n)
+	if err != nil {
+		return 0, err
+	}
+	pages, err := t.pager.allocBlob(data)
+	if err != nil {
+		return 0, err
+	}
+	n.id, n.pages, n.dirty = pages[0], pages, false
+	t.pool.put(n)
+	return n.id, nil
+}
+
+//Commit把所有还没有提交的修改写入文件
+//先把修改过的节点和空闲页列表写到上一次提交没有使用的页中并fsync，再写入另一份元数据并fsync，
+//元数据写完之前崩溃的话，打开时仍然是上一次提交的tree。
+//Commit失败之后PagedBTree不能再使用，只能关闭之后重新打开。
+func (t *PagedBTree) Commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.root.n == nil && uint64(t.length) == t.meta.length {
+		return nil
+	}
+	t.err = t.commit()
+	return t.err
+}
+
+func (t *PagedBTree) commit() error {
+	meta := t.meta
+	meta.txid++
+	meta.root = 0
+	if t.root.n != nil && (len(t.root.n.items) > 0 || len(t.root.n.children) > 0) {
+		id, err := t.writeNode(t.root.n)
+		if err != nil {
+			return err
+		}
+		meta.root = id
+	} else if t.root.n == nil {
+		meta.root = t.root.id
+	}
+	t.root = pageRef{id: meta.root}
+	freelist, blobPages, err := t.pager.writeFreelist()
+	if err != nil {
+		return err
+	}
+	meta.freelist = freelist
+	meta.length = uint64(t.length)
+	meta.pageCount = t.pager.pageCount
+	if err := t.pager.file.Sync(); err != nil {
+		return err
+	}
+	old := t.meta
+	t.meta = meta
+	if err := t.writeMeta(int(meta.txid % metaSlots)); err != nil {
+		t.meta = old
+		return err
+	}
+	if err := t.pager.file.Sync(); err != nil {
+		return err
+	}
+	//上一次提交引用的页现在都可以重用了
+	p := t.pager
+	p.free = append(append(p.free, p.pending...), p.blobPages...)
+	p.pending, p.blobPages = nil, blobPages
+	return nil
+}
+
+//Rollback丢弃上一次Commit之后的所有修改
+func (t *PagedBTree) Rollback() {
+	t.root = pageRef{id: t.meta.root}
+	t.length = int(t.meta.length)
+	t.pager.pending = nil
+}
+
+//Close丢弃没有提交的修改并关闭文件，返回之前读取节点或者提交时遇到的错误
+func (t *PagedBTree) Close() error {
+	t.Rollback()
+	t.pool = newBufferPool(1)
+	err := t.pager.file.Close()
+	if t.err != nil {
+		err = t.err
+	}
+	return err
+}
@@ -0,0 +1,266 @@
+package btree
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func openPaged(t *testing.T, path string, opts PagedOptions) *PagedBTree {
+	t.Helper()
+	tr, err := OpenPaged(path, IntCodec{}, opts)
+	if err != nil {
+		t.Fatalf("OpenPaged: %v", err)
+	}
+	return tr
+}
+
+func allPaged(t *PagedBTree) (out []Item) {
+	t.Ascend(func(a Item) bool {
+		out = append(out, a)
+		return true
+	})
+	return out
+}
+
+//和BTree查询方法相同的只读tree
+type readOnlyTree interface {
+	Len() int
+	Min() Item
+	Max() Item
+	Get(key Item) Item
+	Ascend(iterator ItemIterator)
+	AscendRange(greaterOrEqual, lessThan Item, iterator ItemIterator)
+	AscendLessThan(pivot Item, iterator ItemIterator)
+	AscendGreaterOrEqual(pivot Item, iterator ItemIterator)
+	Descend(iterator ItemIterator)
+	DescendRange(lessOrEqual, greaterThan Item, iterator ItemIterator)
+	DescendLessOrEqual(pivot Item, iterator ItemIterator)
+	DescendGreaterThan(pivot Item, iterator ItemIterator)
+}
+
+//逐个比较p和BTree的查询结果
+func checkPagedScans(t *testing.T, p readOnlyTree, want *BTree) {
+	t.Helper()
+	if p.Len() != want.Len() {
+		t.Fatalf("Len() = %d, want %d", p.Len(), want.Len())
+	}
+	scans := []struct {
+		name string
+		got  func(ItemIterator)
+		want func(ItemIterator)
+	}{
+		{"Ascend", p.Ascend, want.Ascend},
+		{"Descend", p.Descend, want.Descend},
+		{"AscendRange", func(f ItemIterator) { p.AscendRange(Int(100), Int(300), f) }, func(f ItemIterator) { want.AscendRange(Int(100), Int(300), f) }},
+		{"AscendLessThan", func(f ItemIterator) { p.AscendLessThan(Int(250), f) }, func(f ItemIterator) { want.AscendLessThan(Int(250), f) }},
+		{"AscendGreaterOrEqual", func(f ItemIterator) { p.AscendGreaterOrEqual(Int(250), f) }, func(f ItemIterator) { want.AscendGreaterOrEqual(Int(250), f) }},
+		{"DescendRange", func(f ItemIterator) { p.DescendRange(Int(300), Int(100), f) }, func(f ItemIterator) { want.DescendRange(Int(300), Int(100), f) }},
+		{"DescendLessOrEqual", func(f ItemIterator) { p.DescendLessOrEqual(Int(250), f) }, func(f ItemIterator) { want.DescendLessOrEqual(Int(250), f) }},
+		{"DescendGreaterThan", func(f ItemIterator) { p.DescendGreaterThan(Int(250), f) }, func(f ItemIterator) { want.DescendGreaterThan(Int(250), f) }},
+	}
+	for _, s := range scans {
+		var got, expect []Item
+		s.got(func(a Item) bool { got = append(got, a); return true })
+		s.want(func(a Item) bool { expect = append(expect, a); return true })
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("%s:\n got: %v\nwant: %v", s.name, got, expect)
+		}
+	}
+	if p.Min() != want.Min() || p.Max() != want.Max() {
+		t.Fatalf("Min/Max = %v/%v, want %v/%v", p.Min(), p.Max(), want.Min(), want.Max())
+	}
+}
+
+func TestPagedBTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	opts := PagedOptions{Degree: 3, PageSize: 1024, PoolSize: 8}
+	p := openPaged(t, path, opts)
+	want := New(3)
+	for round := 0; round < 20; round++ {
+		committed := want.Clone()
+		for i := 0; i < 300; i++ {
+			v := Int(rand.Intn(500))
+			switch rand.Intn(4) {
+			case 0, 1:
+				if got, w := p.ReplaceOrInsert(v), want.ReplaceOrInsert(v); got != w {
+					t.Fatalf("ReplaceOrInsert(%v) = %v, want %v", v, got, w)
+				}
+			case 2:
+				if got, w := p.Delete(v), want.Delete(v); got != w {
+					t.Fatalf("Delete(%v) = %v, want %v", v, got, w)
+				}
+			case 3:
+				if got, w := p.DeleteMin(), want.DeleteMin(); got != w {
+					t.Fatalf("DeleteMin() = %v, want %v", got, w)
+				}
+			}
+			if got, w := p.Get(v), want.Get(v); got != w {
+				t.Fatalf("Get(%v) = %v, want %v", v, got, w)
+			}
+		}
+		checkPagedScans(t, p, want)
+		switch round % 3 {
+		case 0:
+			//回滚到上一次提交
+			p.Rollback()
+			want = committed
+		case 1:
+			if err := p.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		case 2:
+			//提交之后重新打开
+			if err := p.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			p.Close()
+			p = openPaged(t, path, PagedOptions{PoolSize: 4})
+		}
+		checkPagedScans(t, p, want)
+	}
+	p.Close()
+}
+
+func TestPagedBTreeReusesPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	p := openPaged(t, path, PagedOptions{Degree: 4, PageSize: 1024})
+	defer p.Close()
+	var size int64
+	for round := 0; round < 30; round++ {
+		for i := 0; i < 200; i++ {
+			p.ReplaceOrInsert(Int(i))
+		}
+		if err := p.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 200; i++ {
+			p.Delete(Int(i))
+		}
+		if err := p.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(path)
+		if round == 5 {
+			size = info.Size()
+		} else if round > 5 && info.Size() > size {
+			t.Fatalf("file grew from %d to %d bytes in round %d", size, info.Size(), round)
+		}
+	}
+}
+
+func TestPagedBTreeTornMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	p := openPaged(t, path, PagedOptions{Degree: 2, PageSize: 1024})
+	for i := 0; i < 100; i++ {
+		p.ReplaceOrInsert(Int(i))
+	}
+	p.Commit()
+	for i := 100; i < 200; i++ {
+		p.ReplaceOrInsert(Int(i))
+	}
+	p.Commit()
+	txid := p.meta.txid
+	p.Close()
+	//模拟写最后一份元数据时断电
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte("garbage"), int64(txid%metaSlots)*metaSlotSize+20)
+	f.Close()
+	p = openPaged(t, path, PagedOptions{})
+	defer p.Close()
+	if got := allPaged(p); !reflect.DeepEqual(got, rang(100)) {
+		t.Fatalf("after torn meta: %v", got)
+	}
+	//在上一次提交的基础上继续修改和提交
+	p.ReplaceOrInsert(Int(1000))
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != 101 || p.Get(Int(1000)) == nil {
+		t.Fatalf("commit after recovery failed")
+	}
+}
+
+func TestPagedBTreeLargeItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	p, err := OpenPaged(path, StringCodec{}, PagedOptions{Degree: 3, PageSize: 1024, PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []Item
+	for i := 0; i < 50; i++ {
+		s := String(strings.Repeat(string(rune('a'+i%26)), 3000) + string(rune('0'+i/26)))
+		p.ReplaceOrInsert(s)
+		want = append(want, s)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	p, err = OpenPaged(path, StringCodec{}, PagedOptions{PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	got := allPaged(p)
+	tr := New(2)
+	for _, s := range want {
+		tr.ReplaceOrInsert(s)
+	}
+	if !reflect.DeepEqual(got, all(tr)) {
+		t.Fatalf("large items mismatch")
+	}
+}
+
+func TestPagedBTreeCorruptPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	opts := PagedOptions{Degree: 2, PageSize: 1024, PoolSize: 2}
+	p := openPaged(t, path, opts)
+	for i := 0; i < 500; i++ {
+		p.ReplaceOrInsert(Int(i))
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	root := p.node(p.root)
+	last := root.children[len(root.children)-1].id
+	p.Close()
+	//损坏保存最大的那些item的子树的根节点
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte("garbage"), int64(last)*1024+pageHeaderSize+blobHeaderSize)
+	f.Close()
+
+	p = openPaged(t, path, opts)
+	if got := p.Get(Int(499)); got != nil {
+		t.Fatalf("Get from corrupt page = %v", got)
+	}
+	if p.Err() != ErrBadPageFile {
+		t.Fatalf("Err() = %v", p.Err())
+	}
+	//出错之后查询和修改都不再生效
+	if got := p.Get(Int(0)); got != nil {
+		t.Fatalf("Get after error = %v", got)
+	}
+	p.ReplaceOrInsert(Int(1000))
+	p.Delete(Int(1))
+	if got := allPaged(p); got != nil {
+		t.Fatalf("Ascend after error = %v", got)
+	}
+	if err := p.Commit(); err != ErrBadPageFile {
+		t.Fatalf("Commit() = %v", err)
+	}
+	if err := p.Close(); err != ErrBadPageFile {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	//修改也会在读取损坏的页时停下来
+	p = openPaged(t, path, opts)
+	defer p.Close()
+	p.ReplaceOrInsert(Int(1000))
+	if p.Err() != ErrBadPageFile {
+		t.Fatalf("Err() after insert = %v", p.Err())
+	}
+}
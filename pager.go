@@ -0,0 +1,266 @@
+package btree
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+)
+
+const (
+	DefaultPageSize = 4096 //默认的页大小
+	DefaultPoolSize = 1024 //默认的缓冲池能缓存的节点数
+
+	minPageSize    = 1024
+	pageHeaderSize = 8   //每页开头是链表中下一页的页号，0表示没有下一页
+	blobHeaderSize = 8   //一串页中保存的数据开头是4字节的长度和4字节的CRC
+	metaPages      = 1   //第0页是元数据页
+	metaSlots      = 2   //元数据页中交替写入的两份元数据
+	metaSlotSize   = 512 //每份元数据占用一个扇区，写坏一份不会影响另一份
+)
+
+var (
+	//ErrBadPageFile表示页文件的元数据或者页的内容损坏
+	ErrBadPageFile = errors.New("btree: bad page file")
+
+	pageFileMagic = []byte("btpage1\n")
+)
+
+//页文件的元数据，提交时交替写入第0页中的两个位置，打开时使用CRC正确并且txid最大的一个
+type pageMeta struct {
+	pageSize  uint32
+	degree    uint32
+	txid      uint64
+	root      uint64 //root节点的页号，0表示空tree
+	length    uint64
+	pageCount uint64 //文件中已经分配的页数，包括元数据页
+	freelist  uint64 //保存空闲页列表的第一页，0表示没有空闲页
+}
+
+const pageMetaSize = 8 + 4 + 4 + 8*5 + 4
+
+func (m *pageMeta) encode() []byte {
+	buf := make([]byte, pageMetaSize)
+	copy(buf, pageFileMagic)
+	binary.BigEndian.PutUint32(buf[8:], m.pageSize)
+	binary.BigEndian.PutUint32(buf[12:], m.degree)
+	binary.BigEndian.PutUint64(buf[16:], m.txid)
+	binary.BigEndian.PutUint64(buf[24:], m.root)
+	binary.BigEndian.PutUint64(buf[32:], m.length)
+	binary.BigEndian.PutUint64(buf[40:], m.pageCount)
+	binary.BigEndian.PutUint64(buf[48:], m.freelist)
+	binary.BigEndian.PutUint32(buf[56:], crc32.Checksum(buf[:56], crcTable))
+	return buf
+}
+
+func decodePageMeta(buf []byte) (pageMeta, bool) {
+	var m pageMeta
+	if len(buf) < pageMetaSize || !bytes.HasPrefix(buf, pageFileMagic) ||
+		crc32.Checksum(buf[:56], crcTable) != binary.BigEndian.Uint32(buf[56:]) {
+		return m, false
+	}
+	m.pageSize = binary.BigEndian.Uint32(buf[8:])
+	m.degree = binary.BigEndian.Uint32(buf[12:])
+	m.txid = binary.BigEndian.Uint64(buf[16:])
+	m.root = binary.BigEndian.Uint64(buf[24:])
+	m.length = binary.BigEndian.Uint64(buf[32:])
+	m.pageCount = binary.BigEndian.Uint64(buf[40:])
+	m.freelist = binary.BigEndian.Uint64(buf[48:])
+	return m, true
+}
+
+//pager负责页文件的读写和页的分配
+//free是上一次提交时已经空闲的页，可以在这次事务中直接覆盖；
+//pending是这次事务中不再使用的页，上一次提交的tree仍然引用它们，要等这次提交完成之后才能重用。
+//这和copyOnWriteContext的思路一样：已经提交的页是只读的，修改时总是写到新的页上。
+type pager struct {
+	file      *os.File
+	pageSize  int
+	pageCount uint64
+	free      []uint64
+	pending   []uint64
+	blobPages []uint64 //上一次提交保存空闲页列表所用的页
+}
+
+//每页中可以保存数据的字节数
+func (p *pager) payload() int {
+	return p.pageSize - pageHeaderSize
+}
+
+//保存size字节的数据需要的页数
+func (p *pager) pagesFor(size int) int {
+	return (size + blobHeaderSize + p.payload() - 1) / p.payload()
+}
+
+//分配一个页：优先使用空闲页，否则在文件末尾增加一页
+func (p *pager) alloc() uint64 {
+	if n := len(p.free); n > 0 {
+		id := p.free[n-1]
+		p.free = p.free[:n-1]
+		return id
+	}
+	id := p.pageCount
+	p.pageCount++
+	return id
+}
+
+//将data写入ids这一串页中
+func (p *pager) writeBlob(ids []uint64, data []byte) error {
+	blob := make([]byte, blobHeaderSize, blobHeaderSize+len(data))
+	binary.BigEndian.PutUint32(blob, uint32(len(data)))
+	binary.BigEndian.PutUint32(blob[4:], crc32.Checksum(data, crcTable))
+	blob = append(blob, data...)
+	page := make([]byte, p.pageSize)
+	for i, id := range ids {
+		for j := range page {
+			page[j] = 0
+		}
+		if i+1 < len(ids) {
+			binary.BigEndian.PutUint64(page, ids[i+1])
+		}
+		n := copy(page[pageHeaderSize:], blob)
+		blob = blob[n:]
+		if _, err := p.file.WriteAt(page, int64(id)*int64(p.pageSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//分配足够的页并写入data，返回所用的页
+func (p *pager) allocBlob(data []byte) ([]uint64, error) {
+	ids := make([]uint64, p.pagesFor(len(data)))
+	for i := range ids {
+		ids[i] = p.alloc()
+	}
+	return ids, p.writeBlob(ids, data)
+}
+
+//读取从id开始的一串页中保存的数据，返回数据和所用的页
+func (p *pager) readBlob(id uint64) ([]byte, []uint64, error) {
+	var out []byte
+	var ids []uint64
+	page := make([]byte, p.pageSize)
+	size := -1
+	for id != 0 {
+		if id < metaPages || id >= p.pageCount || len(ids) > int(p.pageCount) {
+			return nil, nil, ErrBadPageFile
+		}
+		if _, err := p.file.ReadAt(page, int64(id)*int64(p.pageSize)); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		out = append(out, page[pageHeaderSize:]...)
+		if size < 0 {
+			size = int(binary.BigEndian.Uint32(out))
+		}
+		if len(out) >= blobHeaderSize+size {
+			break
+		}
+		id = binary.BigEndian.Uint64(page)
+	}
+	if size < 0 || len(out) < blobHeaderSize+size {
+		return nil, nil, ErrBadPageFile
+	}
+	data := out[blobHeaderSize : blobHeaderSize+size]
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(out[4:]) {
+		return nil, nil, ErrBadPageFile
+	}
+	return data, ids, nil
+}
+
+//空闲页列表的编码：uvarint的个数，然后是每个页号的uvarint
+func encodePageList(ids []uint64) []byte {
+	buf := make([]byte, 0, (len(ids)+1)*binary.MaxVarintLen32)
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(ids)))]...)
+	for _, id := range ids {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], id)]...)
+	}
+	return buf
+}
+
+func decodePageList(data []byte) ([]uint64, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, ErrBadPageFile
+	}
+	data = data[n:]
+	out := make([]uint64, 0, count)
+	for i := uint64(0); i < count; i++ {
+		id, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrBadPageFile
+		}
+		out = append(out, id)
+		data = data[n:]
+	}
+	return out, nil
+}
+
+//保存下一次提交之后的空闲页列表，返回列表的第一页
+//列表所用的页只能从free中分配，不能覆盖上一次提交仍然引用的pending和blobPages，
+//而分配出去的页又不再是空闲的，所以反复分配直到剩下的列表能放进已经分配的页中。
+func (p *pager) writeFreelist() (uint64, []uint64, error) {
+	var ids []uint64
+	for {
+		list := make([]uint64, 0, len(p.free)+len(p.pending)+len(p.blobPages))
+		list = append(append(append(list, p.free...), p.pending...), p.blobPages...)
+		if len(list) == 0 && len(ids) == 0 {
+			return 0, nil, nil
+		}
+		data := encodePageList(list)
+		if p.pagesFor(len(data)) <= len(ids) {
+			return ids[0], ids, p.writeBlob(ids, data)
+		}
+		ids = append(ids, p.alloc())
+	}
+}
+
+//缓冲池：按LRU缓存最多size个已经提交的节点
+//只有干净的节点会放进缓冲池，修改过还没有提交的节点由tree直接引用，不会被淘汰
+type bufferPool struct {
+	size  int
+	lru   *list.List //最近使用的在前面
+	pages map[uint64]*list.Element
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{size: size, lru: list.New(), pages: map[uint64]*list.Element{}}
+}
+
+func (b *bufferPool) get(id uint64) *pnode {
+	if e, ok := b.pages[id]; ok {
+		b.lru.MoveToFront(e)
+		return e.Value.(*pnode)
+	}
+	return nil
+}
+
+func (b *bufferPool) put(n *pnode) {
+	if e, ok := b.pages[n.id]; ok {
+		e.Value = n
+		b.lru.MoveToFront(e)
+		return
+	}
+	b.pages[n.id] = b.lru.PushFront(n)
+	for b.lru.Len() > b.size {
+		e := b.lru.Back()
+		b.lru.Remove(e)
+		delete(b.pages, e.Value.(*pnode).id)
+	}
+}
+
+func (b *bufferPool) remove(id uint64) {
+	if e, ok := b.pages[id]; ok {
+		b.lru.Remove(e)
+		delete(b.pages, id)
+	}
+}
+
+//缓冲池中的节点数
+func (b *bufferPool) len() int {
+	return b.lru.Len()
+}
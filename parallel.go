@@ -0,0 +1,156 @@
+package btree
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+//并行遍历的一个任务：一棵子树，或者上层节点中的一个item
+type scanTask struct {
+	n    *node
+	item Item
+}
+
+//从root开始逐层展开，把[ge, lt)范围切分成按升序排列的任务，直到子树任务不少于want个或者已经展开到叶子节点
+func partition(root *node, ge, lt Item, want int) []scanTask {
+	inRange := func(item Item) bool {
+		return (ge == nil || !item.Less(ge)) && (lt == nil || item.Less(lt))
+	}
+	tasks := []scanTask{{n: root}}
+	for {
+		subtrees, expandable := 0, false
+		for _, task := range tasks {
+			if task.n != nil {
+				subtrees++
+				expandable = expandable || len(task.n.children) > 0
+			}
+		}
+		if subtrees >= want || !expandable {
+			return tasks
+		}
+		next := make([]scanTask, 0, len(tasks)*2)
+		for _, task := range tasks {
+			n := task.n
+			if n == nil || len(n.children) == 0 {
+				next = append(next, task)
+				continue
+			}
+			for i, child := range n.children {
+				//跳过和[ge, lt)没有交集的子树
+				if (i == 0 || lt == nil || n.items[i-1].Less(lt)) &&
+					(i == len(n.items) || ge == nil || ge.Less(n.items[i])) {
+					next = append(next, scanTask{n: child})
+				}
+				if i < len(n.items) && inRange(n.items[i]) {
+					next = append(next, scanTask{item: n.items[i]})
+				}
+			}
+		}
+		tasks = next
+	}
+}
+
+//用workers个goroutine执行tasks，run返回false时不再开始新的任务
+func runTasks(tasks []scanTask, workers int, run func(i int, task scanTask) bool) {
+	var (
+		wg      sync.WaitGroup
+		next    int64 = -1
+		stopped int32
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stopped) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(tasks) {
+					return
+				}
+				if !run(i, tasks[i]) {
+					atomic.StoreInt32(&stopped, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+//每个worker分到的任务数，任务切得比worker多一些，可以让快的worker多做一些
+const tasksPerWorker = 4
+
+//ParallelAscend用workers个goroutine并行处理tree中的每一个item，workers<=0时使用GOMAXPROCS
+//树的上层会被切分成若干棵子树分给不同的goroutine，所以fn会被并发调用，调用的顺序也不是升序的。
+//fn返回false之后，所有goroutine都会尽快停止。
+//ParallelAscend会先Clone一份tree再遍历，遍历的是调用时的快照，fn中修改t不会影响这次遍历。
+//和Clone一样，ParallelAscend不能和t上的其他写操作并发调用。
+func (t *BTree) ParallelAscend(workers int, fn ItemIterator) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	snapshot := t.Clone()
+	if snapshot.root == nil {
+		return
+	}
+	var stopped int32
+	iter := func(i Item) bool {
+		if atomic.LoadInt32(&stopped) != 0 || !fn(i) {
+			atomic.StoreInt32(&stopped, 1)
+			return false
+		}
+		return true
+	}
+	tasks := partition(snapshot.root, nil, nil, workers*tasksPerWorker)
+	runTasks(tasks, workers, func(_ int, task scanTask) bool {
+		if task.n != nil {
+			task.n.iterate(ascend, nil, nil, false, false, iter)
+		} else {
+			iter(task.item)
+		}
+		return atomic.LoadInt32(&stopped) == 0
+	})
+}
+
+//ParallelReduce并行计算[greaterOrEqual, lessThan)范围内所有item经过mapFn之后，再用reduceFn合并的结果，
+//greaterOrEqual或lessThan为nil时表示这一边没有边界，范围内没有item时返回nil。
+//每个goroutine按升序合并自己的子树，最后再按key的顺序合并各个子树的结果，
+//所以reduceFn只需要满足结合律，不需要满足交换律。mapFn和reduceFn会被并发调用。
+//和ParallelAscend一样，ParallelReduce遍历的是调用时Clone出来的快照。
+func (t *BTree) ParallelReduce(greaterOrEqual, lessThan Item, mapFn func(Item) interface{}, reduceFn func(a, b interface{}) interface{}) interface{} {
+	snapshot := t.Clone()
+	if snapshot.root == nil {
+		return nil
+	}
+	workers := runtime.GOMAXPROCS(0)
+	tasks := partition(snapshot.root, greaterOrEqual, lessThan, workers*tasksPerWorker)
+	results := make([]interface{}, len(tasks))
+	found := make([]bool, len(tasks))
+	runTasks(tasks, workers, func(i int, task scanTask) bool {
+		if task.n == nil {
+			results[i], found[i] = mapFn(task.item), true
+			return true
+		}
+		task.n.iterate(ascend, greaterOrEqual, lessThan, true, false, func(item Item) bool {
+			v := mapFn(item)
+			if found[i] {
+				v = reduceFn(results[i], v)
+			}
+			results[i], found[i] = v, true
+			return true
+		})
+		return true
+	})
+	var out interface{}
+	first := true
+	for i, v := range results {
+		if !found[i] {
+			continue
+		}
+		if first {
+			out, first = v, false
+		} else {
+			out = reduceFn(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package btree
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelAscend(t *testing.T) {
+	for _, degree := range []int{2, 3, *btreeDegree} {
+		tr := New(degree)
+		for _, v := range perm(10000) {
+			tr.ReplaceOrInsert(v)
+		}
+		var mu sync.Mutex
+		var got []Item
+		tr.ParallelAscend(4, func(a Item) bool {
+			mu.Lock()
+			got = append(got, a)
+			mu.Unlock()
+			return true
+		})
+		sort.Sort(byInts(got))
+		if want := rang(10000); !reflect.DeepEqual(got, want) {
+			t.Fatalf("degree %d: got %d items, want %d", degree, len(got), len(want))
+		}
+	}
+}
+
+func TestParallelAscendIsolatedAndStops(t *testing.T) {
+	tr := New(3)
+	for _, v := range perm(10000) {
+		tr.ReplaceOrInsert(v)
+	}
+	//在fn中删除tree中的item不能影响遍历
+	var mu sync.Mutex
+	var n int64
+	tr.ParallelAscend(4, func(a Item) bool {
+		mu.Lock()
+		tr.Delete(a)
+		mu.Unlock()
+		atomic.AddInt64(&n, 1)
+		return true
+	})
+	if n != 10000 || tr.Len() != 0 {
+		t.Fatalf("saw %d items, %d left in tree", n, tr.Len())
+	}
+
+	for _, v := range perm(10000) {
+		tr.ReplaceOrInsert(v)
+	}
+	n = 0
+	tr.ParallelAscend(4, func(a Item) bool {
+		return atomic.AddInt64(&n, 1) < 100
+	})
+	//其他worker停止之后，每个worker可能还会处理完手上的item
+	if n < 100 || n > 104 {
+		t.Fatalf("saw %d items after stopping at 100", n)
+	}
+}
+
+func TestParallelReduce(t *testing.T) {
+	tr := New(3)
+	for _, v := range perm(10000) {
+		tr.ReplaceOrInsert(v)
+	}
+	sum := tr.ParallelReduce(nil, nil,
+		func(a Item) interface{} { return int(a.(Int)) },
+		func(a, b interface{}) interface{} { return a.(int) + b.(int) })
+	if want := 10000 * 9999 / 2; sum != want {
+		t.Fatalf("sum: want %d, got %v", want, sum)
+	}
+	//字符串连接满足结合律但不满足交换律，可以检查部分结果是否按key的顺序合并
+	got := tr.ParallelReduce(Int(1234), Int(8765),
+		func(a Item) interface{} { return []Item{a} },
+		func(a, b interface{}) interface{} {
+			x := a.([]Item)
+			return append(x[:len(x):len(x)], b.([]Item)...)
+		})
+	if want := rang(10000)[1234:8765]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("concat: got %v", got)
+	}
+	if got := tr.ParallelReduce(Int(20000), nil,
+		func(a Item) interface{} { return a },
+		func(a, b interface{}) interface{} { return a }); got != nil {
+		t.Fatalf("empty range: got %v", got)
+	}
+}
+
+func BenchmarkParallelAscend(b *testing.B) {
+	tr := New(*btreeDegree)
+	for _, v := range perm(benchmarkTreeSize * 10) {
+		tr.ReplaceOrInsert(v)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.ParallelAscend(0, func(Item) bool { return true })
+	}
+}
@@ -0,0 +1,120 @@
+package btree
+
+//PriorityQueue是基于BTree的双端优先队列，Less小的item优先级高
+//和container/heap不同，两端都可以在O(log n)内取出，并且可以直接修改队列中任意一个item的优先级。
+//相等的item可以同时存在：PopMin按照Push的顺序取出相等的item，PopMax则先取出最后Push的那个。
+type PriorityQueue struct {
+	tree *BTree
+	seq  uint64 //Push的次数，用来区分相等的item
+}
+
+//队列中的一个item，相等的item按照Push的顺序排序
+type pqEntry struct {
+	item Item
+	seq  uint64
+}
+
+func (a pqEntry) Less(b Item) bool {
+	bb := b.(pqEntry)
+	if a.item.Less(bb.item) {
+		return true
+	}
+	if bb.item.Less(a.item) {
+		return false
+	}
+	return a.seq < bb.seq
+}
+
+//NewPriorityQueue创建一个使用给定degree的空队列
+func NewPriorityQueue(degree int) *PriorityQueue {
+	return &PriorityQueue{tree: New(degree)}
+}
+
+//Push把item加入队列，不能为nil
+func (q *PriorityQueue) Push(item Item) {
+	if item == nil {
+		panic("nil item being added to PriorityQueue")
+	}
+	q.seq++
+	q.tree.ReplaceOrInsert(pqEntry{item: item, seq: q.seq})
+}
+
+//PopMin取出优先级最高（最小）的item，队列为空时返回nil
+func (q *PriorityQueue) PopMin() Item {
+	return unwrapPQ(q.tree.deleteItem(nil, removeMin))
+}
+
+//PopMax取出优先级最低（最大）的item，队列为空时返回nil
+func (q *PriorityQueue) PopMax() Item {
+	return unwrapPQ(q.tree.deleteItem(nil, removeMax))
+}
+
+//PeekMin返回优先级最高的item但不取出，队列为空时返回nil
+func (q *PriorityQueue) PeekMin() Item {
+	return unwrapPQ(q.tree.Min())
+}
+
+//PeekMax返回优先级最低的item但不取出，队列为空时返回nil
+func (q *PriorityQueue) PeekMax() Item {
+	return unwrapPQ(q.tree.Max())
+}
+
+func unwrapPQ(i Item) Item {
+	if i == nil {
+		return nil
+	}
+	return i.(pqEntry).item
+}
+
+//Update把队列中一个和old相等的item（最早Push的那个）替换成new，相当于删除old之后Push(new)
+//队列中没有和old相等的item时返回false
+func (q *PriorityQueue) Update(old, new Item) bool {
+	if new == nil {
+		panic("nil item being added to PriorityQueue")
+	}
+	var found Item
+	q.tree.AscendGreaterOrEqual(pqEntry{item: old}, func(i Item) bool {
+		if !old.Less(i.(pqEntry).item) {
+			found = i
+		}
+		return false
+	})
+	if found == nil {
+		return false
+	}
+	q.tree.Delete(found)
+	q.Push(new)
+	return true
+}
+
+//PopMinN按优先级从高到低取出最多k个item
+//先找到前k个，再用一次DeleteFunc删除它们，而不是调用k次PopMin。
+func (q *PriorityQueue) PopMinN(k int) []Item {
+	if k <= 0 || q.tree.Len() == 0 {
+		return nil
+	}
+	if k > q.tree.Len() {
+		k = q.tree.Len()
+	}
+	out := make([]Item, 0, k)
+	var stop Item
+	q.tree.Ascend(func(i Item) bool {
+		if len(out) == k {
+			stop = i
+			return false
+		}
+		out = append(out, i.(pqEntry).item)
+		return true
+	})
+	if stop == nil {
+		q.tree.Clear(true)
+	} else {
+		q.tree.DeleteFunc(nil, stop, func(Item) bool { return true })
+	}
+	return out
+}
+
+//Len返回队列中item的个数
+func (q *PriorityQueue) Len() int {
+	return q.tree.Len()
+}
@@ -0,0 +1,113 @@
+package btree
+
+import (
+	"container/heap"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue(3)
+	if q.PopMin() != nil || q.PeekMax() != nil {
+		t.Fatalf("empty queue returned an item")
+	}
+	var want []int
+	for i := 0; i < 200; i++ {
+		v := rand.Intn(50)
+		q.Push(Int(v))
+		want = append(want, v)
+	}
+	sort.Ints(want)
+	if q.PeekMin() != Int(want[0]) || q.PeekMax() != Int(want[len(want)-1]) {
+		t.Fatalf("peek %v %v", q.PeekMin(), q.PeekMax())
+	}
+	if !q.Update(Int(want[0]), Int(1000)) || q.Update(Int(-1), Int(0)) {
+		t.Fatalf("update")
+	}
+	want = append(want[1:], 1000)
+	if q.PopMax() != Int(1000) {
+		t.Fatalf("pop max after update")
+	}
+	want = want[:len(want)-1]
+	got := q.PopMinN(10)
+	for i, v := range got {
+		if v != Int(want[i]) {
+			t.Fatalf("PopMinN %v, want %v", got, want[:10])
+		}
+	}
+	want = want[10:]
+	for len(want) > 0 {
+		if v := q.PopMin(); v != Int(want[0]) {
+			t.Fatalf("pop min %v, want %d", v, want[0])
+		}
+		want = want[1:]
+		if len(want) > 0 {
+			if v := q.PopMax(); v != Int(want[len(want)-1]) {
+				t.Fatalf("pop max %v, want %d", v, want[len(want)-1])
+			}
+			want = want[:len(want)-1]
+		}
+	}
+	if q.Len() != 0 || q.PopMinN(3) != nil {
+		t.Fatalf("queue not empty")
+	}
+}
+
+func TestPriorityQueueStable(t *testing.T) {
+	q := NewPriorityQueue(2)
+	for i := 0; i < 20; i++ {
+		q.Push(kv{i % 2, string(rune('a' + i))})
+	}
+	var got []string
+	for q.Len() > 10 {
+		got = append(got, q.PopMin().(kv).v)
+	}
+	if want := []string{"a", "c", "e", "g", "i", "k", "m", "o", "q", "s"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("equal items popped as %v, want %v", got, want)
+	}
+	if all := q.PopMinN(100); len(all) != 10 || all[0].(kv).v != "b" {
+		t.Fatalf("PopMinN returned %v", all)
+	}
+}
+
+//intHeap是用来对比的container/heap实现
+type intHeap []int
+
+func (h intHeap) Len() int            { return len(h) }
+func (h intHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *intHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func BenchmarkPriorityQueuePushPop(b *testing.B) {
+	values := rand.Perm(benchmarkTreeSize)
+	q := NewPriorityQueue(*btreeDegree)
+	for _, v := range values {
+		q.Push(Int(v))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Push(Int(values[i%len(values)]))
+		q.PopMin()
+	}
+}
+
+func BenchmarkContainerHeapPushPop(b *testing.B) {
+	values := rand.Perm(benchmarkTreeSize)
+	h := &intHeap{}
+	for _, v := range values {
+		heap.Push(h, v)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		heap.Push(h, values[i%len(values)])
+		heap.Pop(h)
+	}
+}
@@ -0,0 +1,438 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	//ErrBadProof表示证明和root hash不符，或者证明不了要证明的结论
+	ErrBadProof = errors.New("btree: bad proof")
+	//ErrBadProofEncoding表示无法解码的证明
+	ErrBadProofEncoding = errors.New("btree: bad proof encoding")
+)
+
+//Proof是ProveInclusion、ProveAbsence或ProveRange生成的证明，可以用Encode序列化之后交给没有tree的一方验证
+//证明是tree的一部分：从root开始只展开需要的节点，其余的子节点和item只给出hash，
+//验证时用它重新计算root hash，再检查展开的部分能否得出要证明的结论。
+//证明的大小是O(degree*log n)，范围证明再加上范围内的item。
+type Proof struct {
+	root *proofNode //空tree的证明为nil
+}
+
+//证明中的一个节点，items和children中为nil的位置只给出hash
+type proofNode struct {
+	items       []Item
+	hashes      []Hash
+	children    []*proofNode
+	childHashes []Hash
+}
+
+//用reveal和expand决定揭示哪些item、展开哪些子节点，生成以n为根的子树的证明
+func (n *node) prove(h *itemHasher, reveal, expand func(n *node, i int) bool) *proofNode {
+	d := n.digestFor(h)
+	p := &proofNode{items: make([]Item, len(n.items)), hashes: make([]Hash, len(n.items))}
+	for i, item := range n.items {
+		if reveal(n, i) {
+			p.items[i] = item
+		} else {
+			p.hashes[i] = d.items[i]
+		}
+	}
+	if len(n.children) > 0 {
+		p.children = make([]*proofNode, len(n.children))
+		p.childHashes = make([]Hash, len(n.children))
+		for i, child := range n.children {
+			if expand(n, i) {
+				p.children[i] = child.prove(h, reveal, expand)
+			} else {
+				p.childHashes[i] = child.digestFor(h).hash
+			}
+		}
+	}
+	return p
+}
+
+//ProveInclusion证明tree中有和item相等的item，返回的证明揭示的是tree中的那个item
+//tree中没有和item相等的item时返回false。没有设置ItemHasher时panic。
+func (t *BTree) ProveInclusion(item Item) (*Proof, bool) {
+	h := t.hasher()
+	if !t.Has(item) {
+		return nil, false
+	}
+	reveal := func(n *node, i int) bool {
+		j, found := n.find(item)
+		return found && i == j
+	}
+	expand := func(n *node, i int) bool {
+		j, found := n.find(item)
+		return !found && i == j
+	}
+	return &Proof{root: t.root.prove(h, reveal, expand)}, true
+}
+
+//ProveAbsence证明tree中没有和key相等的item：揭示查找key经过的每个节点中夹住key的两个item
+//tree中有和key相等的item时返回false。没有设置ItemHasher时panic。
+func (t *BTree) ProveAbsence(key Item) (*Proof, bool) {
+	h := t.hasher()
+	if t.Has(key) {
+		return nil, false
+	}
+	if t.root == nil || t.length == 0 {
+		return &Proof{}, true
+	}
+	reveal := func(n *node, i int) bool {
+		j, _ := n.find(key)
+		return i == j-1 || i == j
+	}
+	expand := func(n *node, i int) bool {
+		j, _ := n.find(key)
+		return i == j
+	}
+	return &Proof{root: t.root.prove(h, reveal, expand)}, true
+}
+
+//ProveRange证明[greaterOrEqual, lessThan)范围内的item正好是AscendRange返回的那些，nil表示不限制这一端
+//展开和范围相交的所有节点并揭示其中所有的item，不相交的子节点只给出hash。没有设置ItemHasher时panic。
+func (t *BTree) ProveRange(greaterOrEqual, lessThan Item) *Proof {
+	h := t.hasher()
+	if t.root == nil || t.length == 0 {
+		return &Proof{}
+	}
+	reveal := func(n *node, i int) bool {
+		return true
+	}
+	expand := func(n *node, i int) bool {
+		return !childOutside(n.items, i, greaterOrEqual, lessThan)
+	}
+	return &Proof{root: t.root.prove(h, reveal, expand)}
+}
+
+//第i个子节点中的item是否一定都在[ge, lt)之外，items是父节点中的item，没有揭示的为nil
+func childOutside(items []Item, i int, ge, lt Item) bool {
+	if i > 0 && lt != nil && items[i-1] != nil && !items[i-1].Less(lt) {
+		return true
+	}
+	if i < len(items) && ge != nil && items[i] != nil && !ge.Less(items[i]) {
+		return true
+	}
+	return false
+}
+
+//重新计算证明中节点的hash
+func (p *proofNode) hash(h ItemHasher) (Hash, error) {
+	if len(p.hashes) != len(p.items) ||
+		(len(p.children) != 0 && len(p.children) != len(p.items)+1) || len(p.childHashes) != len(p.children) {
+		return Hash{}, ErrBadProof
+	}
+	itemHashes := make([]Hash, len(p.items))
+	for i, item := range p.items {
+		if item != nil {
+			itemHashes[i] = h(item)
+		} else {
+			itemHashes[i] = p.hashes[i]
+		}
+	}
+	var childHashes []Hash
+	if len(p.children) > 0 {
+		childHashes = make([]Hash, len(p.children))
+		for i, child := range p.children {
+			if child == nil {
+				childHashes[i] = p.childHashes[i]
+				continue
+			}
+			ch, err := child.hash(h)
+			if err != nil {
+				return Hash{}, err
+			}
+			childHashes[i] = ch
+		}
+	}
+	return nodeHash(itemHashes, childHashes), nil
+}
+
+//检查证明的root hash是否等于root
+func (p *Proof) check(root Hash, h ItemHasher) error {
+	if p == nil {
+		return ErrBadProof
+	}
+	if p.root == nil {
+		if root != (Hash{}) {
+			return ErrBadProof
+		}
+		return nil
+	}
+	got, err := p.root.hash(h)
+	if err != nil {
+		return err
+	}
+	if got != root {
+		return ErrBadProof
+	}
+	return nil
+}
+
+//VerifyInclusion检查proof能否证明root hash为root的tree中有和item相等并且hash相同的item
+func VerifyInclusion(root Hash, item Item, proof *Proof, h ItemHasher) error {
+	if err := proof.check(root, h); err != nil {
+		return err
+	}
+	want := h(item)
+	var walk func(p *proofNode) bool
+	walk = func(p *proofNode) bool {
+		for _, v := range p.items {
+			if v != nil && !v.Less(item) && !item.Less(v) && h(v) == want {
+				return true
+			}
+		}
+		for _, child := range p.children {
+			if child != nil && walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	if proof.root == nil || !walk(proof.root) {
+		return ErrBadProof
+	}
+	return nil
+}
+
+//VerifyAbsence检查proof能否证明root hash为root的tree中没有和key相等的item
+//从root开始，每个节点中都必须揭示夹住key的两个item（在边上时只需要一个），叶子节点中夹住key就说明key不存在。
+func VerifyAbsence(root Hash, key Item, proof *Proof, h ItemHasher) error {
+	if err := proof.check(root, h); err != nil {
+		return err
+	}
+	for p := proof.root; p != nil; {
+		i, ok := bracket(p.items, key)
+		if !ok {
+			return ErrBadProof
+		}
+		if len(p.children) == 0 {
+			return nil
+		}
+		if p = p.children[i]; p == nil {
+			return ErrBadProof
+		}
+	}
+	return nil
+}
+
+//找到key在揭示的item之间的位置i：items[i-1] < key < items[i]，两边的item都必须揭示
+func bracket(items []Item, key Item) (int, bool) {
+	for i := 0; i <= len(items); i++ {
+		if i > 0 && (items[i-1] == nil || !items[i-1].Less(key)) {
+			continue
+		}
+		if i < len(items) && (items[i] == nil || !key.Less(items[i])) {
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+//VerifyRange检查proof能否证明root hash为root的tree在[greaterOrEqual, lessThan)范围内的item正好是items
+//items必须按升序排列，内容要和tree中的item一样（hash相同）。
+//展开的节点必须揭示所有的item，没有展开的子节点必须能由父节点中的item判断出在范围之外。
+func VerifyRange(root Hash, greaterOrEqual, lessThan Item, items []Item, proof *Proof, h ItemHasher) error {
+	if err := proof.check(root, h); err != nil {
+		return err
+	}
+	var got []Item
+	var walk func(p *proofNode) bool
+	walk = func(p *proofNode) bool {
+		for i := 0; i <= len(p.items); i++ {
+			if len(p.children) > 0 {
+				if child := p.children[i]; child != nil {
+					if !walk(child) {
+						return false
+					}
+				} else if !childOutside(p.items, i, greaterOrEqual, lessThan) {
+					return false
+				}
+			}
+			if i == len(p.items) {
+				break
+			}
+			item := p.items[i]
+			if item == nil {
+				return false
+			}
+			if (greaterOrEqual == nil || !item.Less(greaterOrEqual)) && (lessThan == nil || item.Less(lessThan)) {
+				got = append(got, item)
+			}
+		}
+		return true
+	}
+	if proof.root != nil && !walk(proof.root) {
+		return ErrBadProof
+	}
+	if len(got) != len(items) {
+		return ErrBadProof
+	}
+	for i, item := range items {
+		if got[i].Less(item) || item.Less(got[i]) || h(got[i]) != h(item) {
+			return ErrBadProof
+		}
+	}
+	return nil
+}
+
+//证明编码中每个位置的标记
+const (
+	proofHashOnly byte = 0 //后面是32字节的hash
+	proofRevealed byte = 1 //后面是item或者子节点
+)
+
+//解码证明时节点的最大深度，防止错误的数据导致过深的递归
+const maxProofDepth = 64
+
+//Encode将证明编码成字节，c用来编码揭示的item
+func (p *Proof) Encode(c Codec) ([]byte, error) {
+	if p.root == nil {
+		return []byte{0}, nil
+	}
+	return p.root.encode([]byte{1}, c)
+}
+
+func (p *proofNode) encode(buf []byte, c Codec) ([]byte, error) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(p.items)))]...)
+	for i, item := range p.items {
+		if item == nil {
+			buf = append(buf, proofHashOnly)
+			buf = append(buf, p.hashes[i][:]...)
+			continue
+		}
+		data, err := c.EncodeItem(item)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, proofRevealed)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)
+		buf = append(buf, data...)
+	}
+	if len(p.children) == 0 {
+		return append(buf, 0), nil
+	}
+	buf = append(buf, 1)
+	for i, child := range p.children {
+		if child == nil {
+			buf = append(buf, proofHashOnly)
+			buf = append(buf, p.childHashes[i][:]...)
+			continue
+		}
+		buf = append(buf, proofRevealed)
+		var err error
+		if buf, err = child.encode(buf, c); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+//DecodeProof解码Encode的结果
+func DecodeProof(data []byte, c Codec) (*Proof, error) {
+	if len(data) == 0 {
+		return nil, ErrBadProofEncoding
+	}
+	switch data[0] {
+	case 0:
+		if len(data) != 1 {
+			return nil, ErrBadProofEncoding
+		}
+		return &Proof{}, nil
+	case 1:
+		root, rest, err := decodeProofNode(data[1:], c, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, ErrBadProofEncoding
+		}
+		return &Proof{root: root}, nil
+	}
+	return nil, ErrBadProofEncoding
+}
+
+//解码一个节点，返回节点和剩下的数据
+func decodeProofNode(data []byte, c Codec, depth int) (*proofNode, []byte, error) {
+	if depth > maxProofDepth {
+		return nil, nil, ErrBadProofEncoding
+	}
+	count, n := binary.Uvarint(data)
+	//每个item至少占一个字节
+	if n <= 0 || count > uint64(len(data)-n) {
+		return nil, nil, ErrBadProofEncoding
+	}
+	data = data[n:]
+	p := &proofNode{items: make([]Item, count), hashes: make([]Hash, count)}
+	for i := range p.items {
+		if len(data) == 0 {
+			return nil, nil, ErrBadProofEncoding
+		}
+		tag := data[0]
+		data = data[1:]
+		switch tag {
+		case proofHashOnly:
+			if len(data) < len(Hash{}) {
+				return nil, nil, ErrBadProofEncoding
+			}
+			copy(p.hashes[i][:], data)
+			data = data[len(Hash{}):]
+		case proofRevealed:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, nil, ErrBadProofEncoding
+			}
+			item, err := c.DecodeItem(data[n : n+int(size)])
+			if err != nil {
+				return nil, nil, ErrBadProofEncoding
+			}
+			p.items[i] = item
+			data = data[n+int(size):]
+		default:
+			return nil, nil, ErrBadProofEncoding
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil, ErrBadProofEncoding
+	}
+	hasChildren := data[0]
+	data = data[1:]
+	switch hasChildren {
+	case 0:
+		return p, data, nil
+	case 1:
+	default:
+		return nil, nil, ErrBadProofEncoding
+	}
+	p.children = make([]*proofNode, count+1)
+	p.childHashes = make([]Hash, count+1)
+	for i := range p.children {
+		if len(data) == 0 {
+			return nil, nil, ErrBadProofEncoding
+		}
+		tag := data[0]
+		data = data[1:]
+		switch tag {
+		case proofHashOnly:
+			if len(data) < len(Hash{}) {
+				return nil, nil, ErrBadProofEncoding
+			}
+			copy(p.childHashes[i][:], data)
+			data = data[len(Hash{}):]
+		case proofRevealed:
+			child, rest, err := decodeProofNode(data, c, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.children[i], data = child, rest
+		default:
+			return nil, nil, ErrBadProofEncoding
+		}
+	}
+	return p, data, nil
+}
@@ -0,0 +1,105 @@
+package btree
+
+import (
+	"testing"
+)
+
+//roundTrip像发送给验证方一样编码再解码p
+func roundTrip(t *testing.T, p *Proof) *Proof {
+	t.Helper()
+	data, err := p.Encode(IntCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeProof(data, IntCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestProveInclusionAndAbsence(t *testing.T) {
+	h := HasherFromCodec(IntCodec{})
+	tr := New(3)
+	tr.SetItemHasher(h)
+	for i := 0; i < 200; i += 2 {
+		tr.ReplaceOrInsert(Int(i))
+	}
+	root := tr.RootHash()
+	for i := -1; i <= 200; i++ {
+		if i%2 == 0 && i < 200 {
+			p, ok := tr.ProveInclusion(Int(i))
+			if !ok {
+				t.Fatalf("no inclusion proof for %d", i)
+			}
+			if err := VerifyInclusion(root, Int(i), roundTrip(t, p), h); err != nil {
+				t.Fatalf("inclusion of %d: %v", i, err)
+			}
+			if err := VerifyInclusion(root, Int(i+1), p, h); err != ErrBadProof {
+				t.Fatalf("inclusion proof of %d also proved %d: %v", i, i+1, err)
+			}
+			if _, ok := tr.ProveAbsence(Int(i)); ok {
+				t.Fatalf("absence proof for present %d", i)
+			}
+			continue
+		}
+		p, ok := tr.ProveAbsence(Int(i))
+		if !ok {
+			t.Fatalf("no absence proof for %d", i)
+		}
+		if err := VerifyAbsence(root, Int(i), roundTrip(t, p), h); err != nil {
+			t.Fatalf("absence of %d: %v", i, err)
+		}
+		if tr.Has(Int(i - 1)) {
+			if err := VerifyAbsence(root, Int(i-1), p, h); err != ErrBadProof {
+				t.Fatalf("absence proof of %d also proved absence of present %d: %v", i, i-1, err)
+			}
+		}
+	}
+	p, _ := tr.ProveInclusion(Int(10))
+	tr.ReplaceOrInsert(Int(11))
+	if err := VerifyInclusion(tr.RootHash(), Int(10), p, h); err != ErrBadProof {
+		t.Fatalf("stale proof verified against new root: %v", err)
+	}
+
+	empty := New(3)
+	empty.SetItemHasher(h)
+	p, ok := empty.ProveAbsence(Int(1))
+	if !ok {
+		t.Fatalf("no absence proof in empty tree")
+	}
+	if err := VerifyAbsence(empty.RootHash(), Int(1), roundTrip(t, p), h); err != nil {
+		t.Fatalf("absence in empty tree: %v", err)
+	}
+}
+
+func TestProveRange(t *testing.T) {
+	h := HasherFromCodec(IntCodec{})
+	tr := New(3)
+	tr.SetItemHasher(h)
+	for _, v := range perm(300) {
+		tr.ReplaceOrInsert(v)
+	}
+	root := tr.RootHash()
+	for _, r := range [][2]Item{{nil, nil}, {Int(10), Int(20)}, {nil, Int(7)}, {Int(295), nil}, {Int(50), Int(50)}, {Int(-10), Int(0)}} {
+		var got []Item
+		tr.AscendRange(r[0], r[1], func(i Item) bool {
+			got = append(got, i)
+			return true
+		})
+		p := roundTrip(t, tr.ProveRange(r[0], r[1]))
+		if err := VerifyRange(root, r[0], r[1], got, p, h); err != nil {
+			t.Fatalf("range %v: %v", r, err)
+		}
+		if len(got) > 0 {
+			if err := VerifyRange(root, r[0], r[1], got[1:], p, h); err != ErrBadProof {
+				t.Fatalf("range %v: proof accepted a scan missing an item: %v", r, err)
+			}
+		}
+	}
+	//较窄范围的证明不能证明更宽的范围
+	p := tr.ProveRange(Int(100), Int(110))
+	if err := VerifyRange(root, Int(100), Int(150), rang(150)[100:], p, h); err != ErrBadProof {
+		t.Fatalf("narrow proof accepted for wider range: %v", err)
+	}
+}
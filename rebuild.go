@@ -0,0 +1,220 @@
+package btree
+
+import (
+	"runtime"
+	"time"
+)
+
+//Rebuild用newDegree重建一棵包含t中所有item的新tree并返回它，t本身不会被修改
+//新tree是按升序遍历t得到的有序item自底向上批量构建的，每个item只需要放置一次而不需要比较，
+//比逐个ReplaceOrInsert快得多，并且除了root之外的节点都在[minItems, maxItems]之间。
+//新tree沿用t的freelist、查找策略和item hash函数。
+//Rebuild只读取t，可以先Clone一份，在另一个goroutine中对克隆调用Rebuild，
+//原来的tree在此期间可以继续写入，完成之后再把这段时间的修改补到新tree上。
+func (t *BTree) Rebuild(newDegree int) *BTree {
+	out := NewWithFreeList(newDegree, t.cow.freelist)
+	out.cow.search, out.cow.linearMax, out.cow.hasher = t.cow.search, t.cow.linearMax, t.cow.hasher
+	if t.root == nil || t.length == 0 {
+		return out
+	}
+	sorted := make(items, 0, t.length)
+	t.Ascend(func(i Item) bool {
+		sorted = append(sorted, i)
+		return true
+	})
+	out.root = out.buildSorted(sorted, true)
+	out.length = len(sorted)
+	return out
+}
+
+//高度为height的子树最多能容纳的item数：(maxItems+1)^height - 1
+func (t *BTree) capacity(height int) int {
+	c := 1
+	for i := 0; i < height; i++ {
+		c *= t.maxItems() + 1
+		if c > 1<<40 {
+			break
+		}
+	}
+	return c - 1
+}
+
+//用有序的sorted构建一棵子树
+//先选出能容纳所有item的最小高度，再把item尽量平均地分给子节点：
+//子节点的个数是放下所有item需要的最少个数（至少degree个，root至少2个），
+//这样每个子节点的item数都在它所在高度允许的范围内，并且所有叶子节点都在同一层。
+func (t *BTree) buildSorted(sorted items, isRoot bool) *node {
+	height := 1
+	for t.capacity(height) < len(sorted) {
+		height++
+	}
+	return t.buildLevel(sorted, height, isRoot)
+}
+
+func (t *BTree) buildLevel(sorted items, height int, isRoot bool) *node {
+	n := t.cow.newNode()
+	n.size = len(sorted)
+	if height == 1 {
+		n.items = append(n.items[:0], sorted...)
+		return n
+	}
+	childCap := t.capacity(height - 1)
+	k := (len(sorted) + 1 + childCap) / (childCap + 1) //ceil((len+1) / (childCap+1))
+	if isRoot && k < 2 {
+		k = 2
+	} else if !isRoot && k < t.degree {
+		k = t.degree
+	}
+	//len+1个"槽位"平均分给k个子节点，每个子节点占用的槽位数减1就是它的item数，子节点之间的槽位放分隔item
+	slots := len(sorted) + 1
+	start := 0
+	for i := 0; i < k; i++ {
+		end := slots*(i+1)/k - 1
+		n.children = append(n.children, t.buildLevel(sorted[start:end], height-1, false))
+		if i < k-1 {
+			n.items = append(n.items, sorted[end])
+		}
+		start = end + 1
+	}
+	return n
+}
+
+//候选的degree
+var tuneDegrees = []int{2, 4, 8, 16, 32, 64, 128}
+
+//一次内存分配的代价相当于多少次Less调用，用来把比较次数和分配次数合成一个代价
+const tuneAllocCompares = 8
+
+//TuneDegree中一次候选degree的测量结果
+type DegreeResult struct {
+	Degree   int           //候选的degree
+	Compares int           //插入并查找一遍sample所需的Less调用次数
+	Allocs   uint64        //插入一遍sample的内存分配次数，取多次测量中最少的一次
+	Bytes    uint64        //插入一遍sample分配的字节数
+	Cost     uint64        //Compares + Allocs*tuneAllocCompares，TuneDegree按它排序
+	Elapsed  time.Duration //插入并查找一遍sample的耗时，取多次测量中最短的一次，只在Cost相同时参考
+}
+
+//统计Less调用次数的item
+type countingItem struct {
+	Item
+	n *int
+}
+
+func (c countingItem) Less(than Item) bool {
+	*c.n++
+	return c.Item.Less(than.(countingItem).Item)
+}
+
+//TuneDegree用sample对每个候选degree测量插入并查找一遍的代价，返回代价最小的degree以及所有候选的测量结果
+//代价由Less的调用次数和内存分配次数组成，测量时使用item自己的Less和t的查找策略，
+//两者对同一个sample都是确定的，所以同一个sample总是得到同一个推荐；耗时只用来在代价相同时做选择。
+//sample为nil时使用t中最多10000个item。
+func (t *BTree) TuneDegree(sample []Item) (best int, results []DegreeResult) {
+	if sample == nil {
+		t.Ascend(func(i Item) bool {
+			sample = append(sample, i)
+			return len(sample) < 10000
+		})
+	}
+	if len(sample) == 0 {
+		return t.degree, nil
+	}
+	//打乱顺序，避免有序的sample只触发最右侧路径的插入
+	shuffled := make([]Item, len(sample))
+	for i, j := range perm32(len(sample)) {
+		shuffled[i] = sample[j]
+	}
+	var ms runtime.MemStats
+	for _, degree := range tuneDegrees {
+		r := DegreeResult{Degree: degree}
+		//先用countingItem统计比较次数
+		counted := make([]Item, len(shuffled))
+		for i, item := range shuffled {
+			counted[i] = countingItem{Item: item, n: &r.Compares}
+		}
+		tr := t.tuneTree(degree)
+		for _, item := range counted {
+			tr.ReplaceOrInsert(item)
+		}
+		for _, item := range counted {
+			tr.Get(item)
+		}
+		//再用原始item测量分配和耗时
+		for round := 0; round < 3; round++ {
+			tr := t.tuneTree(degree)
+			runtime.ReadMemStats(&ms)
+			mallocs, bytes := ms.Mallocs, ms.TotalAlloc
+			begin := time.Now()
+			for _, item := range shuffled {
+				tr.ReplaceOrInsert(item)
+			}
+			elapsed := time.Since(begin)
+			runtime.ReadMemStats(&ms)
+			begin = time.Now()
+			for _, item := range shuffled {
+				tr.Get(item)
+			}
+			elapsed += time.Since(begin)
+			if round == 0 || elapsed < r.Elapsed {
+				r.Elapsed = elapsed
+			}
+			//其他goroutine的分配只会让计数变多，取最少的一次
+			if allocs := ms.Mallocs - mallocs; round == 0 || allocs < r.Allocs {
+				r.Allocs, r.Bytes = allocs, ms.TotalAlloc-bytes
+			}
+		}
+		r.Cost = uint64(r.Compares) + r.Allocs*tuneAllocCompares
+		results = append(results, r)
+	}
+	return bestDegree(results), results
+}
+
+//返回Cost最小的degree，Cost相同时选耗时短的，再相同时选小的degree
+func bestDegree(results []DegreeResult) int {
+	best := -1
+	for i, r := range results {
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := results[best]
+		switch {
+		case r.Cost != b.Cost:
+			if r.Cost < b.Cost {
+				best = i
+			}
+		case r.Elapsed != b.Elapsed:
+			if r.Elapsed < b.Elapsed {
+				best = i
+			}
+		case r.Degree < b.Degree:
+			best = i
+		}
+	}
+	return results[best].Degree
+}
+
+//创建一棵和t使用相同查找策略的空tree，使用独立的freelist，避免测量互相影响
+func (t *BTree) tuneTree(degree int) *BTree {
+	tr := New(degree)
+	tr.cow.search, tr.cow.linearMax = t.cow.search, t.cow.linearMax
+	return tr
+}
+
+//不依赖全局随机数的确定性排列，使测量可以重复
+func perm32(n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = i
+	}
+	x := uint32(2463534242)
+	for i := n - 1; i > 0; i-- {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		j := int(x % uint32(i+1))
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package btree
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRebuild(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 7, 100, 1000, 4321} {
+		tr := New(*btreeDegree)
+		for _, v := range perm(size) {
+			tr.ReplaceOrInsert(v)
+		}
+		for _, degree := range []int{2, 3, 4, 16, 100} {
+			out := tr.Rebuild(degree)
+			verifyTree(t, out)
+			if got, want := all(out), rang(size); !reflect.DeepEqual(got, want) && size > 0 {
+				t.Fatalf("size %d degree %d mismatch:\n got: %v\nwant: %v", size, degree, got, want)
+			}
+			//重建后的tree可以继续正常修改
+			for _, v := range perm(size) {
+				if out.Delete(v) == nil {
+					t.Fatalf("size %d degree %d: didn't find %v", size, degree, v)
+				}
+				out.ReplaceOrInsert(Int(size + int(v.(Int))))
+			}
+			verifyTree(t, out)
+		}
+		if got := all(tr); len(got) != size {
+			t.Fatalf("Rebuild modified the original tree")
+		}
+	}
+}
+
+func TestTuneDegree(t *testing.T) {
+	tr := New(2)
+	for _, v := range perm(2000) {
+		tr.ReplaceOrInsert(v)
+	}
+	best, results := tr.TuneDegree(nil)
+	if len(results) != len(tuneDegrees) {
+		t.Fatalf("got %d results", len(results))
+	}
+	found := false
+	for i, r := range results {
+		if r.Degree != tuneDegrees[i] || r.Compares == 0 || r.Elapsed <= 0 {
+			t.Fatalf("bad result %+v", r)
+		}
+		found = found || r.Degree == best
+	}
+	if !found {
+		t.Fatalf("recommended degree %d not among candidates", best)
+	}
+	if best != bestDegree(results) {
+		t.Fatalf("recommended %d, cheapest is %d", best, bestDegree(results))
+	}
+	//比较次数只取决于样本，和耗时无关
+	_, again := tr.TuneDegree(nil)
+	for i := range results {
+		if results[i].Compares != again[i].Compares {
+			t.Fatalf("degree %d: %d compares, then %d", results[i].Degree, results[i].Compares, again[i].Compares)
+		}
+	}
+	if best, results := New(3).TuneDegree(nil); best != 3 || results != nil {
+		t.Fatalf("empty sample: got %d %v", best, results)
+	}
+}
+
+func TestBestDegree(t *testing.T) {
+	ms := time.Millisecond
+	for _, c := range []struct {
+		results []DegreeResult
+		want    int
+	}{
+		//代价最低的胜出，即使它更慢
+		{[]DegreeResult{{Degree: 2, Cost: 500, Elapsed: ms}, {Degree: 4, Cost: 300, Elapsed: 9 * ms}, {Degree: 8, Cost: 400, Elapsed: 2 * ms}}, 4},
+		//耗时只在代价相同时起作用
+		{[]DegreeResult{{Degree: 2, Cost: 300, Elapsed: 5 * ms}, {Degree: 4, Cost: 300, Elapsed: 3 * ms}, {Degree: 8, Cost: 900, Elapsed: ms}}, 4},
+		//然后选较小的degree
+		{[]DegreeResult{{Degree: 16, Cost: 300, Elapsed: ms}, {Degree: 8, Cost: 300, Elapsed: ms}}, 8},
+	} {
+		if got := bestDegree(c.results); got != c.want {
+			t.Errorf("bestDegree(%+v) = %d, want %d", c.results, got, c.want)
+		}
+	}
+}
+
+func BenchmarkRebuild(b *testing.B) {
+	tr := New(*btreeDegree)
+	for _, v := range perm(benchmarkTreeSize) {
+		tr.ReplaceOrInsert(v)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Rebuild(16)
+	}
+}
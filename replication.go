@@ -0,0 +1,318 @@
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+//复制流中记录的类型
+const (
+	replPut    byte = 1 //插入或者替换，follower上用ReplaceOrInsert重放
+	replDelete byte = 2
+	replClear  byte = 3
+)
+
+var (
+	//ErrReplicationGap表示复制流中缺少了一段操作，follower需要从更早的序号重新拉取或者重新加载快照
+	ErrReplicationGap = errors.New("btree: replication gap")
+	//ErrReplicationTruncated表示leader已经丢弃了请求的序号之后的一部分操作，follower需要重新加载快照
+	ErrReplicationTruncated = errors.New("btree: replication log truncated")
+	//ErrBadReplicationStream表示复制流或者快照损坏
+	ErrBadReplicationStream = errors.New("btree: bad replication stream")
+
+	replSnapshotMagic = []byte("btrepl1\n")
+)
+
+//Replicator把leader tree上的每一次修改记录成带有序号的操作，序号从1开始连续递增
+//它作为Observer注册在tree上，所以修改tree的方式和原来一样，ApplyBatch、DeleteFunc等批量修改会记录成多条操作。
+//WriteOps把某个序号之后的操作写到io.Writer中，follower用Follower.Apply重放；
+//新的follower先用Snapshot得到的快照初始化，再从快照的序号开始拉取操作。
+//WriteOps、Seq和Truncate可以在其他goroutine中和修改tree并发调用，Snapshot和Clone一样只能在修改tree的goroutine中调用。
+//item编码失败时这次修改无法复制，之后的修改也不再记录，WriteOps一直返回这个错误，
+//直到下一次Snapshot丢弃整个log，所有follower都需要用新的快照重新初始化。
+type Replicator struct {
+	mu    sync.Mutex
+	tree  *BTree
+	codec Codec
+	seq   uint64   //最后一条操作的序号
+	first uint64   //log[0]的序号
+	log   [][]byte //编码好的记录
+	err   error    //编码item时的第一个错误，Snapshot之后清除
+}
+
+//NewReplicator创建一个记录t的修改的Replicator，c用来编码item
+func NewReplicator(t *BTree, c Codec) *Replicator {
+	r := &Replicator{tree: t, codec: c, first: 1}
+	t.Observe(r)
+	return r
+}
+
+func (r *Replicator) OnInsert(item Item) {
+	r.record(replPut, item)
+}
+
+func (r *Replicator) OnReplace(old, new Item) {
+	r.record(replPut, new)
+}
+
+func (r *Replicator) OnDelete(item Item) {
+	r.record(replDelete, item)
+}
+
+func (r *Replicator) OnClear() {
+	r.record(replClear, nil)
+}
+
+//编码一条操作并追加到log中
+func (r *Replicator) record(typ byte, item Item) {
+	var tmp [binary.MaxVarintLen64]byte
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		//log已经和tree不一致了，等待Snapshot
+		return
+	}
+	var encoded []byte
+	if item != nil {
+		var err error
+		if encoded, err = r.codec.EncodeItem(item); err != nil {
+			r.err = err
+			return
+		}
+	}
+	r.seq++
+	data := append([]byte(nil), tmp[:binary.PutUvarint(tmp[:], r.seq)]...)
+	data = append(data, encoded...)
+	r.log = append(r.log, appendWALRecord(nil, typ, data))
+}
+
+//Seq返回最后一条操作的序号，还没有修改时返回0
+func (r *Replicator) Seq() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq
+}
+
+//WriteOps把序号大于from的所有操作写到w中，返回写入的最后一条操作的序号
+//from之后的操作已经被Truncate丢弃时返回ErrReplicationTruncated。
+func (r *Replicator) WriteOps(w io.Writer, from uint64) (uint64, error) {
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return from, r.err
+	}
+	if from+1 < r.first {
+		r.mu.Unlock()
+		return from, ErrReplicationTruncated
+	}
+	if from >= r.seq {
+		r.mu.Unlock()
+		return from, nil
+	}
+	//记录写入之后不会再被修改，复制切片之后可以在不持有锁的情况下写
+	ops, last := r.log[from+1-r.first:], r.seq
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, op := range ops {
+		if _, err := bw.Write(op); err != nil {
+			return from, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return from, err
+	}
+	return last, nil
+}
+
+//Truncate丢弃序号不大于upTo的操作，所有follower都已经应用过这些操作，或者会从更新的快照开始时使用
+func (r *Replicator) Truncate(upTo uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if upTo > r.seq {
+		upTo = r.seq
+	}
+	if upTo < r.first {
+		return
+	}
+	drop := int(upTo + 1 - r.first)
+	r.log = append([][]byte(nil), r.log[drop:]...)
+	r.first = upTo + 1
+}
+
+//Snapshot用Clone得到tree当前的快照，把它和对应的序号写到w中，返回快照的序号
+//只有Clone需要在修改tree的goroutine中完成，序列化时tree可以继续被修改。
+//之前有item编码失败时，Snapshot清除这个错误并丢弃整个log，快照使用一个新的序号，
+//所以快照之前的follower拉取操作时都会得到ErrReplicationTruncated。
+func (r *Replicator) Snapshot(w io.Writer) (uint64, error) {
+	r.mu.Lock()
+	if r.err != nil {
+		//快照中包含没有记录下来的修改，不能和任何已有的序号对应
+		r.seq++
+		r.first, r.log, r.err = r.seq+1, nil, nil
+	}
+	tree, seq := r.tree.Clone(), r.seq
+	r.mu.Unlock()
+	return seq, writeReplSnapshot(w, tree, seq, r.codec)
+}
+
+//快照：magic、序号、item数、每个item的长度和编码，最后是前面所有内容的CRC
+func writeReplSnapshot(w io.Writer, tree *BTree, seq uint64, c Codec) error {
+	buf := append([]byte(nil), replSnapshotMagic...)
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], seq)]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(tree.Len()))]...)
+	var err error
+	tree.Ascend(func(i Item) bool {
+		var data []byte
+		if data, err = c.EncodeItem(i); err != nil {
+			return false
+		}
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)
+		buf = append(buf, data...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(buf, crcTable))
+	buf = append(buf, sum[:]...)
+	_, err = w.Write(buf)
+	return err
+}
+
+//Follower在另一个进程中重放Replicator记录的操作，维护一份leader tree的副本
+//Tree只能读取，所有的修改都应该来自Apply和Bootstrap。
+type Follower struct {
+	Tree  *BTree
+	codec Codec
+	seq   uint64
+}
+
+//NewFollower创建一个空的Follower，degree是副本tree的degree，c必须和leader使用的Codec一样
+func NewFollower(degree int, c Codec) *Follower {
+	return &Follower{Tree: New(degree), codec: c}
+}
+
+//Seq返回已经应用的最后一条操作的序号，从leader拉取操作时从这个序号继续
+func (f *Follower) Seq() uint64 {
+	return f.seq
+}
+
+//Bootstrap用Replicator.Snapshot写出的快照替换副本tree，之后从快照的序号继续应用操作
+func (f *Follower) Bootstrap(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < len(replSnapshotMagic)+4 || !bytes.HasPrefix(data, replSnapshotMagic) {
+		return ErrBadReplicationStream
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crcTable) != sum {
+		return ErrBadReplicationStream
+	}
+	body = body[len(replSnapshotMagic):]
+	seq, n := binary.Uvarint(body)
+	if n <= 0 {
+		return ErrBadReplicationStream
+	}
+	body = body[n:]
+	count, n := binary.Uvarint(body)
+	if n <= 0 || count > uint64(len(body)) {
+		return ErrBadReplicationStream
+	}
+	body = body[n:]
+	sorted := make(items, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(body)
+		if n <= 0 || uint64(len(body)-n) < size {
+			return ErrBadReplicationStream
+		}
+		item, err := f.codec.DecodeItem(body[n : n+int(size)])
+		if err != nil {
+			return ErrBadReplicationStream
+		}
+		sorted = append(sorted, item)
+		body = body[n+int(size):]
+	}
+	tree := New(f.Tree.degree)
+	if len(sorted) > 0 {
+		tree.root = tree.buildSorted(sorted, true)
+		tree.length = len(sorted)
+	}
+	f.Tree, f.seq = tree, seq
+	return nil
+}
+
+//Apply从r中读取WriteOps写出的操作并按顺序重放，直到r结束
+//序号不大于Seq的操作已经应用过，会被跳过，所以重复发送的操作是安全的；
+//遇到不连续的序号时返回ErrReplicationGap，之前的操作已经应用，Seq停在缺口之前。
+func (f *Follower) Apply(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var head [walHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(head[:])
+		if size == 0 || size > 1<<30 {
+			return ErrBadReplicationStream
+		}
+		rec := make([]byte, size)
+		if _, err := io.ReadFull(br, rec); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if crc32.Checksum(rec, crcTable) != binary.BigEndian.Uint32(head[4:]) {
+			return ErrBadReplicationStream
+		}
+		seq, n := binary.Uvarint(rec[1:])
+		if n <= 0 {
+			return ErrBadReplicationStream
+		}
+		if seq <= f.seq {
+			continue
+		}
+		if seq != f.seq+1 {
+			return fmt.Errorf("%w: have %d, got %d", ErrReplicationGap, f.seq, seq)
+		}
+		if err := f.apply(rec[0], rec[1+n:]); err != nil {
+			return err
+		}
+		f.seq = seq
+	}
+}
+
+//重放一条操作
+func (f *Follower) apply(typ byte, data []byte) error {
+	if typ == replClear {
+		f.Tree.Clear(true)
+		return nil
+	}
+	item, err := f.codec.DecodeItem(data)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case replPut:
+		f.Tree.ReplaceOrInsert(item)
+	case replDelete:
+		f.Tree.Delete(item)
+	default:
+		return ErrBadReplicationStream
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+//ship通过pipe把leader在from之后的操作发送给follower
+func ship(t *testing.T, r *Replicator, f *Follower, from uint64) error {
+	t.Helper()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_, err := r.WriteOps(pw, from)
+		pw.CloseWithError(err)
+	}()
+	return f.Apply(pr)
+}
+
+func TestReplicator(t *testing.T) {
+	leader := New(4)
+	r := NewReplicator(leader, IntCodec{})
+	for _, v := range perm(200) {
+		leader.ReplaceOrInsert(v)
+	}
+	for i := 0; i < 200; i += 3 {
+		leader.Delete(Int(i))
+	}
+	leader.ApplyBatch([]Op{{Kind: OpInsert, Item: Int(1000)}, {Kind: OpDelete, Item: Int(1)}})
+
+	f := NewFollower(3, IntCodec{})
+	if err := ship(t, r, f, f.Seq()); err != nil {
+		t.Fatal(err)
+	}
+	if f.Seq() != r.Seq() {
+		t.Fatalf("follower at %d, leader at %d", f.Seq(), r.Seq())
+	}
+	if got, want := all(f.Tree), all(leader); !reflect.DeepEqual(got, want) {
+		t.Fatalf("follower has %d items, leader %d", len(got), len(want))
+	}
+
+	//重新重放所有操作不会有任何影响
+	if err := ship(t, r, f, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := all(f.Tree), all(leader); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replay changed the follower")
+	}
+
+	//从follower的序号继续
+	leader.DeleteMin()
+	leader.ReplaceOrInsert(Int(-7))
+	if err := ship(t, r, f, f.Seq()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := all(f.Tree), all(leader); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after resume follower has %v, want %v", got[:3], want[:3])
+	}
+
+	//跳过了一部分操作的流会被拒绝
+	leader.ReplaceOrInsert(Int(2000))
+	leader.ReplaceOrInsert(Int(2001))
+	before := f.Seq()
+	if err := ship(t, r, f, before+1); !errors.Is(err, ErrReplicationGap) {
+		t.Fatalf("got %v, want gap", err)
+	}
+	if f.Seq() != before || f.Tree.Has(Int(2001)) {
+		t.Fatalf("gap applied ops")
+	}
+
+	leader.Clear(true)
+	leader.ReplaceOrInsert(Int(5))
+	if err := ship(t, r, f, f.Seq()); err != nil {
+		t.Fatal(err)
+	}
+	if got := all(f.Tree); !reflect.DeepEqual(got, []Item{Int(5)}) {
+		t.Fatalf("after clear follower has %v", got)
+	}
+}
+
+func TestReplicatorSnapshot(t *testing.T) {
+	leader := New(4)
+	r := NewReplicator(leader, IntCodec{})
+	for _, v := range perm(100) {
+		leader.ReplaceOrInsert(v)
+	}
+	var snap bytes.Buffer
+	seq, err := r.Snapshot(&snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Truncate(seq)
+	leader.Delete(Int(10))
+	leader.ReplaceOrInsert(Int(500))
+
+	f := NewFollower(4, IntCodec{})
+	if err := ship(t, r, f, f.Seq()); err != ErrReplicationTruncated {
+		t.Fatalf("got %v, want truncated", err)
+	}
+	if err := f.Bootstrap(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if f.Seq() != seq || f.Tree.Len() != 100 {
+		t.Fatalf("bootstrap at %d with %d items", f.Seq(), f.Tree.Len())
+	}
+	if err := ship(t, r, f, f.Seq()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := all(f.Tree), all(leader); !reflect.DeepEqual(got, want) {
+		t.Fatalf("follower has %d items, leader %d", len(got), len(want))
+	}
+
+	corrupt := bytes.NewReader([]byte("btrepl1\nxxxxxxxx"))
+	if err := f.Bootstrap(corrupt); err != ErrBadReplicationStream {
+		t.Fatalf("got %v, want bad stream", err)
+	}
+}
+
+//negCodec不能编码负数
+type negCodec struct{ IntCodec }
+
+var errNegative = errors.New("negative item")
+
+func (c negCodec) EncodeItem(item Item) ([]byte, error) {
+	if item.(Int) < 0 {
+		return nil, errNegative
+	}
+	return c.IntCodec.EncodeItem(item)
+}
+
+func TestReplicatorEncodeError(t *testing.T) {
+	leader := New(4)
+	r := NewReplicator(leader, negCodec{})
+	for i := 0; i < 10; i++ {
+		leader.ReplaceOrInsert(Int(i))
+	}
+	f := NewFollower(4, negCodec{})
+	if err := ship(t, r, f, f.Seq()); err != nil {
+		t.Fatal(err)
+	}
+	leader.ReplaceOrInsert(Int(-1))
+	leader.ReplaceOrInsert(Int(20))
+	if r.Seq() != 10 {
+		t.Fatalf("seq advanced to %d after encode error", r.Seq())
+	}
+	if err := ship(t, r, f, f.Seq()); err != errNegative {
+		t.Fatalf("got %v, want encode error", err)
+	}
+	//快照中也无法编码这个item
+	if _, err := r.Snapshot(ioutil.Discard); err != errNegative {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	leader.Delete(Int(-1))
+	leader.ReplaceOrInsert(Int(30))
+	var snap bytes.Buffer
+	seq, err := r.Snapshot(&snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.ReplaceOrInsert(Int(40))
+	//快照之前的follower必须重新初始化
+	if err := ship(t, r, f, f.Seq()); err != ErrReplicationTruncated {
+		t.Fatalf("got %v, want truncated", err)
+	}
+	if err := f.Bootstrap(&snap); err != nil || f.Seq() != seq {
+		t.Fatalf("bootstrap at %d: %v", f.Seq(), err)
+	}
+	if err := ship(t, r, f, f.Seq()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := all(f.Tree), all(leader); !reflect.DeepEqual(got, want) {
+		t.Fatalf("follower %v, leader %v", got, want)
+	}
+}
@@ -0,0 +1,394 @@
+package btree
+
+//Sequence中的值，节点中按位置排列，不需要也不能比较
+type seqValue struct {
+	v interface{}
+}
+
+func (seqValue) Less(Item) bool {
+	panic("btree: Sequence values are not ordered")
+}
+
+//Sequence是以位置为key的序列，可以用作文本缓冲区或者有序列表
+//它使用和BTree一样的节点，值按位置排列而不比较，每个节点记录的子树大小把位置映射到节点，
+//所以InsertAt、DeleteAt、At、Concat和SplitAt都是O(log n)，Slice是O(log n + 返回的个数)。
+//Clone和BTree.Clone一样是延迟的，之后对两个序列的修改都只复制被修改的节点。
+type Sequence struct {
+	t *BTree
+}
+
+//NewSequence创建一个使用给定degree的空序列
+func NewSequence(degree int) *Sequence {
+	return &Sequence{t: New(degree)}
+}
+
+//Len返回序列的长度
+func (s *Sequence) Len() int {
+	return s.t.length
+}
+
+//Clone返回序列的延迟拷贝，和BTree.Clone的约束一样
+func (s *Sequence) Clone() *Sequence {
+	return &Sequence{t: s.t.Clone()}
+}
+
+func (s *Sequence) checkIndex(i, max int) {
+	if i < 0 || i > max {
+		panic("btree: Sequence index out of range")
+	}
+}
+
+//At返回位置i的值，i必须在[0, Len())范围内
+func (s *Sequence) At(i int) interface{} {
+	s.checkIndex(i, s.Len()-1)
+	return s.t.root.at(i).(seqValue).v
+}
+
+//InsertAt把v插入到位置i，原来在i以及之后的值都后移一位，i必须在[0, Len()]范围内
+func (s *Sequence) InsertAt(i int, v interface{}) {
+	s.checkIndex(i, s.Len())
+	t := s.t
+	if t.root == nil {
+		t.root = t.cow.newNode()
+	}
+	t.mutableRootForInsert()
+	t.root.insertPos(i, seqValue{v}, t.maxItems())
+	t.length++
+}
+
+//DeleteAt删除并返回位置i的值，之后的值都前移一位，i必须在[0, Len())范围内
+func (s *Sequence) DeleteAt(i int) interface{} {
+	s.checkIndex(i, s.Len()-1)
+	return s.deleteAt(i).(seqValue).v
+}
+
+func (s *Sequence) deleteAt(i int) Item {
+	t := s.t
+	t.root = t.root.mutableFor(t.cow)
+	out := t.root.removePos(i, t.minItems())
+	if len(t.root.items) == 0 && len(t.root.children) > 0 {
+		oldRoot := t.root
+		t.root = t.root.children[0]
+		t.cow.freeNode(oldRoot)
+	}
+	t.length--
+	return out
+}
+
+//Slice返回[i, j)范围内的值，0 <= i <= j <= Len()
+func (s *Sequence) Slice(i, j int) []interface{} {
+	s.checkIndex(j, s.Len())
+	s.checkIndex(i, j)
+	if i == j {
+		return nil
+	}
+	out := make([]interface{}, 0, j-i)
+	s.t.root.ascendPos(i, func(item Item) bool {
+		out = append(out, item.(seqValue).v)
+		return len(out) < cap(out)
+	})
+	return out
+}
+
+//Concat把other中的所有值追加到s的末尾，other不会被修改，两个序列的degree必须相同
+//取出other的第一个值作为分隔，把较矮的tree挂到较高的tree的边上，只需要修改这条边上的节点。
+func (s *Sequence) Concat(other *Sequence) {
+	if s.t.degree != other.t.degree {
+		panic("btree: Concat of sequences with different degrees")
+	}
+	if other.Len() == 0 {
+		return
+	}
+	o := &Sequence{t: other.t.Clone()}
+	length := s.t.length + o.t.length
+	sep := o.deleteAt(0)
+	if o.t.length == 0 {
+		o.t.root = nil
+	}
+	if s.t.length == 0 {
+		s.t.root = nil
+	}
+	s.t.root, _ = s.join(s.t.root, height(s.t.root), sep, o.t.root, height(o.t.root))
+	s.t.length = length
+}
+
+//SplitAt把s截断成前i个值，并返回包含其余值的新序列，i必须在[0, Len()]范围内
+//沿着位置i从root向下，把每一层在i左边和右边的部分分别和下一层的结果连接起来，
+//每次连接的代价和两边高度的差成正比，加起来是O(log n)。
+func (s *Sequence) SplitAt(i int) *Sequence {
+	s.checkIndex(i, s.Len())
+	length := s.t.length
+	var l, r *node
+	if i < length {
+		l, _, r, _ = s.split(s.t.root, height(s.t.root), i)
+	} else {
+		l = s.t.root
+	}
+	//两部分共享了原来的节点和拆分时新建的节点，和Clone一样让两边都使用新的cow，之后修改时各自复制
+	out := &Sequence{t: s.t.Clone()}
+	s.t.root, s.t.length = l, i
+	out.t.root, out.t.length = r, length-i
+	return out
+}
+
+//以n为根的子树的高度，叶子节点为0，nil也为0
+func height(n *node) int {
+	h := 0
+	for n != nil && len(n.children) > 0 {
+		n = n.children[0]
+		h++
+	}
+	return h
+}
+
+//在子树中位置i插入item，和insert一样在向下的过程中拆分已满的子节点
+func (n *node) insertPos(i int, item Item, maxItems int) {
+	n.size++
+	if len(n.children) == 0 {
+		n.items.insertAt(i, item)
+		return
+	}
+	//i等于子节点j的大小时插入到子节点j的末尾，也就是items[j]之前
+	j := 0
+	for i > n.children[j].size {
+		i -= n.children[j].size + 1
+		j++
+	}
+	if n.maybeSplitChild(j, maxItems) && i > n.children[j].size {
+		i -= n.children[j].size + 1
+		j++
+	}
+	n.mutableChild(j).insertPos(i, item, maxItems)
+}
+
+//删除并返回子树中位置i的item，和remove一样在向下之前保证子节点有多于minItems个item
+func (n *node) removePos(i int, minItems int) Item {
+	if len(n.children) == 0 {
+		n.size--
+		return n.items.removeAt(i)
+	}
+	j, local := 0, i
+	for local > n.children[j].size {
+		local -= n.children[j].size + 1
+		j++
+	}
+	if len(n.children[j].items) <= minItems {
+		n.growChild(j, minItems)
+		return n.removePos(i, minItems)
+	}
+	n.size--
+	child := n.mutableChild(j)
+	if local == child.size {
+		//要删除的是items[j]，用左边子树中的最后一个item代替它
+		out := n.items[j]
+		n.items[j] = child.remove(nil, minItems, removeMax)
+		return out
+	}
+	return child.removePos(local, minItems)
+}
+
+//从位置i开始按顺序对子树中的item调用fn，直到fn返回false
+func (n *node) ascendPos(i int, fn func(Item) bool) bool {
+	if len(n.children) == 0 {
+		for _, item := range n.items[i:] {
+			if !fn(item) {
+				return false
+			}
+		}
+		return true
+	}
+	for j, child := range n.children {
+		if i < child.size {
+			if !child.ascendPos(i, fn) {
+				return false
+			}
+			i = 0
+		} else {
+			i -= child.size
+		}
+		if j < len(n.items) {
+			if i > 0 {
+				i--
+			} else if !fn(n.items[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//子节点i的item少于minItems时不断从兄弟节点窃取，直到足够或者和兄弟节点合并
+func (n *node) fill(i, minItems int) {
+	for len(n.children[i].items) < minItems {
+		if n.growChild(i, minItems) {
+			return
+		}
+	}
+}
+
+//用items和children创建高度为h的新节点，没有item时返回唯一的子节点，什么都没有时返回nil
+func (s *Sequence) newSubtree(items []Item, children []*node, h int) (*node, int) {
+	if len(items) == 0 {
+		if len(children) == 0 {
+			return nil, 0
+		}
+		return children[0], h - 1
+	}
+	n := s.t.cow.newNode()
+	n.items = append(n.items, items...)
+	n.children = append(n.children, children...)
+	n.recount()
+	return n, h
+}
+
+//连接l、sep和r，返回新的root和它的高度，l和r可以是nil，它们的root可以少于minItems个item
+func (s *Sequence) join(l *node, hl int, sep Item, r *node, hr int) (*node, int) {
+	t := s.t
+	switch {
+	case l == nil && r == nil:
+		n := t.cow.newNode()
+		n.items = append(n.items, sep)
+		n.size = 1
+		return n, 0
+	case l == nil:
+		return s.insertRoot(r, hr, 0, sep)
+	case r == nil:
+		return s.insertRoot(l, hl, l.size, sep)
+	case hl == hr:
+		n := t.cow.newNode()
+		n.items = append(n.items, sep)
+		n.children = append(n.children, l, r)
+		n.recount()
+		n.fill(0, t.minItems())
+		if len(n.items) == 0 {
+			//l和r合并成了一个节点
+			child := n.children[0]
+			t.cow.freeNode(n)
+			return child, hl
+		}
+		n.fill(1, t.minItems())
+		if len(n.items) == 0 {
+			child := n.children[0]
+			t.cow.freeNode(n)
+			return child, hl
+		}
+		return n, hl + 1
+	case hl > hr:
+		return s.splitRoot(s.joinRight(l, hl, sep, r, hr), hl)
+	default:
+		return s.splitRoot(s.joinLeft(l, hl, sep, r, hr), hr)
+	}
+}
+
+//在root的位置pos插入item，返回新的root和高度，和InsertAt一样在root已满时先拆分它
+func (s *Sequence) insertRoot(root *node, h, pos int, item Item) (*node, int) {
+	t := s.t
+	root = root.mutableFor(t.cow)
+	if len(root.items) >= t.maxItems() {
+		item2, second := root.split(t.maxItems() / 2)
+		oldRoot := root
+		root = t.cow.newNode()
+		root.items = append(root.items, item2)
+		root.children = append(root.children, oldRoot, second)
+		root.recount()
+		h++
+	}
+	root.insertPos(pos, item, t.maxItems())
+	return root, h
+}
+
+//root的item超过maxItems时拆分它
+func (s *Sequence) splitRoot(n *node, h int) (*node, int) {
+	if len(n.items) <= s.t.maxItems() {
+		return n, h
+	}
+	item, next := n.split(len(n.items) / 2)
+	root := s.t.cow.newNode()
+	root.items = append(root.items, item)
+	root.children = append(root.children, n, next)
+	root.recount()
+	return root, h + 1
+}
+
+//hl > hr，把sep和r挂到l最右边高度为hr+1的节点上，返回的节点可能多出一个item，由调用者拆分
+func (s *Sequence) joinRight(l *node, hl int, sep Item, r *node, hr int) *node {
+	t := s.t
+	l = l.mutableFor(t.cow)
+	l.size += 1 + r.size
+	k := len(l.children) - 1
+	if hl == hr+1 {
+		l.items = append(l.items, sep)
+		l.children = append(l.children, r)
+		l.fill(k+1, t.minItems())
+		return l
+	}
+	c := s.joinRight(l.children[k], hl-1, sep, r, hr)
+	l.children[k] = c
+	if len(c.items) > t.maxItems() {
+		item, next := c.split(len(c.items) / 2)
+		l.items = append(l.items, item)
+		l.children = append(l.children, next)
+	}
+	return l
+}
+
+//hr > hl，和joinRight对称，把l和sep挂到r最左边高度为hl+1的节点上
+func (s *Sequence) joinLeft(l *node, hl int, sep Item, r *node, hr int) *node {
+	t := s.t
+	r = r.mutableFor(t.cow)
+	r.size += 1 + l.size
+	if hr == hl+1 {
+		r.items.insertAt(0, sep)
+		r.children.insertAt(0, l)
+		r.fill(0, t.minItems())
+		return r
+	}
+	c := s.joinLeft(l, hl, sep, r.children[0], hr-1)
+	r.children[0] = c
+	if len(c.items) > t.maxItems() {
+		item, next := c.split(len(c.items) / 2)
+		r.items.insertAt(0, item)
+		r.children.insertAt(1, next)
+	}
+	return r
+}
+
+//把高度为h的子树n在位置i拆成两部分，返回两部分的root和高度，空的部分为nil
+//n本身不会被修改，两部分只会新建沿着位置i的路径上的节点，其余的节点和n共享。
+func (s *Sequence) split(n *node, h, i int) (*node, int, *node, int) {
+	if i == 0 {
+		return nil, 0, n, h
+	}
+	if i == n.size {
+		return n, h, nil, 0
+	}
+	if len(n.children) == 0 {
+		l, _ := s.newSubtree(n.items[:i], nil, 0)
+		r, _ := s.newSubtree(n.items[i:], nil, 0)
+		return l, 0, r, 0
+	}
+	j, local := 0, i
+	for local > n.children[j].size {
+		local -= n.children[j].size + 1
+		j++
+	}
+	if local == n.children[j].size {
+		//items[j]是右边部分的第一个
+		l, hl := s.newSubtree(n.items[:j], n.children[:j+1], h)
+		r, hr := s.newSubtree(n.items[j+1:], n.children[j+1:], h)
+		r, hr = s.join(nil, 0, n.items[j], r, hr)
+		return l, hl, r, hr
+	}
+	cl, hcl, cr, hcr := s.split(n.children[j], h-1, local)
+	l, hl := cl, hcl
+	if j > 0 {
+		left, hleft := s.newSubtree(n.items[:j-1], n.children[:j], h)
+		l, hl = s.join(left, hleft, n.items[j-1], cl, hcl)
+	}
+	r, hr := cr, hcr
+	if j < len(n.items) {
+		right, hright := s.newSubtree(n.items[j+1:], n.children[j+1:], h)
+		r, hr = s.join(cr, hcr, n.items[j], right, hright)
+	}
+	return l, hl, r, hr
+}
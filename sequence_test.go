@@ -0,0 +1,244 @@
+package btree
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//checkSequence检查s满足B-Tree的约束，并且内容和want相同
+func checkSequence(t *testing.T, s *Sequence, want []interface{}) {
+	t.Helper()
+	if s.Len() != len(want) {
+		t.Fatalf("len = %d, want %d", s.Len(), len(want))
+	}
+	if got := s.Slice(0, s.Len()); len(want) > 0 && !reflect.DeepEqual(got, want) {
+		t.Fatalf("contents = %v, want %v", got, want)
+	}
+	if s.t.root == nil || s.t.root.size == 0 {
+		if len(want) != 0 {
+			t.Fatalf("empty root with %d values", len(want))
+		}
+		return
+	}
+	leafDepth := -1
+	var walk func(n *node, depth int, root bool) int
+	walk = func(n *node, depth int, root bool) int {
+		if !root && (len(n.items) < s.t.minItems() || len(n.items) > s.t.maxItems()) {
+			t.Fatalf("node with %d items, degree %d", len(n.items), s.t.degree)
+		}
+		if root && (len(n.items) == 0 || len(n.items) > s.t.maxItems()) {
+			t.Fatalf("root with %d items, degree %d", len(n.items), s.t.degree)
+		}
+		size := len(n.items)
+		if len(n.children) == 0 {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				t.Fatalf("leaves at depth %d and %d", leafDepth, depth)
+			}
+		} else {
+			if len(n.children) != len(n.items)+1 {
+				t.Fatalf("%d children for %d items", len(n.children), len(n.items))
+			}
+			for _, c := range n.children {
+				size += walk(c, depth+1, false)
+			}
+		}
+		if n.size != size {
+			t.Fatalf("size = %d, want %d", n.size, size)
+		}
+		return size
+	}
+	walk(s.t.root, 0, true)
+}
+
+func TestSequenceInsertDelete(t *testing.T) {
+	for _, degree := range []int{2, 3, 8} {
+		s := NewSequence(degree)
+		var model []interface{}
+		for i := 0; i < 3000; i++ {
+			if len(model) > 0 && rand.Intn(3) == 0 {
+				j := rand.Intn(len(model))
+				if got := s.DeleteAt(j); got != model[j] {
+					t.Fatalf("DeleteAt(%d) = %v, want %v", j, got, model[j])
+				}
+				model = append(model[:j], model[j+1:]...)
+			} else {
+				j := rand.Intn(len(model) + 1)
+				s.InsertAt(j, i)
+				model = append(model[:j], append([]interface{}{i}, model[j:]...)...)
+			}
+			if len(model) > 0 {
+				j := rand.Intn(len(model))
+				if got := s.At(j); got != model[j] {
+					t.Fatalf("At(%d) = %v, want %v", j, got, model[j])
+				}
+			}
+		}
+		checkSequence(t, s, model)
+		for i := 0; i < 50; i++ {
+			a := rand.Intn(len(model) + 1)
+			b := a + rand.Intn(len(model)-a+1)
+			got := s.Slice(a, b)
+			if len(got) != b-a || (b > a && !reflect.DeepEqual(got, model[a:b])) {
+				t.Fatalf("Slice(%d, %d) = %v, want %v", a, b, got, model[a:b])
+			}
+		}
+		for len(model) > 0 {
+			s.DeleteAt(0)
+			model = model[1:]
+		}
+		checkSequence(t, s, nil)
+	}
+}
+
+func seqRange(degree, from, to int) (*Sequence, []interface{}) {
+	s := NewSequence(degree)
+	var model []interface{}
+	for i := from; i < to; i++ {
+		s.InsertAt(s.Len(), i)
+		model = append(model, i)
+	}
+	return s, model
+}
+
+func TestSequenceConcat(t *testing.T) {
+	sizes := []int{0, 1, 2, 5, 30, 200, 2000}
+	for _, degree := range []int{2, 3, 5} {
+		for _, a := range sizes {
+			for _, b := range sizes {
+				l, lm := seqRange(degree, 0, a)
+				r, rm := seqRange(degree, a, a+b)
+				l.Concat(r)
+				checkSequence(t, l, append(lm, rm...))
+				checkSequence(t, r, rm)
+				//r没有通过共享的节点被修改
+				l.InsertAt(l.Len(), -1)
+				if b > 0 {
+					l.DeleteAt(a)
+				}
+				checkSequence(t, r, rm)
+			}
+		}
+	}
+}
+
+func TestSequenceSplitAt(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		for _, n := range []int{0, 1, 7, 100, 1500} {
+			for k := 0; k < 20; k++ {
+				i := rand.Intn(n + 1)
+				s, model := seqRange(degree, 0, n)
+				c := s.Clone()
+				r := s.SplitAt(i)
+				checkSequence(t, s, model[:i])
+				checkSequence(t, r, model[i:])
+				checkSequence(t, c, model)
+				s.Concat(r)
+				checkSequence(t, s, model)
+				//修改拼接之后的s，r和c都不能受到影响
+				for j := 0; j < n/2; j++ {
+					s.DeleteAt(rand.Intn(s.Len()))
+				}
+				s.InsertAt(s.Len()/2, -2)
+				checkSequence(t, r, model[i:])
+				checkSequence(t, c, model)
+				r.InsertAt(0, -1)
+				checkSequence(t, c, model)
+			}
+		}
+	}
+}
+
+func TestSequenceRandomOps(t *testing.T) {
+	s := NewSequence(3)
+	var model []interface{}
+	next := 0
+	for i := 0; i < 1000; i++ {
+		switch rand.Intn(5) {
+		case 0, 1:
+			j := rand.Intn(len(model) + 1)
+			s.InsertAt(j, next)
+			model = append(model[:j], append([]interface{}{next}, model[j:]...)...)
+			next++
+		case 2:
+			if len(model) > 0 {
+				j := rand.Intn(len(model))
+				s.DeleteAt(j)
+				model = append(model[:j], model[j+1:]...)
+			}
+		case 3:
+			//剪切一段随机的范围，再把它粘贴到末尾
+			a := rand.Intn(len(model) + 1)
+			b := a + rand.Intn(len(model)-a+1)
+			tail := s.SplitAt(b)
+			mid := s.SplitAt(a)
+			tailModel := append([]interface{}(nil), model[b:]...)
+			cut := append([]interface{}(nil), model[a:b]...)
+			s.Concat(tail)
+			s.Concat(mid)
+			model = append(append(model[:a:a], model[b:]...), cut...)
+			//修改s之后，拆分出来的两部分都不能改变
+			for j := 0; j < 3 && len(model) > 0; j++ {
+				k := rand.Intn(len(model))
+				s.DeleteAt(k)
+				model = append(model[:k], model[k+1:]...)
+			}
+			s.InsertAt(len(model), next)
+			model = append(model, next)
+			next++
+			checkSequence(t, tail, tailModel)
+			checkSequence(t, mid, cut)
+		case 4:
+			c := s.Clone()
+			c.Concat(c.Clone())
+			checkSequence(t, c, append(append([]interface{}(nil), model...), model...))
+		}
+		checkSequence(t, s, model)
+	}
+}
+
+func TestSequencePanics(t *testing.T) {
+	s, _ := seqRange(3, 0, 10)
+	for name, fn := range map[string]func(){
+		"At":       func() { s.At(10) },
+		"InsertAt": func() { s.InsertAt(11, 0) },
+		"DeleteAt": func() { s.DeleteAt(-1) },
+		"Slice":    func() { s.Slice(5, 4) },
+		"SplitAt":  func() { s.SplitAt(11) },
+		"Concat":   func() { s.Concat(NewSequence(4)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func BenchmarkSequenceInsertAt(b *testing.B) {
+	s := NewSequence(32)
+	for i := 0; i < b.N; i++ {
+		s.InsertAt(rand.Intn(s.Len()+1), i)
+	}
+}
+
+func TestSequenceSplitConcatShared(t *testing.T) {
+	s, model := seqRange(2, 0, 50)
+	tail := s.SplitAt(25)
+	s.Concat(tail)
+	for i := 0; i < 20; i++ {
+		s.DeleteAt(30)
+	}
+	checkSequence(t, tail, model[25:])
+	checkSequence(t, s, append(append([]interface{}(nil), model[:30]...), model[50:]...))
+	//修改tail也不能影响s
+	for tail.Len() > 0 {
+		tail.DeleteAt(tail.Len() / 2)
+	}
+	checkSequence(t, s, model[:30])
+}
@@ -0,0 +1,202 @@
+package btree
+
+import (
+	"math"
+)
+
+//ScoredMember是SortedSet中的一个成员和它的分数
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+//ScoreBound是按分数查找时范围的一端，用math.Inf表示不限制
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool //为true时不包含Score本身
+}
+
+//Inclusive返回包含score的边界
+func Inclusive(score float64) ScoreBound {
+	return ScoreBound{Score: score}
+}
+
+//Exclusive返回不包含score的边界
+func Exclusive(score float64) ScoreBound {
+	return ScoreBound{Score: score, Exclusive: true}
+}
+
+//tree中的item，按(score, member)排序
+//pos不为0的是查找用的边界：-1排在同一个分数的所有成员之前，1排在它们之后
+type zsetEntry struct {
+	score  float64
+	member string
+	pos    int8
+}
+
+func (a zsetEntry) Less(b Item) bool {
+	bb := b.(zsetEntry)
+	if a.score != bb.score {
+		return a.score < bb.score
+	}
+	if a.pos != 0 || bb.pos != 0 {
+		return a.pos < bb.pos
+	}
+	return a.member < bb.member
+}
+
+//SortedSet是类似Redis ZSET的有序集合：每个成员有一个分数，成员按(分数, 成员)排序
+//成员到分数的map用来O(1)查找分数，按(分数, 成员)排序的tree利用每个节点记录的子树大小，
+//在O(log n)内完成按排名的查找（Rank、RangeByRank）和按分数的范围查找。
+//分数不能是NaN。SortedSet不能被并发修改。
+type SortedSet struct {
+	scores map[string]float64
+	tree   *BTree
+}
+
+//NewSortedSet创建一个使用给定degree的空集合
+func NewSortedSet(degree int) *SortedSet {
+	return &SortedSet{scores: make(map[string]float64), tree: New(degree)}
+}
+
+func checkScore(score float64) {
+	if math.IsNaN(score) {
+		panic("NaN score in SortedSet")
+	}
+}
+
+//Add把member的分数设置成score，member原来不存在时返回true
+func (s *SortedSet) Add(member string, score float64) bool {
+	checkScore(score)
+	old, exists := s.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		s.tree.Delete(zsetEntry{score: old, member: member})
+	}
+	s.scores[member] = score
+	s.tree.ReplaceOrInsert(zsetEntry{score: score, member: member})
+	return !exists
+}
+
+//Remove删除member，member不存在时返回false
+func (s *SortedSet) Remove(member string) bool {
+	score, ok := s.scores[member]
+	if !ok {
+		return false
+	}
+	delete(s.scores, member)
+	s.tree.Delete(zsetEntry{score: score, member: member})
+	return true
+}
+
+//IncrBy把member的分数加上delta并返回新的分数，member不存在时当作分数为0
+func (s *SortedSet) IncrBy(member string, delta float64) float64 {
+	score := s.scores[member] + delta
+	s.Add(member, score)
+	return score
+}
+
+//Score返回member的分数，member不存在时返回false
+func (s *SortedSet) Score(member string) (float64, bool) {
+	score, ok := s.scores[member]
+	return score, ok
+}
+
+//Len返回成员的个数
+func (s *SortedSet) Len() int {
+	return len(s.scores)
+}
+
+//Rank返回member按分数从低到高的排名（从0开始），member不存在时返回false
+func (s *SortedSet) Rank(member string) (int, bool) {
+	score, ok := s.scores[member]
+	if !ok {
+		return 0, false
+	}
+	return s.tree.root.rank(zsetEntry{score: score, member: member}), true
+}
+
+//RevRank返回member按分数从高到低的排名（从0开始），member不存在时返回false
+func (s *SortedSet) RevRank(member string) (int, bool) {
+	r, ok := s.Rank(member)
+	if !ok {
+		return 0, false
+	}
+	return s.Len() - 1 - r, true
+}
+
+//RangeByRank按分数从低到高返回排名在[start, stop]之间的成员，和ZRANGE一样，负数表示从末尾倒数
+func (s *SortedSet) RangeByRank(start, stop int) []ScoredMember {
+	n := s.Len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return nil
+	}
+	out := make([]ScoredMember, 0, stop-start+1)
+	s.tree.AscendGreaterOrEqual(s.tree.At(start), func(i Item) bool {
+		out = append(out, scoredMember(i))
+		return len(out) < cap(out)
+	})
+	return out
+}
+
+//RangeByScore按分数从低到高返回分数在min和max之间的成员
+func (s *SortedSet) RangeByScore(min, max ScoreBound) []ScoredMember {
+	var out []ScoredMember
+	ge, lt, ok := scoreRange(min, max)
+	if !ok {
+		return nil
+	}
+	s.tree.AscendRange(ge, lt, func(i Item) bool {
+		out = append(out, scoredMember(i))
+		return true
+	})
+	return out
+}
+
+//RemoveRangeByScore删除分数在min和max之间的所有成员并返回删除的个数，只遍历一次tree
+func (s *SortedSet) RemoveRangeByScore(min, max ScoreBound) int {
+	ge, lt, ok := scoreRange(min, max)
+	if !ok {
+		return 0
+	}
+	return s.tree.DeleteFunc(ge, lt, func(i Item) bool {
+		delete(s.scores, i.(zsetEntry).member)
+		return true
+	})
+}
+
+//把分数的边界转换成tree中的[ge, lt)，范围为空时返回false
+func scoreRange(min, max ScoreBound) (ge, lt Item, ok bool) {
+	checkScore(min.Score)
+	checkScore(max.Score)
+	lo, hi := zsetEntry{score: min.Score, pos: -1}, zsetEntry{score: max.Score, pos: 1}
+	if min.Exclusive {
+		lo.pos = 1
+	}
+	if max.Exclusive {
+		hi.pos = -1
+	}
+	if !lo.Less(hi) {
+		return nil, nil, false
+	}
+	return lo, hi, true
+}
+
+func scoredMember(i Item) ScoredMember {
+	e := i.(zsetEntry)
+	return ScoredMember{Member: e.member, Score: e.score}
+}
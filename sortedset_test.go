@@ -0,0 +1,116 @@
+package btree
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//sortedMembers用排序实现的SortedSet模型，按(score, member)返回所有成员
+func sortedMembers(scores map[string]float64) []ScoredMember {
+	var out []ScoredMember
+	for m, s := range scores {
+		out = append(out, ScoredMember{m, s})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score < out[j].Score
+		}
+		return out[i].Member < out[j].Member
+	})
+	return out
+}
+
+func TestSortedSet(t *testing.T) {
+	s := NewSortedSet(3)
+	model := map[string]float64{}
+	for i := 0; i < 2000; i++ {
+		m := fmt.Sprint("m", rand.Intn(300))
+		switch rand.Intn(4) {
+		case 0:
+			if s.Remove(m) != hasKey(model, m) {
+				t.Fatalf("remove %s", m)
+			}
+			delete(model, m)
+		case 1:
+			model[m] += 1.5
+			if got := s.IncrBy(m, 1.5); got != model[m] {
+				t.Fatalf("incr %s: %v, want %v", m, got, model[m])
+			}
+		default:
+			score := float64(rand.Intn(50))
+			if s.Add(m, score) == hasKey(model, m) {
+				t.Fatalf("add %s reported wrong newness", m)
+			}
+			model[m] = score
+		}
+	}
+	want := sortedMembers(model)
+	if s.Len() != len(want) || !reflect.DeepEqual(s.RangeByRank(0, -1), want) {
+		t.Fatalf("contents differ from model")
+	}
+	for r, sm := range want {
+		if got, _ := s.Rank(sm.Member); got != r {
+			t.Fatalf("rank of %s: %d, want %d", sm.Member, got, r)
+		}
+		if got, _ := s.RevRank(sm.Member); got != len(want)-1-r {
+			t.Fatalf("revrank of %s: %d", sm.Member, got)
+		}
+	}
+	if _, ok := s.Rank("missing"); ok {
+		t.Fatalf("rank of missing member")
+	}
+	if got := s.RangeByRank(-3, -1); !reflect.DeepEqual(got, want[len(want)-3:]) {
+		t.Fatalf("RangeByRank(-3, -1) = %v", got)
+	}
+	if got := s.RangeByRank(5, 2); got != nil {
+		t.Fatalf("empty rank range returned %v", got)
+	}
+
+	inRange := func(score float64, min, max ScoreBound) bool {
+		if score < min.Score || (min.Exclusive && score == min.Score) {
+			return false
+		}
+		return score < max.Score || (!max.Exclusive && score == max.Score)
+	}
+	bounds := [][2]ScoreBound{
+		{Inclusive(10), Inclusive(20)},
+		{Exclusive(10), Inclusive(20)},
+		{Inclusive(10), Exclusive(20)},
+		{Exclusive(10), Exclusive(11)},
+		{Inclusive(math.Inf(-1)), Exclusive(5)},
+		{Exclusive(30), Inclusive(math.Inf(1))},
+		{Inclusive(7), Inclusive(7)},
+		{Exclusive(7), Exclusive(7)},
+	}
+	for _, b := range bounds {
+		var expect []ScoredMember
+		for _, sm := range want {
+			if inRange(sm.Score, b[0], b[1]) {
+				expect = append(expect, sm)
+			}
+		}
+		if got := s.RangeByScore(b[0], b[1]); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("RangeByScore%v = %v, want %v", b, got, expect)
+		}
+	}
+
+	n := s.RemoveRangeByScore(Exclusive(10), Inclusive(20))
+	for m, score := range model {
+		if inRange(score, Exclusive(10), Inclusive(20)) {
+			delete(model, m)
+			n--
+		}
+	}
+	if n != 0 || !reflect.DeepEqual(s.RangeByRank(0, -1), sortedMembers(model)) || s.Len() != len(model) {
+		t.Fatalf("RemoveRangeByScore left the set inconsistent")
+	}
+}
+
+func hasKey(m map[string]float64, k string) bool {
+	_, ok := m[k]
+	return ok
+}
@@ -0,0 +1,141 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+//String 实现了item接口
+type String string
+
+//如果 string(a) < string(b)就返回true
+func (a String) Less(b Item) bool {
+	return a < b.(String)
+}
+
+//Bytes 实现了item接口，使用bytes.Compare比较
+type Bytes []byte
+
+//如果 bytes.Compare(a, b) < 0就返回true
+func (a Bytes) Less(b Item) bool {
+	return bytes.Compare(a, b.(Bytes)) < 0
+}
+
+//Int64 实现了item接口
+type Int64 int64
+
+//如果 int64(a) < int64(b)就返回true
+func (a Int64) Less(b Item) bool {
+	return a < b.(Int64)
+}
+
+//Uint64 实现了item接口
+type Uint64 uint64
+
+//如果 uint64(a) < uint64(b)就返回true
+func (a Uint64) Less(b Item) bool {
+	return a < b.(Uint64)
+}
+
+//Float64 实现了item接口，并且是一个全序：
+//-0小于+0，所有的NaN都相等，并且比+Inf大
+type Float64 float64
+
+//按照上面的全序比较a和b
+func (a Float64) Less(b Item) bool {
+	return float64Key(float64(a)) < float64Key(float64(b.(Float64)))
+}
+
+//将float64映射成一个保持全序的uint64
+func float64Key(f float64) uint64 {
+	if math.IsNaN(f) {
+		return math.MaxUint64
+	}
+	bits := math.Float64bits(f)
+	if bits>>63 != 0 {
+		//负数：翻转所有位，绝对值越大越小
+		return ^bits
+	}
+	return bits | 1<<63
+}
+
+//Time 实现了item接口，按照时间的先后比较，不考虑时区
+type Time time.Time
+
+//如果 a 在 b 之前就返回true
+func (a Time) Less(b Item) bool {
+	return time.Time(a).Before(time.Time(b.(Time)))
+}
+
+//Tuple 是一个由多个item组成的复合key，按字典序比较：
+//逐个比较相同位置的元素，前面的元素都相等时较短的tuple更小。
+//相同位置上的元素可以是不同的类型，不同类型之间先按类型排序，内置类型的顺序和下面tupleRank一致，
+//其他类型排在内置类型之后，按类型名排序。
+type Tuple []Item
+
+//按字典序比较a和b
+func (a Tuple) Less(b Item) bool {
+	bt := b.(Tuple)
+	for i := 0; i < len(a) && i < len(bt); i++ {
+		if lessMixed(a[i], bt[i]) {
+			return true
+		}
+		if lessMixed(bt[i], a[i]) {
+			return false
+		}
+	}
+	return len(a) < len(bt)
+}
+
+//比较两个可能是不同类型的item
+func lessMixed(a, b Item) bool {
+	ra, rb := tupleRank(a), tupleRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	if ra == rankOther {
+		ta, tb := fmt.Sprintf("%T", a), fmt.Sprintf("%T", b)
+		if ta != tb {
+			return ta < tb
+		}
+	}
+	return a.Less(b)
+}
+
+//Tuple中不同类型之间的顺序，同时也是TupleCodec中的类型标记
+const (
+	rankInt byte = iota + 1
+	rankInt64
+	rankUint64
+	rankFloat64
+	rankString
+	rankBytes
+	rankTime
+	rankTuple
+	rankOther
+)
+
+//返回item的类型在Tuple中的顺序
+func tupleRank(i Item) byte {
+	switch i.(type) {
+	case Int:
+		return rankInt
+	case Int64:
+		return rankInt64
+	case Uint64:
+		return rankUint64
+	case Float64:
+		return rankFloat64
+	case String:
+		return rankString
+	case Bytes:
+		return rankBytes
+	case Time:
+		return rankTime
+	case Tuple:
+		return rankTuple
+	}
+	return rankOther
+}
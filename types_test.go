@@ -0,0 +1,177 @@
+package btree
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//assertOrdered检查want按Less严格递增，并且用打乱顺序的want建立的tree按顺序返回它
+func assertOrdered(t *testing.T, name string, want []Item) {
+	t.Helper()
+	for i := 1; i < len(want); i++ {
+		if !want[i-1].Less(want[i]) || want[i].Less(want[i-1]) {
+			t.Fatalf("%s: %v should be less than %v", name, want[i-1], want[i])
+		}
+	}
+	tr := New(2)
+	for _, i := range rand.Perm(len(want)) {
+		tr.ReplaceOrInsert(want[i])
+	}
+	//用Less比较，reflect.DeepEqual认为NaN != NaN
+	got := all(tr)
+	if len(got) != len(want) {
+		t.Fatalf("%s:\n got: %v\nwant: %v", name, got, want)
+	}
+	for i := range got {
+		if got[i].Less(want[i]) || want[i].Less(got[i]) {
+			t.Fatalf("%s:\n got: %v\nwant: %v", name, got, want)
+		}
+	}
+}
+
+func TestBuiltinTypesOrder(t *testing.T) {
+	assertOrdered(t, "String", []Item{String(""), String("a"), String("ab"), String("b")})
+	assertOrdered(t, "Bytes", []Item{Bytes{}, Bytes{0}, Bytes{0, 1}, Bytes{1}, Bytes{0xff}})
+	assertOrdered(t, "Int64", []Item{Int64(math.MinInt64), Int64(-1), Int64(0), Int64(math.MaxInt64)})
+	assertOrdered(t, "Uint64", []Item{Uint64(0), Uint64(1), Uint64(math.MaxUint64)})
+	assertOrdered(t, "Float64", []Item{
+		Float64(math.Inf(-1)), Float64(-math.MaxFloat64), Float64(-1), Float64(-math.SmallestNonzeroFloat64),
+		Float64(math.Copysign(0, -1)), Float64(0), Float64(math.SmallestNonzeroFloat64), Float64(1),
+		Float64(math.MaxFloat64), Float64(math.Inf(1)), Float64(math.NaN()),
+	})
+	now := time.Now()
+	assertOrdered(t, "Time", []Item{Time(now.Add(-time.Hour)), Time(now), Time(now.Add(time.Nanosecond))})
+	assertOrdered(t, "Tuple", []Item{
+		Tuple{},
+		Tuple{String("a")},
+		Tuple{String("a"), Int64(1)},
+		Tuple{String("a"), Int64(2)},
+		Tuple{String("a"), String("x")},
+		Tuple{String("b")},
+		Tuple{Bytes("b"), Tuple{Int(1)}},
+	})
+}
+
+func TestFloat64NaN(t *testing.T) {
+	tr := New(2)
+	tr.ReplaceOrInsert(Float64(math.NaN()))
+	if tr.ReplaceOrInsert(Float64(math.NaN())) == nil || tr.Len() != 1 {
+		t.Fatalf("NaN should replace NaN, len %d", tr.Len())
+	}
+	if !tr.Has(Float64(math.NaN())) {
+		t.Fatalf("NaN not found")
+	}
+	tr.ReplaceOrInsert(Float64(0))
+	if tr.Has(Float64(math.Copysign(0, -1))) {
+		t.Fatalf("-0 should not equal +0")
+	}
+}
+
+func TestTimeIgnoresLocation(t *testing.T) {
+	now := time.Now()
+	tr := New(2)
+	tr.ReplaceOrInsert(Time(now))
+	if !tr.Has(Time(now.UTC())) {
+		t.Fatalf("same instant in another location not found")
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	now := time.Now().Round(0)
+	for _, tc := range []struct {
+		codec Codec
+		items []Item
+	}{
+		{IntCodec{}, []Item{Int(0), Int(-5), Int(math.MaxInt32)}},
+		{StringCodec{}, []Item{String(""), String("héllo")}},
+		{BytesCodec{}, []Item{Bytes{}, Bytes{0, 1, 2}}},
+		{Int64Codec{}, []Item{Int64(math.MinInt64), Int64(0), Int64(math.MaxInt64)}},
+		{Uint64Codec{}, []Item{Uint64(0), Uint64(math.MaxUint64)}},
+		{Float64Codec{}, []Item{Float64(math.Copysign(0, -1)), Float64(math.Inf(1)), Float64(1.5)}},
+		{TimeCodec{}, []Item{Time(now), Time(now.UTC())}},
+		{TupleCodec{}, []Item{Tuple{}, Tuple{String("a"), Int64(-1), Float64(2), Bytes("x"), Time(now), Tuple{Uint64(3), Int(4)}}}},
+	} {
+		for _, item := range tc.items {
+			data, err := tc.codec.EncodeItem(item)
+			if err != nil {
+				t.Fatalf("%T encode %v: %v", tc.codec, item, err)
+			}
+			got, err := tc.codec.DecodeItem(data)
+			if err != nil {
+				t.Fatalf("%T decode %v: %v", tc.codec, item, err)
+			}
+			if got.Less(item) || item.Less(got) {
+				t.Fatalf("%T: got %v, want %v", tc.codec, got, item)
+			}
+		}
+	}
+	nan, _ := Float64Codec{}.EncodeItem(Float64(math.NaN()))
+	if got, _ := (Float64Codec{}).DecodeItem(nan); !math.IsNaN(float64(got.(Float64))) {
+		t.Fatalf("NaN round trip: got %v", got)
+	}
+	if _, err := (TupleCodec{}).EncodeItem(Tuple{struct{ Item }{}}); err != errUnsupportedTupleElem {
+		t.Fatalf("unsupported element: got %v", err)
+	}
+	if _, err := (TupleCodec{}).DecodeItem([]byte{rankInt, 5, 0}); err != errShortBuffer {
+		t.Fatalf("short tuple: got %v", err)
+	}
+}
+
+//typedItems按随机顺序返回每种内置类型的n个不同的item
+func typedItems(n int) map[string][]Item {
+	out := map[string][]Item{}
+	base := time.Now()
+	for _, v := range rand.Perm(n) {
+		out["Int"] = append(out["Int"], Int(v))
+		out["Int64"] = append(out["Int64"], Int64(v))
+		out["Uint64"] = append(out["Uint64"], Uint64(v))
+		out["Float64"] = append(out["Float64"], Float64(v))
+		out["String"] = append(out["String"], String(fmt.Sprintf("key-%08d", v)))
+		out["Bytes"] = append(out["Bytes"], Bytes(fmt.Sprintf("key-%08d", v)))
+		out["Time"] = append(out["Time"], Time(base.Add(time.Duration(v))))
+		out["Tuple"] = append(out["Tuple"], Tuple{String(fmt.Sprint(v % 10)), Int64(v)})
+	}
+	return out
+}
+
+var typeNames = []string{"Int", "Int64", "Uint64", "Float64", "String", "Bytes", "Time", "Tuple"}
+
+func BenchmarkInsertTypes(b *testing.B) {
+	items := typedItems(benchmarkTreeSize)
+	for _, name := range typeNames {
+		insertP := items[name]
+		b.Run(name, func(b *testing.B) {
+			i := 0
+			for i < b.N {
+				tr := New(*btreeDegree)
+				for _, item := range insertP {
+					tr.ReplaceOrInsert(item)
+					i++
+					if i >= b.N {
+						return
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetTypes(b *testing.B) {
+	items := typedItems(benchmarkTreeSize)
+	for _, name := range typeNames {
+		insertP := items[name]
+		b.Run(name, func(b *testing.B) {
+			tr := New(*btreeDegree)
+			for _, item := range insertP {
+				tr.ReplaceOrInsert(item)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.Get(insertP[i%len(insertP)])
+			}
+		})
+	}
+}